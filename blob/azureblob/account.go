@@ -0,0 +1,138 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+)
+
+// StorageAccountInfo describes the storage account of a bucket.
+type StorageAccountInfo struct {
+	// SKUName is the SKU of the account, e.g. azblob.SkuNameStandardLRS.
+	// Premium SKUs don't support the Cool and Archive tiers.
+	SKUName azblob.SkuNameType
+	// Kind is the kind of the account, e.g. azblob.AccountKindStorageV2.
+	// Legacy azblob.AccountKindStorage accounts don't support access tiers.
+	Kind azblob.AccountKindType
+	// HierarchicalNamespace is true if the account has hierarchical
+	// namespace enabled; see IsHierarchicalNamespaceEnabled.
+	HierarchicalNamespace bool
+}
+
+// AccountInfo returns the SKU and kind of the storage account of the
+// bucket, so that operations that depend on them, like changing access
+// tiers, can be skipped for accounts that don't support them.
+func AccountInfo(ctx context.Context, b *blob.Bucket) (*StorageAccountInfo, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := drv.containerURL.GetAccountInfo(ctx)
+	if err != nil {
+		return nil, drv.wrapError(err, "")
+	}
+	return &StorageAccountInfo{
+		SKUName: resp.SkuName(),
+		Kind:    resp.AccountKind(),
+		// azblob doesn't expose this header.
+		HierarchicalNamespace: resp.Response().Header.Get("x-ms-is-hns-enabled") == "true",
+	}, nil
+}
+
+// IsHierarchicalNamespaceEnabled reports whether the storage account of the
+// bucket has hierarchical namespace enabled, i.e., is an Azure Data Lake
+// Storage Gen2 account. In such accounts directories are real objects, and
+// renaming a directory is atomic.
+func IsHierarchicalNamespaceEnabled(ctx context.Context, b *blob.Bucket) (bool, error) {
+	info, err := AccountInfo(ctx, b)
+	if err != nil {
+		return false, err
+	}
+	return info.HierarchicalNamespace, nil
+}
+
+// ServiceProperties returns the Blob service properties of the storage
+// account of the bucket: its logging, metrics, CORS, soft delete retention,
+// and static website settings. Reading them requires account-level
+// permissions, e.g. a shared key credential or an account SAS.
+func ServiceProperties(ctx context.Context, b *blob.Bucket) (*azblob.StorageServiceProperties, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return nil, err
+	}
+	props, err := drv.serviceURL.GetProperties(ctx)
+	if err != nil {
+		return nil, drv.wrapError(err, "")
+	}
+	return props, nil
+}
+
+// CORSRules returns the CORS rules of the Blob service of the storage
+// account of the bucket; see ServiceProperties.
+func CORSRules(ctx context.Context, b *blob.Bucket) ([]azblob.CorsRule, error) {
+	props, err := ServiceProperties(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return props.Cors, nil
+}
+
+// corsProperties is the body of a Set Blob Service Properties request that
+// only sets the CORS rules. azblob omits an empty list of rules, which
+// leaves the existing rules in place instead of removing them.
+type corsProperties struct {
+	XMLName xml.Name `xml:"StorageServiceProperties"`
+	Cors    struct {
+		Rules []azblob.CorsRule `xml:"CorsRule"`
+	} `xml:"Cors"`
+}
+
+// SetCORSRules replaces the CORS rules of the Blob service of the storage
+// account of the bucket with rules, e.g. to allow browsers to upload to
+// signed URLs directly. An empty rules removes all of them. The other
+// service properties are left unchanged.
+//
+// The rules apply to every container in the account. Azure allows at most
+// five rules, and setting them requires account-level permissions.
+func SetCORSRules(ctx context.Context, b *blob.Bucket, rules []azblob.CorsRule) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	var props corsProperties
+	props.Cors.Rules = rules
+	body, err := xml.Marshal(props)
+	if err != nil {
+		return err
+	}
+	u := drv.serviceURL.URL()
+	q := u.Query()
+	q.Set("restype", "service")
+	q.Set("comp", "properties")
+	u.RawQuery = q.Encode()
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := drv.doRaw(ctx, http.MethodPut, u, header, bytes.NewReader(body))
+	if err != nil {
+		return drv.wrapError(err, "")
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsHierarchicalNamespaceEnabled(t *testing.T) {
+	for _, want := range []bool{false, true} {
+		b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+			if q := r.URL.Query(); q.Get("restype") != "account" || q.Get("comp") != "properties" {
+				t.Errorf("got query %q, want restype=account&comp=properties", r.URL.RawQuery)
+			}
+			w.Header().Set("x-ms-sku-name", "Standard_LRS")
+			w.Header().Set("x-ms-account-kind", "StorageV2")
+			if want {
+				w.Header().Set("x-ms-is-hns-enabled", "true")
+			}
+		})
+		got, err := IsHierarchicalNamespaceEnabled(context.Background(), b)
+		done()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	}
+}
+
+func TestAccountInfo(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-sku-name", "Premium_LRS")
+		w.Header().Set("x-ms-account-kind", "BlockBlobStorage")
+	})
+	defer done()
+	got, err := AccountInfo(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &StorageAccountInfo{SKUName: azblob.SkuNamePremiumLRS, Kind: azblob.AccountKindBlockBlobStorage}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestServiceProperties(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Get("restype") != "service" || q.Get("comp") != "properties" {
+			t.Errorf("got query %q, want restype=service&comp=properties", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><StorageServiceProperties>`+
+			`<DeleteRetentionPolicy><Enabled>true</Enabled><Days>7</Days></DeleteRetentionPolicy>`+
+			`<Cors><CorsRule><AllowedOrigins>https://example.com</AllowedOrigins><AllowedMethods>PUT</AllowedMethods><AllowedHeaders>*</AllowedHeaders><ExposedHeaders /><MaxAgeInSeconds>60</MaxAgeInSeconds></CorsRule></Cors>`+
+			`</StorageServiceProperties>`)
+	})
+	defer done()
+	got, err := ServiceProperties(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := got.DeleteRetentionPolicy; p == nil || !p.Enabled || p.Days == nil || *p.Days != 7 {
+		t.Errorf("got DeleteRetentionPolicy %+v want enabled for 7 days", p)
+	}
+	want := []azblob.CorsRule{{AllowedOrigins: "https://example.com", AllowedMethods: "PUT", AllowedHeaders: "*", MaxAgeInSeconds: 60}}
+	if diff := cmp.Diff(got.Cors, want); diff != "" {
+		t.Errorf("Cors diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSetCORSRules(t *testing.T) {
+	tests := []struct {
+		description string
+		rules       []azblob.CorsRule
+		want        string
+	}{
+		{
+			description: "rules",
+			rules:       []azblob.CorsRule{{AllowedOrigins: "*", AllowedMethods: "PUT,OPTIONS", MaxAgeInSeconds: 60}},
+			want:        `<StorageServiceProperties><Cors><CorsRule><AllowedOrigins>*</AllowedOrigins><AllowedMethods>PUT,OPTIONS</AllowedMethods><AllowedHeaders></AllowedHeaders><ExposedHeaders></ExposedHeaders><MaxAgeInSeconds>60</MaxAgeInSeconds></CorsRule></Cors></StorageServiceProperties>`,
+		},
+		{
+			description: "no rules",
+			want:        `<StorageServiceProperties><Cors></Cors></StorageServiceProperties>`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var got string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				if q := r.URL.Query(); r.Method != http.MethodPut || q.Get("restype") != "service" || q.Get("comp") != "properties" {
+					t.Errorf("got %s %q, want PUT restype=service&comp=properties", r.Method, r.URL.RawQuery)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				got = string(body)
+				w.WriteHeader(http.StatusAccepted)
+			})
+			defer done()
+			if err := SetCORSRules(context.Background(), b, test.rules); err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got body %s want %s", got, test.want)
+			}
+		})
+	}
+}
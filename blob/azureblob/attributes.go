@@ -0,0 +1,140 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+)
+
+// BlobTypeInfo describes the type of a blob.
+type BlobTypeInfo struct {
+	// Type is the type of the blob, e.g. azblob.BlobAppendBlob.
+	Type azblob.BlobType
+	// CommittedBlockCount is the number of blocks appended to an append
+	// blob. It is 0 for other blob types.
+	CommittedBlockCount int32
+	// AppendOffset is the offset at which the next block appended to an
+	// append blob will be written, i.e., its current length. It is 0 for
+	// other blob types.
+	AppendOffset int64
+	// SequenceNumber is the sequence number of a page blob. It is 0 for
+	// other blob types.
+	SequenceNumber int64
+}
+
+// AttributesBlobType returns the type of the blob described by attrs, as
+// returned by blob.Bucket.Attributes. It returns false if attrs doesn't come
+// from this package.
+func AttributesBlobType(attrs *blob.Attributes) (*BlobTypeInfo, bool) {
+	var props azblob.BlobGetPropertiesResponse
+	if !attrs.As(&props) {
+		return nil, false
+	}
+	info := &BlobTypeInfo{Type: props.BlobType()}
+	if info.Type == azblob.BlobAppendBlob {
+		info.AppendOffset = attrs.Size
+	}
+	// The SDK reports absent headers as -1.
+	if n := props.BlobCommittedBlockCount(); n > 0 {
+		info.CommittedBlockCount = n
+	}
+	if n := props.BlobSequenceNumber(); n > 0 {
+		info.SequenceNumber = n
+	}
+	return info, true
+}
+
+// Size returns the size in bytes of the blob at key. It makes the same
+// request as blob.Bucket.Attributes, but skips decoding the rest of the
+// blob's properties and metadata.
+func Size(ctx context.Context, b *blob.Bucket, key string) (int64, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return 0, err
+	}
+	blobURL := drv.containerURL.NewBlobURL(drv.blobName(key))
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return 0, drv.wrapError(err, key)
+	}
+	return props.ContentLength(), nil
+}
+
+// AttributesBatchOptions sets options for AttributesBatch.
+type AttributesBatchOptions struct {
+	// Parallelism is the maximum number of requests issued concurrently.
+	// Defaults to 16.
+	Parallelism int
+}
+
+// AttributesResult is the result of AttributesBatch for one key.
+type AttributesResult struct {
+	// Attributes is the result of blob.Bucket.Attributes, or nil if Err is
+	// set.
+	Attributes *blob.Attributes
+	// Err is the error from blob.Bucket.Attributes, if any. Use
+	// gcerrors.Code to tell missing blobs (gcerrors.NotFound) from other
+	// failures.
+	Err error
+}
+
+// AttributesBatch calls blob.Bucket.Attributes for each of keys, issuing up
+// to opts.Parallelism requests at a time, and returns the results by key.
+// Azure has no request that gets the properties of several blobs, other than
+// listing them, so each key costs its own request. opts may be nil.
+//
+// The returned map has an entry for every key; a failure for one key
+// doesn't stop the others.
+func AttributesBatch(ctx context.Context, b *blob.Bucket, keys []string, opts *AttributesBatchOptions) map[string]*AttributesResult {
+	parallelism := defaultPrefixParallelism
+	if opts != nil && opts.Parallelism > 0 {
+		parallelism = opts.Parallelism
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]*AttributesResult, len(keys))
+		sem     = make(chan struct{}, parallelism)
+	)
+	for _, key := range keys {
+		mu.Lock()
+		_, seen := results[key]
+		if !seen {
+			results[key] = nil
+		}
+		mu.Unlock()
+		if seen {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			attrs, err := b.Attributes(ctx, key)
+			mu.Lock()
+			results[key] = &AttributesResult{Attributes: attrs, Err: err}
+			mu.Unlock()
+		}(key)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/gcerrors"
+)
+
+func TestAttributesBlobType(t *testing.T) {
+	tests := []struct {
+		description string
+		header      map[string]string
+		want        *BlobTypeInfo
+	}{
+		{
+			description: "append blob",
+			header:      map[string]string{"x-ms-blob-type": "AppendBlob", "x-ms-blob-committed-block-count": "7", "Content-Length": "70"},
+			want:        &BlobTypeInfo{Type: azblob.BlobAppendBlob, CommittedBlockCount: 7, AppendOffset: 70},
+		},
+		{
+			description: "page blob",
+			header:      map[string]string{"x-ms-blob-type": "PageBlob", "x-ms-blob-sequence-number": "42"},
+			want:        &BlobTypeInfo{Type: azblob.BlobPageBlob, SequenceNumber: 42},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range test.header {
+					w.Header().Set(k, v)
+				}
+			})
+			defer done()
+			attrs, err := b.Attributes(context.Background(), "my-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := AttributesBlobType(attrs)
+			if !ok {
+				t.Fatal("AttributesBlobType failed")
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("AttributesBlobType diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSize(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("got method %s want HEAD", r.Method)
+		}
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "42")
+	})
+	defer done()
+
+	got, err := Size(context.Background(), b, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 42 {
+		t.Errorf("got size %d want 42", got)
+	}
+	if _, err := Size(context.Background(), b, "missing"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v want NotFound", err)
+	}
+}
+
+func TestAttributesBatch(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight, requests := 0, 0, 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "5")
+	})
+	defer done()
+
+	keys := []string{"a", "b", "missing", "c", "a"}
+	got := AttributesBatch(context.Background(), b, keys, &AttributesBatchOptions{Parallelism: 2})
+	if len(got) != 4 {
+		t.Fatalf("got %d results want 4", len(got))
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if res := got[key]; res == nil || res.Err != nil || res.Attributes.Size != 5 {
+			t.Errorf("%s: got %+v want attributes of size 5", key, res)
+		}
+	}
+	if res := got["missing"]; res == nil || gcerrors.Code(res.Err) != gcerrors.NotFound {
+		t.Errorf("missing: got %+v want a NotFound error", res)
+	}
+	if requests != 4 {
+		t.Errorf("got %d requests want 4", requests)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("got %d concurrent requests want at most 2", maxInFlight)
+	}
+}
@@ -17,7 +17,7 @@
 //
 // NOTE: SignedURLs for PUT created with this package are not fully portable;
 // they will not work unless the PUT request includes a "x-ms-blob-type" header
-// set to "BlockBlob".
+// set to "BlockBlob". SignedURLHeaders returns the headers to send.
 // See https://stackoverflow.com/questions/37824136/put-on-sas-blob-url-without-specifying-x-ms-blob-type-header.
 //
 // URLs
@@ -25,12 +25,15 @@
 // For blob.OpenBucket, azureblob registers for the scheme "azblob".
 // The default URL opener will use credentials from the environment variables
 // AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY, and AZURE_STORAGE_SAS_TOKEN.
-// AZURE_STORAGE_ACCOUNT is required, along with one of the other two.
+// AZURE_STORAGE_ACCOUNT is required, along with one of the other two, unless
+// the URL sets "anon=true" to access a public container without credentials.
 // AZURE_STORAGE_DOMAIN can optionally be used to provide an Azure Environment
 // blob storage domain to use. If no AZURE_STORAGE_DOMAIN is provided, the
 // default Azure public domain "blob.core.windows.net" will be used. Check
 // the Azure Developer Guide for your particular cloud environment to see
 // the proper blob storage domain name to provide.
+// AZURE_STORAGE_APPLICATION_ID can optionally be used to provide an
+// application ID that is added to the User-Agent of each request.
 // To customize the URL opener, or for more details on the URL format,
 // see URLOpener.
 // See https://gocloud.dev/concepts/urls/ for background information.
@@ -42,7 +45,8 @@
 // (during reads). The following escapes are performed for azureblob:
 //  - Blob keys: ASCII characters 0-31, 92 ("\"), and 127 are escaped to
 //    "__0x<hex>__". Additionally, the "/" in "../" and a trailing "/" in a
-//    key (e.g., "foo/") are escaped in the same way.
+//    key (e.g., "foo/") are escaped in the same way. EscapeKey and UnescapeKey
+//    convert between keys and blob names.
 //  - Metadata keys: Per https://docs.microsoft.com/en-us/azure/storage/blobs/storage-properties-metadata,
 //    Azure only allows C# identifiers as metadata keys. Therefore, characters
 //    other than "[a-z][A-z][0-9]_" are escaped using "__0x<hex>__". In addition,
@@ -50,26 +54,37 @@
 //    URL encoding would not work since "%" is not valid.
 //  - Metadata values: Escaped using URL encoding.
 //
+// Options.RawKeys disables the escaping of blob keys.
+//
 // As
 //
 // azureblob exposes the following types for As:
-//  - Bucket: *azblob.ContainerURL
+//  - Bucket: *azblob.ContainerURL, *azblob.ServiceURL
 //  - Error: azblob.StorageError
 //  - ListObject: azblob.BlobItemInternal for objects, azblob.BlobPrefix for "directories"
 //  - ListOptions.BeforeList: *azblob.ListBlobsSegmentOptions
-//  - Reader: azblob.DownloadResponse
-//  - Reader.BeforeRead: *azblob.BlockBlobURL, *azblob.BlobAccessConditions
-//  - Attributes: azblob.BlobGetPropertiesResponse
-//  - CopyOptions.BeforeCopy: azblob.Metadata, *azblob.ModifiedAccessConditions, *azblob.BlobAccessConditions
+//  - Reader: azblob.DownloadResponse, except for reads starting at or beyond
+//    the end of the blob, which return no data; ContentRange (see
+//    ReaderContentRange)
+//  - Reader.BeforeRead: *azblob.BlockBlobURL, *azblob.BlobAccessConditions, *azblob.RetryReaderOptions
+//  - Attributes: azblob.BlobGetPropertiesResponse, and azblob.BlobTags if the
+//    context was from WithAttributesTags
+//  - CopyOptions.BeforeCopy: azblob.Metadata, *azblob.ModifiedAccessConditions
+//    (for the source), *azblob.BlobAccessConditions (for the destination),
+//    *MetadataDirective, azblob.BlobTagsMap, *TagDirective,
+//    *azblob.AccessTierType (the tier of the destination, set in the copy
+//    request itself; see CopyToRehydrate)
 //  - WriterOptions.BeforeWrite: *azblob.UploadStreamToBlockBlobOptions
 //  - SignedURLOptions.BeforeSign: *azblob.BlobSASSignatureValues
 package azureblob
 
 import (
 	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -88,16 +103,43 @@ import (
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/driver"
 	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/semaphore"
 
 	"gocloud.dev/internal/escape"
 	"gocloud.dev/internal/gcerr"
-	"gocloud.dev/internal/useragent"
 )
 
 const (
 	tokenRefreshTolerance = 300
 )
 
+// ErrNoAccountName is returned (possibly wrapped) by OpenBucket when no
+// Azure storage account name was provided, and by DefaultAccountName and
+// the default URL opener when AZURE_STORAGE_ACCOUNT is not set. Use
+// errors.Is to detect it.
+var ErrNoAccountName = errors.New("azureblob: account name is required")
+
+// ErrNoSharedKeyCredential is returned (possibly wrapped) by SignedURL when
+// the bucket has no credential that can sign a SAS, for example when it was
+// opened with a SAS token or an Azure AD token. Use errors.Is to detect it.
+// To sign URLs in that case, obtain a user delegation credential with
+// azblob.ServiceURL.GetUserDelegationCredential and set it as
+// Options.Credential.
+var ErrNoSharedKeyCredential = errors.New("azureblob: SignedURL requires a shared key or user delegation credential")
+
+// ErrUnsupportedMethod is returned (possibly wrapped) by SignedURL,
+// SignedHeadURL and SignedDirectoryURL when asked to sign a URL for an HTTP
+// method that they can't grant. Use errors.Is to detect it.
+// Its error code is gcerrors.Unimplemented.
+var ErrUnsupportedMethod = errors.New("azureblob: unsupported SignedURL method")
+
+// ErrNotModified is returned (possibly wrapped) by AttributesIfModifiedSince
+// and AttributesIfNoneMatch when the blob has not been modified. Use errors.Is to detect it.
+// It is also returned by reads whose BeforeRead sets an IfModifiedSince or
+// IfNoneMatch condition that isn't met.
+// Its error code is gcerrors.FailedPrecondition.
+var ErrNotModified = errors.New("azureblob: blob not modified")
+
 // Options sets options for constructing a *blob.Bucket backed by Azure Block Blob.
 type Options struct {
 	// Credential represents the authorizer for SignedURL.
@@ -131,6 +173,79 @@ type Options struct {
 	// The full URL used is "<Protocol>://<account name>.<StorageDomain>", where the
 	// "<account name>." part is dropped if IsCDN is set to true.
 	IsCDN bool
+
+	// MaxUploadBufferMemory, if positive, bounds the total memory used for
+	// upload buffers by all writers of the bucket combined.
+	//
+	// While uploading, each writer uses up to BufferSize * MaxBuffers bytes of
+	// buffers (see azblob.UploadStreamToBlockBlobOptions); with the defaults
+	// that is 8 MiB * 5 = 40 MiB per writer. When MaxUploadBufferMemory is set,
+	// a writer reserves that amount before it starts uploading and releases it
+	// when the upload completes, waiting for other writers to finish if the
+	// limit would be exceeded. A writer whose buffers alone exceed the limit
+	// reserves the whole limit.
+	MaxUploadBufferMemory int64
+
+	// ReadAhead, if positive, makes readers fetch up to ReadAhead chunks of
+	// ReadAheadSize bytes concurrently, ahead of the data being read, instead
+	// of reading the blob in a single request. This speeds up sequential
+	// reads of large blobs over high-latency links, at the cost of up to
+	// (ReadAhead + 2) * ReadAheadSize bytes of memory per reader: ReadAhead
+	// queued chunks, plus the one being received, plus the one being read.
+	ReadAhead int
+
+	// ReadAheadSize is the size of the chunks fetched with ReadAhead.
+	// Defaults to 4 MiB.
+	ReadAheadSize int64
+
+	// AccessTier, if set, is the access tier of the blobs written through the
+	// bucket, e.g. azblob.AccessTierCool, including the destinations of
+	// copies (Copy and CopyFromURL). Writes and copies use the account's
+	// default tier if it is not set. BeforeWrite can override it per write by
+	// setting azblob.UploadStreamToBlockBlobOptions.BlobAccessTier, and
+	// BeforeCopy per copy by setting the *azblob.AccessTierType it exposes.
+	AccessTier azblob.AccessTierType
+
+	// DirectoryPlaceholder, if set, is the suffix of the names of the
+	// zero-byte blobs that represent empty directories, as created by other
+	// tools: "/" for blobs named like "dir/", or e.g. "/.keep" for blobs
+	// named like "dir/.keep". See CreateDirectoryPlaceholder and
+	// IsDirectoryPlaceholder. When it is "/", keys ending in "/" are not
+	// escaped, so that such blobs can be read, written, copied, and deleted
+	// by their names.
+	DirectoryPlaceholder string
+
+	// WriteBufferSize, if positive, makes writers buffer up to
+	// WriteBufferSize bytes of the data written to them, which are handed
+	// to the upload in the background, so that Write only blocks while the
+	// buffer is full. Without it, each Write blocks until the upload has
+	// read all of its data.
+	WriteBufferSize int
+
+	// UploadBlockSize, if positive, is the size of the blocks uploaded by
+	// writers whose blob.WriterOptions.BufferSize is not set, instead of
+	// 8 MiB. Larger blocks mean fewer requests for large blobs, at the cost
+	// of more memory per writer.
+	UploadBlockSize int
+
+	// UploadBuffers, if positive, is the number of blocks each writer
+	// uploads concurrently, instead of 5.
+	UploadBuffers int
+
+	// CheckContainer makes OpenBucket check that the container exists,
+	// with a request, so that a misconfigured container name fails at
+	// startup instead of at the first operation. If it doesn't, the error
+	// code is gcerrors.NotFound.
+	CheckContainer bool
+
+	// RawKeys disables the escaping of keys described in the package
+	// documentation, so that keys are used as blob names as is, and blob
+	// names are returned by List as keys as is. It applies consistently to
+	// all operations of the bucket and of the functions of this package, so
+	// that keys round-trip. It is meant for containers shared with tools that
+	// don't escape names; keys Azure can't handle, such as keys ending in
+	// "/" or containing a backslash, then fail or address another blob.
+	RawKeys bool
 }
 
 const (
@@ -138,6 +253,11 @@ const (
 	defaultPageSize                 = 1000            // default page size for ListPaged (Azure default is 5000)
 	defaultUploadBuffers            = 5               // configure the number of rotating buffers that are used when uploading (for degree of parallelism)
 	defaultUploadBlockSize          = 8 * 1024 * 1024 // configure the upload buffer size
+	defaultCopyPollInterval         = 500 * time.Millisecond
+	defaultRehydratePollInterval    = time.Minute // rehydration from Archive takes hours
+	defaultReadAheadSize            = 4 * 1024 * 1024
+	delegationKeyValidity           = 48 * time.Hour // of the user delegation keys obtained with MSI
+	maxDelegationKeyValidity        = 7 * 24 * time.Hour
 )
 
 func init() {
@@ -157,25 +277,40 @@ type lazyCredsOpener struct {
 	init   sync.Once
 	opener *URLOpener
 	err    error
+
+	anonInit   sync.Once
+	anonOpener *URLOpener
+	anonErr    error
 }
 
 func (o *lazyCredsOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	anon, err := isAnonURL(u.Query())
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %v", u, err)
+	}
+	if anon {
+		// Don't look for credentials; only the account name is needed.
+		o.anonInit.Do(func() {
+			accountName, _ := DefaultAccountName()
+			o.anonOpener, o.anonErr = openerFromAnon(accountName, optionsFromEnv())
+		})
+		if o.anonErr != nil {
+			return nil, fmt.Errorf("open bucket %v: %w", u, o.anonErr)
+		}
+		return o.anonOpener.OpenBucketURL(ctx, u)
+	}
 	o.init.Do(func() {
 		// Use default credential info from the environment.
-		// Ignore errors, as we'll get errors from OpenBucket later.
-		accountName, _ := DefaultAccountName()
+		var accountName AccountName
+		if accountName, o.err = DefaultAccountName(); o.err != nil {
+			return
+		}
+		// Ignore other errors, as we'll get errors from OpenBucket later.
 		accountKey, _ := DefaultAccountKey()
 		sasToken, _ := DefaultSASToken()
-		storageDomain, _ := DefaultStorageDomain()
-		isCDN, _ := DefaultIsCDN()
-		protocol, _ := DefaultProtocol()
 
 		isMSIEnvironment := adal.MSIAvailable(ctx, adal.CreateSender())
-		opts := Options{
-			StorageDomain: storageDomain,
-			Protocol:      protocol,
-			IsCDN:         isCDN,
-		}
+		opts := optionsFromEnv()
 
 		if accountKey != "" || sasToken != "" {
 			o.opener, o.err = openerFromEnv(accountName, accountKey, sasToken, opts)
@@ -186,11 +321,50 @@ func (o *lazyCredsOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.
 		}
 	})
 	if o.err != nil {
-		return nil, fmt.Errorf("open bucket %v: %v", u, o.err)
+		return nil, fmt.Errorf("open bucket %v: %w", u, o.err)
 	}
 	return o.opener.OpenBucketURL(ctx, u)
 }
 
+// optionsFromEnv returns the Options set by environment variables, ignoring
+// invalid values.
+func optionsFromEnv() Options {
+	storageDomain, _ := DefaultStorageDomain()
+	isCDN, _ := DefaultIsCDN()
+	protocol, _ := DefaultProtocol()
+	return Options{
+		StorageDomain: storageDomain,
+		Protocol:      protocol,
+		IsCDN:         isCDN,
+	}
+}
+
+// pipelineOptionsFromEnv returns the azblob.PipelineOptions set by
+// environment variables.
+func pipelineOptionsFromEnv() (azblob.PipelineOptions, error) {
+	var opts azblob.PipelineOptions
+	appID, err := DefaultApplicationID()
+	if err != nil {
+		return opts, err
+	}
+	if appID != "" {
+		opts.Telemetry.Value = " " + appID
+	}
+	return opts, nil
+}
+
+// isAnonURL reports whether the "anon" query parameter in q is set to true.
+func isAnonURL(q url.Values) (bool, error) {
+	values, ok := q["anon"]
+	if !ok {
+		return false, nil
+	}
+	if len(values) > 1 {
+		return false, errors.New("multiple values of anon not allowed")
+	}
+	return strconv.ParseBool(values[0])
+}
+
 // Scheme is the URL scheme gcsblob registers its URLOpener under on
 // blob.DefaultMux.
 const Scheme = "azblob"
@@ -203,6 +377,22 @@ const Scheme = "azblob"
 //  - domain: The domain name used to access the Azure Blob storage (e.g. blob.core.windows.net)
 //  - protocol: The protocol to use (e.g., http or https; default to https)
 //  - cdn: Set to true when domain represents a CDN
+//  - anon: Set to true to access the container without credentials, e.g.
+//    for public containers. Requests are sent unauthenticated, ignoring
+//    Pipeline's credential, Options.Credential, and Options.SASToken, with
+//    a pipeline created from PipelineOptions.
+//  - tier: The access tier of written and copied blobs (Hot, Cool, or
+//    Archive); see Options.AccessTier.
+//  - dir_placeholder: The suffix of directory placeholder blobs; see
+//    Options.DirectoryPlaceholder.
+//  - block_size: The size of uploaded blocks in bytes; see
+//    Options.UploadBlockSize.
+//  - max_buffers: The number of blocks uploaded concurrently by each
+//    writer; see Options.UploadBuffers.
+//  - check_container: Set to true to check that the container exists when
+//    opening the bucket; see Options.CheckContainer.
+//  - raw_keys: Set to true to use keys as blob names without escaping them;
+//    see Options.RawKeys.
 //
 // See Options for more details.
 type URLOpener struct {
@@ -212,11 +402,38 @@ type URLOpener struct {
 	// Pipeline must be set to a non-nil value.
 	Pipeline pipeline.Pipeline
 
+	// PipelineOptions are the options Pipeline was created with. They are
+	// used to create the pipeline that sends the requests of URLs with
+	// anon=true, since Pipeline's credential can't be removed from it.
+	PipelineOptions azblob.PipelineOptions
+
 	// Options specifies the options to pass to OpenBucket.
 	Options Options
 }
 
+// NewURLOpener returns a URLOpener for the storage account accountName that
+// authenticates with accountKey, or with sasToken if accountKey is empty. If
+// both are empty, requests are sent anonymously. Its pipeline is created
+// with pipelineOpts.
+//
+// Unlike the default URL opener, it doesn't read environment variables: it
+// takes the storage domain, protocol, and CDN setting from opts, and the
+// application ID from pipelineOpts, so openers for accounts in different
+// Azure clouds can be used side by side.
+// opts.Credential and opts.SASToken are overwritten.
+func NewURLOpener(accountName AccountName, accountKey AccountKey, sasToken SASToken, opts Options, pipelineOpts azblob.PipelineOptions) (*URLOpener, error) {
+	return newURLOpener(accountName, accountKey, sasToken, opts, pipelineOpts)
+}
+
 func openerFromEnv(accountName AccountName, accountKey AccountKey, sasToken SASToken, opts Options) (*URLOpener, error) {
+	popts, err := pipelineOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return newURLOpener(accountName, accountKey, sasToken, opts, popts)
+}
+
+func newURLOpener(accountName AccountName, accountKey AccountKey, sasToken SASToken, opts Options, popts azblob.PipelineOptions) (*URLOpener, error) {
 	// azblob.Credential is an interface; we will use either a SharedKeyCredential
 	// or anonymous credentials. If the former, we will also fill in
 	// Options.Credential so that SignedURL will work.
@@ -225,7 +442,7 @@ func openerFromEnv(accountName AccountName, accountKey AccountKey, sasToken SAST
 	if accountKey != "" {
 		sharedKeyCred, err := NewCredential(accountName, accountKey)
 		if err != nil {
-			return nil, fmt.Errorf("invalid credentials %s/%s: %v", accountName, accountKey, err)
+			return nil, fmt.Errorf("invalid credentials for account %s: %v", accountName, err)
 		}
 		credential = sharedKeyCred
 		storageAccountCredential = sharedKeyCred
@@ -235,18 +452,24 @@ func openerFromEnv(accountName AccountName, accountKey AccountKey, sasToken SAST
 	opts.Credential = storageAccountCredential
 	opts.SASToken = sasToken
 	return &URLOpener{
-		AccountName: accountName,
-		Pipeline:    NewPipeline(credential, azblob.PipelineOptions{}),
-		Options:     opts,
+		AccountName:     accountName,
+		Pipeline:        NewPipeline(credential, popts),
+		PipelineOptions: popts,
+		Options:         opts,
 	}, nil
 }
 
 // openerFromAnon creates an anonymous credential backend URLOpener
 func openerFromAnon(accountName AccountName, opts Options) (*URLOpener, error) {
+	popts, err := pipelineOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
 	return &URLOpener{
-		AccountName: accountName,
-		Pipeline:    NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}),
-		Options:     opts,
+		AccountName:     accountName,
+		Pipeline:        NewPipeline(azblob.NewAnonymousCredential(), popts),
+		PipelineOptions: popts,
+		Options:         opts,
 	}, nil
 }
 
@@ -279,10 +502,15 @@ func openerFromMSI(accountName AccountName, opts Options) (*URLOpener, error) {
 	}
 
 	credential := azblob.NewTokenCredential(spToken.Token().AccessToken, defaultTokenRefreshFunction(spToken))
+	popts, err := pipelineOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
 	return &URLOpener{
-		AccountName: accountName,
-		Pipeline:    NewPipeline(credential, azblob.PipelineOptions{}),
-		Options:     opts,
+		AccountName:     accountName,
+		Pipeline:        NewPipeline(credential, popts),
+		PipelineOptions: popts,
+		Options:         opts,
 	}, nil
 }
 
@@ -307,12 +535,44 @@ func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket
 	opts := new(Options)
 	*opts = o.Options
 
-	err := setOptionsFromURLParams(u.Query(), opts)
+	q := u.Query()
+	anon, err := isAnonURL(q)
+	if err != nil {
+		return nil, err
+	}
+	q.Del("anon")
+	err = setOptionsFromURLParams(q, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return OpenBucket(ctx, o.Pipeline, o.AccountName, u.Host, opts)
+	p := o.Pipeline
+	if anon {
+		p = NewPipeline(azblob.NewAnonymousCredential(), o.PipelineOptions)
+		opts.Credential = nil
+		opts.SASToken = ""
+	}
+	return OpenBucket(ctx, p, o.AccountName, u.Host, opts)
+}
+
+// parseAccessTier returns the block blob access tier named s, ignoring case.
+func parseAccessTier(s string) (azblob.AccessTierType, error) {
+	for _, tier := range []azblob.AccessTierType{azblob.AccessTierHot, azblob.AccessTierCool, azblob.AccessTierArchive} {
+		if strings.EqualFold(s, string(tier)) {
+			return tier, nil
+		}
+	}
+	return azblob.AccessTierNone, fmt.Errorf("invalid access tier %q", s)
+}
+
+// parsePositiveInt parses value, the value of the URL parameter param, as a
+// positive integer.
+func parsePositiveInt(param, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", param, value)
+	}
+	return n, nil
 }
 
 func setOptionsFromURLParams(q url.Values, o *Options) error {
@@ -333,6 +593,38 @@ func setOptionsFromURLParams(q url.Values, o *Options) error {
 				return err
 			}
 			o.IsCDN = isCDN
+		case "tier":
+			tier, err := parseAccessTier(value)
+			if err != nil {
+				return err
+			}
+			o.AccessTier = tier
+		case "dir_placeholder":
+			o.DirectoryPlaceholder = value
+		case "check_container":
+			check, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			o.CheckContainer = check
+		case "block_size":
+			n, err := parsePositiveInt(param, value)
+			if err != nil {
+				return err
+			}
+			o.UploadBlockSize = n
+		case "raw_keys":
+			raw, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			o.RawKeys = raw
+		case "max_buffers":
+			n, err := parsePositiveInt(param, value)
+			if err != nil {
+				return err
+			}
+			o.UploadBuffers = n
 		default:
 			return fmt.Errorf("unknown query parameter %q", param)
 		}
@@ -385,7 +677,7 @@ type Protocol string
 func DefaultAccountName() (AccountName, error) {
 	s := os.Getenv("AZURE_STORAGE_ACCOUNT")
 	if s == "" {
-		return "", errors.New("azureblob: environment variable AZURE_STORAGE_ACCOUNT not set")
+		return "", fmt.Errorf("%w: environment variable AZURE_STORAGE_ACCOUNT not set", ErrNoAccountName)
 	}
 	return AccountName(s), nil
 }
@@ -439,10 +731,28 @@ func NewCredential(accountName AccountName, accountKey AccountKey) (*azblob.Shar
 	return azblob.NewSharedKeyCredential(string(accountName), string(accountKey))
 }
 
+// DefaultApplicationID loads an application ID to add to the User-Agent of
+// requests from the AZURE_STORAGE_APPLICATION_ID environment variable.
+// It returns an error if the value contains characters that aren't allowed
+// in a User-Agent header, such as control or non-ASCII characters.
+func DefaultApplicationID() (string, error) {
+	s := os.Getenv("AZURE_STORAGE_APPLICATION_ID")
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			return "", fmt.Errorf("environment variable AZURE_STORAGE_APPLICATION_ID contains invalid character %q", r)
+		}
+	}
+	return s, nil
+}
+
 // NewPipeline creates a Pipeline for making HTTP requests to Azure.
+//
+// opts.Telemetry.Value, if set, is appended as is to this package's
+// application ID in the User-Agent of each request, so that Azure's logs and
+// metrics can distinguish the requests of different applications; start it
+// with a space to separate the two.
 func NewPipeline(credential azblob.Credential, opts azblob.PipelineOptions) pipeline.Pipeline {
-	opts.Telemetry.Value = useragent.AzureUserAgentPrefix("blob") + opts.Telemetry.Value
-	return azblob.NewPipeline(credential, opts)
+	return NewPipelineWithPolicies(credential, opts, PipelinePolicies{})
 }
 
 // bucket represents a Azure Storage Account Container, which handles read,
@@ -453,7 +763,10 @@ type bucket struct {
 	pageMarkers  map[string]azblob.Marker
 	serviceURL   *azblob.ServiceURL
 	containerURL azblob.ContainerURL
+	pipeline     pipeline.Pipeline
 	opts         *Options
+	uploadMem    *semaphore.Weighted // nil if Options.MaxUploadBufferMemory is not set
+	bufferPools  sync.Map            // upload buffer size -> *sync.Pool of buffers of that size
 
 	mu                    sync.Mutex // protect the fields below
 	credentialExpiration  time.Time
@@ -472,16 +785,81 @@ func OpenBucket(ctx context.Context, pipeline pipeline.Pipeline, accountName Acc
 	return blob.NewBucket(b), nil
 }
 
+// OpenBucketFromEndpoint is like OpenBucket, but takes the full URL of the
+// container, as shown in the Azure portal, instead of an account name and a
+// container name; for example
+// "https://myaccount.blob.core.windows.net/mycontainer". The account name,
+// container name, protocol, and storage domain are all derived from it, the
+// latter two overriding opts.Protocol and opts.StorageDomain. A query string
+// in the URL, such as an appended SAS token, is used as opts.SASToken.
+//
+// URLs of the local emulator, of the form
+// "http://127.0.0.1:10000/<account name>/<container name>", are supported
+// too. CDN URLs are not, since they don't include the account name; use
+// OpenBucket with Options.IsCDN for those.
+func OpenBucketFromEndpoint(ctx context.Context, pipeline pipeline.Pipeline, endpoint string, opts *Options) (*blob.Bucket, error) {
+	o := new(Options)
+	if opts != nil {
+		*o = *opts
+	}
+	accountName, containerName, err := parseContainerEndpoint(endpoint, o)
+	if err != nil {
+		return nil, fmt.Errorf("azureblob.OpenBucketFromEndpoint: %v", err)
+	}
+	return OpenBucket(ctx, pipeline, accountName, containerName, o)
+}
+
+// parseContainerEndpoint parses endpoint, the URL of a container, into an
+// account name and a container name, and sets the protocol, storage domain,
+// and SAS token in opts from it.
+func parseContainerEndpoint(endpoint string, opts *Options) (AccountName, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid endpoint %q: must be an absolute URL", endpoint)
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	var accountName AccountName
+	var domain string
+	if h := u.Hostname(); h == "127.0.0.1" || h == "localhost" {
+		// The local emulator has the account name in the path; see openBucket.
+		if len(path) != 2 {
+			return "", "", fmt.Errorf("invalid endpoint %q: want <protocol>://%s/<account name>/<container name>", endpoint, u.Host)
+		}
+		accountName, domain, path = AccountName(path[0]), u.Host, path[1:]
+	} else {
+		i := strings.IndexByte(u.Host, '.')
+		if i <= 0 {
+			return "", "", fmt.Errorf("invalid endpoint %q: host must be <account name>.<storage domain>", endpoint)
+		}
+		accountName, domain = AccountName(u.Host[:i]), u.Host[i+1:]
+	}
+	if len(path) != 1 || path[0] == "" {
+		return "", "", fmt.Errorf("invalid endpoint %q: path must be a container name", endpoint)
+	}
+	opts.Protocol = Protocol(u.Scheme)
+	opts.StorageDomain = StorageDomain(domain)
+	if u.RawQuery != "" {
+		opts.SASToken = SASToken(u.RawQuery)
+	}
+	return accountName, path[0], nil
+}
+
 func openBucket(ctx context.Context, pipeline pipeline.Pipeline, accountName AccountName, containerName string, opts *Options) (*bucket, error) {
 	if pipeline == nil {
 		return nil, errors.New("azureblob.OpenBucket: pipeline is required")
 	}
 	if accountName == "" {
-		return nil, errors.New("azureblob.OpenBucket: accountName is required")
+		return nil, ErrNoAccountName
 	}
 	if containerName == "" {
 		return nil, errors.New("azureblob.OpenBucket: containerName is required")
 	}
+	if err := validateContainerName(containerName); err != nil {
+		return nil, fmt.Errorf("azureblob.OpenBucket: %v", err)
+	}
 	if opts == nil {
 		opts = &Options{}
 	}
@@ -516,14 +894,55 @@ func openBucket(ctx context.Context, pipeline pipeline.Pipeline, accountName Acc
 		// don't want here.
 		blobURL.RawQuery = strings.TrimPrefix(string(opts.SASToken), "?")
 	}
+	pipeline = headerPipeline{pipeline}
 	serviceURL := azblob.NewServiceURL(*blobURL, pipeline)
-	return &bucket{
+	var uploadMem *semaphore.Weighted
+	if opts.MaxUploadBufferMemory > 0 {
+		uploadMem = semaphore.NewWeighted(opts.MaxUploadBufferMemory)
+	}
+	b := &bucket{
 		name:         containerName,
 		pageMarkers:  map[string]azblob.Marker{},
 		serviceURL:   &serviceURL,
 		containerURL: serviceURL.NewContainerURL(containerName),
+		pipeline:     pipeline,
 		opts:         opts,
-	}, nil
+		uploadMem:    uploadMem,
+	}
+	if opts.CheckContainer {
+		if _, err := b.containerURL.GetProperties(ctx, azblob.LeaseAccessConditions{}); err != nil {
+			return nil, gcerr.New(b.ErrorCode(err), err, 1, fmt.Sprintf("azureblob.OpenBucket: checking container %q", containerName))
+		}
+	}
+	return b, nil
+}
+
+// validateContainerName checks name against the Azure container naming rules.
+// See https://docs.microsoft.com/en-us/rest/api/storageservices/naming-and-referencing-containers--blobs--and-metadata#container-names.
+func validateContainerName(name string) error {
+	switch name {
+	case "$root", "$web", "$logs":
+		// Special containers that don't follow the usual rules.
+		return nil
+	}
+	if len(name) < 3 || len(name) > 63 {
+		return fmt.Errorf("invalid container name %q: must be between 3 and 63 characters long", name)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-':
+			if i == 0 || i == len(name)-1 {
+				return fmt.Errorf("invalid container name %q: must start and end with a letter or number", name)
+			}
+			if name[i-1] == '-' {
+				return fmt.Errorf("invalid container name %q: consecutive hyphens are not allowed", name)
+			}
+		default:
+			return fmt.Errorf("invalid container name %q: only lowercase letters, numbers, and hyphens are allowed", name)
+		}
+	}
+	return nil
 }
 
 // Close implements driver.Close.
@@ -533,26 +952,45 @@ func (b *bucket) Close() error {
 
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
-	dstKey = escapeKey(dstKey, false)
+	dstKey = b.blobName(dstKey)
 	dstBlobURL := b.containerURL.NewBlobURL(dstKey)
-	srcKey = escapeKey(srcKey, false)
+	srcKey = b.blobName(srcKey)
 	srcURL := b.containerURL.NewBlobURL(srcKey).URL()
 	md := azblob.Metadata{}
 	mac := azblob.ModifiedAccessConditions{}
 	bac := azblob.BlobAccessConditions{}
-	at := azblob.AccessTierNone
+	at := b.opts.AccessTier
+	directive := MetadataDirectiveCopy
+	tags := azblob.BlobTagsMap{}
+	var tagDirective TagDirective
+	var progress CopyProgress
 	if opts.BeforeCopy != nil {
 		asFunc := func(i interface{}) bool {
 			switch v := i.(type) {
 			case *azblob.Metadata:
 				*v = md
 				return true
+			case **MetadataDirective:
+				*v = &directive
+				return true
+			case *azblob.BlobTagsMap:
+				*v = tags
+				return true
+			case **TagDirective:
+				*v = &tagDirective
+				return true
+			case **azblob.AccessTierType:
+				*v = &at
+				return true
 			case **azblob.ModifiedAccessConditions:
 				*v = &mac
 				return true
 			case **azblob.BlobAccessConditions:
 				*v = &bac
 				return true
+			case **CopyProgress:
+				*v = &progress
+				return true
 			}
 			return false
 		}
@@ -560,16 +998,42 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 			return err
 		}
 	}
-	resp, err := dstBlobURL.StartCopyFromURL(ctx, srcURL, md, mac, bac, at, nil /* BlobTagsMap */)
+	var copyTags azblob.BlobTagsMap
+	if len(tags) > 0 {
+		copyTags = tags
+	}
+	resp, err := dstBlobURL.StartCopyFromURL(ctx, srcURL, md, mac, bac, at, copyTags)
 	if err != nil {
 		return err
 	}
-	copyStatus := resp.CopyStatus()
+	if err := waitForCopy(ctx, dstBlobURL, resp.CopyStatus(), defaultCopyPollInterval, progress); err != nil {
+		return err
+	}
+	if directive == MetadataDirectiveReplace && len(md) == 0 {
+		// Azure copies the source metadata if none is given, so clear it
+		// after the copy.
+		if _, err := dstBlobURL.SetMetadata(ctx, azblob.Metadata{}, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{}); err != nil {
+			return err
+		}
+	}
+	return b.applyTagDirective(ctx, dstBlobURL, srcKey, tagDirective, tags)
+}
+
+// waitForCopy polls blobURL every interval until the copy that was started
+// with the given initial status is no longer pending, reporting its progress
+// to progress if it is not nil.
+func waitForCopy(ctx context.Context, blobURL azblob.BlobURL, copyStatus azblob.CopyStatusType, interval time.Duration, progress CopyProgress) error {
 	nErrors := 0
 	for copyStatus == azblob.CopyStatusPending {
 		// Poll until the copy is complete.
-		time.Sleep(500 * time.Millisecond)
-		propertiesResp, err := dstBlobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		pollCtx, cancel := copyPollContext(ctx)
+		propertiesResp, err := blobURL.GetProperties(pollCtx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+		cancel()
 		if err != nil {
 			// A GetProperties failure may be transient, so allow a couple
 			// of them before giving up.
@@ -577,8 +1041,10 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 			if ctx.Err() != nil || nErrors == 3 {
 				return err
 			}
+			continue
 		}
 		copyStatus = propertiesResp.CopyStatus()
+		reportCopyProgress(progress, propertiesResp.CopyProgress())
 	}
 	if copyStatus != azblob.CopyStatusSuccess {
 		return fmt.Errorf("Copy failed with status: %s", copyStatus)
@@ -588,7 +1054,7 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 
 // Delete implements driver.Delete.
 func (b *bucket) Delete(ctx context.Context, key string) error {
-	key = escapeKey(key, false)
+	key = b.blobName(key)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	_, err := blockBlobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
 	return err
@@ -598,7 +1064,9 @@ func (b *bucket) Delete(ctx context.Context, key string) error {
 type reader struct {
 	body  io.ReadCloser
 	attrs driver.ReaderAttributes
-	raw   *azblob.DownloadResponse
+	raw   *azblob.DownloadResponse // nil for reads past the end of the blob
+	// contentRange is the range read, if Azure returned one.
+	contentRange *ContentRange
 }
 
 func (r *reader) Read(p []byte) (int, error) {
@@ -611,20 +1079,32 @@ func (r *reader) Attributes() *driver.ReaderAttributes {
 	return &r.attrs
 }
 func (r *reader) As(i interface{}) bool {
-	p, ok := i.(*azblob.DownloadResponse)
-	if !ok {
-		return false
+	switch p := i.(type) {
+	case *azblob.DownloadResponse:
+		if r.raw == nil {
+			return false
+		}
+		*p = *r.raw
+		return true
+	case *ContentRange:
+		if r.contentRange == nil {
+			return false
+		}
+		*p = *r.contentRange
+		return true
 	}
-	*p = *r.raw
-	return true
+	return false
 }
 
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
-	key = escapeKey(key, false)
+	start := time.Now()
+	key = b.blobName(key)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	blockBlobURLp := &blockBlobURL
 	accessConditions := &azblob.BlobAccessConditions{}
+	retryOpts := &azblob.RetryReaderOptions{MaxRetryRequests: defaultMaxDownloadRetryRequests}
+	settings := &readSettings{}
 
 	end := length
 	if end < 0 {
@@ -640,15 +1120,43 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 				*p = accessConditions
 				return true
 			}
+			if p, ok := i.(**azblob.RetryReaderOptions); ok {
+				*p = retryOpts
+				return true
+			}
+			if p, ok := i.(**readSettings); ok {
+				*p = settings
+				return true
+			}
 			return false
 		}
 		if err := opts.BeforeRead(asFunc); err != nil {
 			return nil, err
 		}
 	}
+	if settings.cacheBusting {
+		*blockBlobURLp = cacheBustURL(*blockBlobURLp, b.pipeline)
+	}
 
+	// With read-ahead, the first request only reads the first chunk; the
+	// rest is fetched by a prefetchReader.
+	chunkSize := b.readAheadSize()
+	readAhead := b.opts.ReadAhead > 0 && length != 0 && (end == azblob.CountToEnd || end > chunkSize)
+	if readAhead {
+		end = chunkSize
+	}
 	blobDownloadResponse, err := blockBlobURLp.Download(ctx, offset, end, *accessConditions, false, azblob.ClientProvidedKeyOptions{})
 	if err != nil {
+		if serr, ok := err.(azblob.StorageError); ok && serr.Response() != nil && serr.Response().StatusCode == http.StatusNotModified {
+			return nil, ErrNotModified
+		}
+		if r := b.readPastEnd(ctx, blockBlobURLp, offset, *accessConditions, err); r != nil {
+			return r, nil
+		}
+		return nil, err
+	}
+	if err := checkEncryption(settings.encryptionCheck, blobDownloadResponse.Response().Header); err != nil {
+		blobDownloadResponse.Response().Body.Close()
 		return nil, err
 	}
 	attrs := driver.ReaderAttributes{
@@ -657,18 +1165,63 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 		ModTime:     blobDownloadResponse.LastModified(),
 	}
 	var body io.ReadCloser
+	var contentRange *ContentRange
 	if length == 0 {
 		body = http.NoBody
 	} else {
-		body = blobDownloadResponse.Body(azblob.RetryReaderOptions{MaxRetryRequests: defaultMaxDownloadRetryRequests})
+		body = blobDownloadResponse.Body(*retryOpts)
+		if cr, ok := parseContentRange(blobDownloadResponse.ContentRange()); ok {
+			contentRange = &cr
+		}
+	}
+	if readAhead {
+		stop := attrs.Size
+		if length > 0 && offset+length < stop {
+			stop = offset + length
+		}
+		if next := offset + chunkSize; next < stop {
+			if contentRange != nil {
+				contentRange.End = stop - 1
+			}
+			// Make sure the remaining chunks come from the same version of
+			// the blob as the first one.
+			ac := *accessConditions
+			ac.ModifiedAccessConditions.IfMatch = blobDownloadResponse.ETag()
+			body = newPrefetchReader(ctx, blockBlobURLp, ac, *retryOpts, body, next, stop, chunkSize, b.opts.ReadAhead)
+		}
+	}
+	if settings.timingHooks != nil {
+		body = &timedBody{ReadCloser: body, hooks: settings.timingHooks, start: start}
 	}
 	return &reader{
-		body:  body,
-		attrs: attrs,
-		raw:   blobDownloadResponse,
+		body:         body,
+		attrs:        attrs,
+		raw:          blobDownloadResponse,
+		contentRange: contentRange,
 	}, nil
 }
 
+// readPastEnd returns an empty reader if err is the InvalidRange error Azure
+// returns for a read starting at or beyond the end of the blob, like reading
+// at the end of a file. Otherwise, it returns nil.
+func (b *bucket) readPastEnd(ctx context.Context, blockBlobURL *azblob.BlockBlobURL, offset int64, ac azblob.BlobAccessConditions, err error) *reader {
+	if serr, ok := err.(azblob.StorageError); !ok || serr.ServiceCode() != azblob.ServiceCodeInvalidRange {
+		return nil
+	}
+	props, err := blockBlobURL.GetProperties(ctx, ac, azblob.ClientProvidedKeyOptions{})
+	if err != nil || offset < props.ContentLength() {
+		return nil
+	}
+	return &reader{
+		body: http.NoBody,
+		attrs: driver.ReaderAttributes{
+			ContentType: props.ContentType(),
+			Size:        props.ContentLength(),
+			ModTime:     props.LastModified(),
+		},
+	}
+}
+
 func getSize(contentLength int64, contentRange string) int64 {
 	// Default size to ContentLength, but that's incorrect for partial-length reads,
 	// where ContentLength refers to the size of the returned Body, not the entire
@@ -688,12 +1241,19 @@ func getSize(contentLength int64, contentRange string) int64 {
 
 // As implements driver.As.
 func (b *bucket) As(i interface{}) bool {
-	p, ok := i.(**azblob.ContainerURL)
-	if !ok {
-		return false
+	switch p := i.(type) {
+	case **azblob.ContainerURL:
+		*p = &b.containerURL
+		return true
+	case **azblob.ServiceURL:
+		*p = b.serviceURL
+		return true
+	case **bucket:
+		// Used by the package-level helpers to reach the driver; see driverBucket.
+		*p = b
+		return true
 	}
-	*p = &b.containerURL
-	return true
+	return false
 }
 
 // As implements driver.ErrorAs.
@@ -709,6 +1269,9 @@ func (b *bucket) ErrorAs(err error, i interface{}) bool {
 }
 
 func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if errors.Is(err, ErrNotModified) || errors.Is(err, ErrBlobTypeMismatch) || errors.Is(err, ErrNotEncrypted) {
+		return gcerrors.FailedPrecondition
+	}
 	serr, ok := err.(azblob.StorageError)
 	switch {
 	case !ok:
@@ -718,11 +1281,27 @@ func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 			return gcerrors.NotFound
 		}
 		return gcerrors.Unknown
-	case serr.ServiceCode() == azblob.ServiceCodeBlobNotFound || serr.Response().StatusCode == 404:
+	case serr.ServiceCode() == azblob.ServiceCodeBlobNotFound || serr.ServiceCode() == azblob.ServiceCodeContainerNotFound || serr.Response().StatusCode == 404:
 		// Check and fail both the SDK ServiceCode and the Http Response Code for NotFound
 		return gcerrors.NotFound
 	case serr.ServiceCode() == azblob.ServiceCodeAuthenticationFailed:
 		return gcerrors.PermissionDenied
+	case serr.ServiceCode() == azblob.ServiceCodeBlobAlreadyExists:
+		return gcerrors.AlreadyExists
+	case serr.ServiceCode() == azblob.ServiceCodeConditionNotMet || serr.Response().StatusCode == http.StatusPreconditionFailed:
+		return gcerrors.FailedPrecondition
+	case serr.ServiceCode() == azblob.ServiceCodeMd5Mismatch:
+		// As for blob.WriterOptions.ContentMD5 mismatches.
+		return gcerrors.FailedPrecondition
+	case serr.ServiceCode() == azblob.ServiceCodeInvalidBlobType:
+		// E.g. staging blocks for a key that holds a page blob.
+		return gcerrors.FailedPrecondition
+	case serr.ServiceCode() == azblob.ServiceCodeServerBusy || serr.Response().StatusCode == http.StatusTooManyRequests || serr.Response().StatusCode == http.StatusServiceUnavailable:
+		// Azure throttles requests that exceed the account's limits; see
+		// ErrorDetails.RetryAfter for how long to back off.
+		return gcerrors.ResourceExhausted
+	case serr.ServiceCode() == azblob.ServiceCodeInvalidRange || serr.Response().StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		return gcerrors.InvalidArgument
 	default:
 		return gcerrors.Unknown
 	}
@@ -730,13 +1309,33 @@ func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 
 // Attributes implements driver.Attributes.
 func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
-	key = escapeKey(key, false)
+	key = b.blobName(key)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
-	blobPropertiesResponse, err := blockBlobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	ac := azblob.BlobAccessConditions{}
+	if mac, ok := ctx.Value(attributesConditionsKey{}).(azblob.ModifiedAccessConditions); ok {
+		ac.ModifiedAccessConditions = mac
+	}
+	propsCtx := ctx
+	if ctx.Value(attributesLastAccessKey{}) != nil {
+		// The last access time is only returned by newer service versions.
+		propsCtx = WithRequestHeaders(ctx, http.Header{"X-Ms-Version": {lastAccessServiceVersion}})
+	}
+	blobPropertiesResponse, err := blockBlobURL.GetProperties(propsCtx, ac, azblob.ClientProvidedKeyOptions{})
 	if err != nil {
+		if serr, ok := err.(azblob.StorageError); ok && serr.Response() != nil && serr.Response().StatusCode == http.StatusNotModified {
+			return nil, ErrNotModified
+		}
 		return nil, err
 	}
 
+	var tags *azblob.BlobTags
+	if ctx.Value(attributesTagsKey{}) != nil && blobPropertiesResponse.TagCount() > 0 {
+		tags, err = blockBlobURL.GetTags(ctx, nil, nil, nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	azureMD := blobPropertiesResponse.NewMetadata()
 	md := make(map[string]string, len(azureMD))
 	for k, v := range azureMD {
@@ -757,12 +1356,20 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ETag:               fmt.Sprintf("%v", blobPropertiesResponse.ETag()),
 		Metadata:           md,
 		AsFunc: func(i interface{}) bool {
-			p, ok := i.(*azblob.BlobGetPropertiesResponse)
-			if !ok {
-				return false
+			switch p := i.(type) {
+			case *azblob.BlobGetPropertiesResponse:
+				*p = *blobPropertiesResponse
+				return true
+			case *azblob.BlobTags:
+				if ctx.Value(attributesTagsKey{}) == nil {
+					return false
+				}
+				if tags != nil {
+					*p = *tags
+				}
+				return true
 			}
-			*p = *blobPropertiesResponse
-			return true
+			return false
 		},
 	}, nil
 }
@@ -783,7 +1390,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 
 	azOpts := azblob.ListBlobsSegmentOptions{
 		MaxResults: int32(pageSize),
-		Prefix:     escapeKey(opts.Prefix, true),
+		Prefix:     b.blobPrefix(opts.Prefix),
 	}
 	if opts.BeforeList != nil {
 		asFunc := func(i interface{}) bool {
@@ -798,7 +1405,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 			return nil, err
 		}
 	}
-	listBlob, err := b.containerURL.ListBlobsHierarchySegment(ctx, marker, escapeKey(opts.Delimiter, true), azOpts)
+	listBlob, err := b.containerURL.ListBlobsHierarchySegment(ctx, marker, b.blobPrefix(opts.Delimiter), azOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -806,8 +1413,9 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	page := &driver.ListPage{}
 	page.Objects = []*driver.ListObject{}
 	for _, blobPrefix := range listBlob.Segment.BlobPrefixes {
+		blobPrefix := blobPrefix // capture loop variable for use in AsFunc
 		page.Objects = append(page.Objects, &driver.ListObject{
-			Key:   unescapeKey(blobPrefix.Name),
+			Key:   b.keyFromBlobName(blobPrefix.Name),
 			Size:  0,
 			IsDir: true,
 			AsFunc: func(i interface{}) bool {
@@ -821,8 +1429,9 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	}
 
 	for _, blobInfo := range listBlob.Segment.BlobItems {
+		blobInfo := blobInfo // capture loop variable for use in AsFunc
 		page.Objects = append(page.Objects, &driver.ListObject{
-			Key:     unescapeKey(blobInfo.Name),
+			Key:     b.keyFromBlobName(blobInfo.Name),
 			ModTime: blobInfo.Properties.LastModified,
 			Size:    *blobInfo.Properties.ContentLength,
 			MD5:     blobInfo.Properties.ContentMD5,
@@ -851,14 +1460,16 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	return page, nil
 }
 
-func (b *bucket) refreshDelegationCredentials(ctx context.Context) (azblob.StorageAccountCredential, error) {
+// refreshDelegationCredentials returns a user delegation credential obtained
+// with MSI that is valid for at least minValidity, getting a new one if the
+// current one expires sooner.
+func (b *bucket) refreshDelegationCredentials(ctx context.Context, minValidity time.Duration) (azblob.StorageAccountCredential, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if time.Now().UTC().After(b.credentialExpiration) {
-		validPeriod := 48 * time.Hour
+	if time.Now().UTC().Add(minValidity).After(b.credentialExpiration) {
 		currentTime := time.Now().UTC()
-		expires := currentTime.Add(validPeriod)
+		expires := currentTime.Add(delegationKeyValidity)
 		keyInfo := azblob.NewKeyInfo(currentTime, expires)
 
 		creds, err := b.serviceURL.GetUserDelegationCredential(ctx, keyInfo, nil /* default timeout */, nil /* no request id */)
@@ -873,40 +1484,74 @@ func (b *bucket) refreshDelegationCredentials(ctx context.Context) (azblob.Stora
 	return b.delegationCredentials, nil
 }
 
+// validateSignedURLOptions returns an error if SignedURL can't sign a URL
+// with opts, other than because of a missing credential.
+func (b *bucket) validateSignedURLOptions(opts *driver.SignedURLOptions) error {
+	if opts.ContentType != "" || opts.EnforceAbsentContentType {
+		return gcerr.New(gcerr.Unimplemented, nil, 1, "azureblob: does not enforce Content-Type on PUT")
+	}
+	switch opts.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return gcerr.New(gcerr.Unimplemented, ErrUnsupportedMethod, 1, fmt.Sprintf("azureblob: unsupported Method %s", opts.Method))
+	}
+	switch b.opts.Credential.(type) {
+	case azblob.UserDelegationCredential, *azblob.UserDelegationCredential:
+		// The key's expiry isn't exposed; check against the longest
+		// validity Azure allows.
+		return checkDelegationKeyExpiry(opts.Expiry, maxDelegationKeyValidity)
+	}
+	return nil
+}
+
+// checkDelegationKeyExpiry returns an error if a SAS expiring after expiry
+// can't be signed with a user delegation key valid for validity: the SAS
+// would stop working when the key expires.
+func checkDelegationKeyExpiry(expiry, validity time.Duration) error {
+	if expiry > validity {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: Expiry must be at most %v when signing with a user delegation key, got %v", validity, expiry)
+	}
+	return nil
+}
+
 // SignedURL implements driver.SignedURL.
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	var credential azblob.StorageAccountCredential
 	if b.opts.Credential != nil {
 		credential = b.opts.Credential
+	} else if b.opts.SASToken != "" {
+		// A SAS token can't be used to sign another SAS.
+		return "", gcerr.New(gcerr.FailedPrecondition, ErrNoSharedKeyCredential, 1, "azureblob: bucket was opened with a SAS token; set Options.Credential to a user delegation credential to use SignedURL")
 	} else if isMSIEnvironment := adal.MSIAvailable(ctx, adal.CreateSender()); isMSIEnvironment {
+		if err := checkDelegationKeyExpiry(opts.Expiry, delegationKeyValidity); err != nil {
+			return "", err
+		}
 		var err error
-		credential, err = b.refreshDelegationCredentials(ctx)
+		credential, err = b.refreshDelegationCredentials(ctx, opts.Expiry)
 		if err != nil {
 			return "", gcerr.New(gcerr.Internal, err, 1, "azureblob: unable to generate User Delegation Credential")
 		}
 	} else {
-		return "", gcerr.New(gcerr.Unimplemented, nil, 1, "azureblob: to use SignedURL, you must call OpenBucket with a non-nil Options.Credential")
+		return "", gcerr.New(gcerr.Unimplemented, ErrNoSharedKeyCredential, 1, "azureblob: to use SignedURL, you must call OpenBucket with a non-nil Options.Credential")
 	}
 
-	if opts.ContentType != "" || opts.EnforceAbsentContentType {
-		return "", gcerr.New(gcerr.Unimplemented, nil, 1, "azureblob: does not enforce Content-Type on PUT")
+	if err := b.validateSignedURLOptions(opts); err != nil {
+		return "", err
 	}
 
-	key = escapeKey(key, false)
+	key = b.blobName(key)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	srcBlobParts := azblob.NewBlobURLParts(blockBlobURL.URL())
 
 	perms := azblob.BlobSASPermissions{}
 	switch opts.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 		perms.Read = true
 	case http.MethodPut:
 		perms.Create = true
 		perms.Write = true
 	case http.MethodDelete:
 		perms.Delete = true
-	default:
-		return "", fmt.Errorf("unsupported Method %s", opts.Method)
 	}
 	signVals := &azblob.BlobSASSignatureValues{
 		Protocol:      azblob.SASProtocolHTTPS,
@@ -937,10 +1582,15 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 
 type writer struct {
 	ctx          context.Context
+	bucket       *bucket
 	blockBlobURL *azblob.BlockBlobURL
 	uploadOpts   *azblob.UploadStreamToBlockBlobOptions
+	settings     *writeSettings
+	uploadMem    *semaphore.Weighted
+	maxUploadMem int64
 
 	w     *io.PipeWriter
+	buf   *bufferedPipe // buffers writes to w if Options.WriteBufferSize is set
 	donec chan struct{}
 	err   error
 }
@@ -969,21 +1619,11 @@ func escapeKey(key string, isPrefix bool) string {
 	})
 }
 
-// unescapeKey reverses escapeKey.
-func unescapeKey(key string) string {
-	return escape.HexUnescape(key)
-}
-
-// NewTypedWriter implements driver.NewTypedWriter.
-func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
-	key = escapeKey(key, false)
-	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
-	if opts.BufferSize == 0 {
-		opts.BufferSize = defaultUploadBlockSize
-	}
-
-	md := make(map[string]string, len(opts.Metadata))
-	for k, v := range opts.Metadata {
+// escapeMetadata does all required escaping of metadata keys and values for
+// them to work with Azure.
+func escapeMetadata(metadata map[string]string) (azblob.Metadata, error) {
+	md := make(azblob.Metadata, len(metadata))
+	for k, v := range metadata {
 		// See the package comments for more details on escaping of metadata
 		// keys & values.
 		e := escape.HexEscape(k, func(runes []rune, i int) bool {
@@ -1003,9 +1643,50 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		}
 		md[e] = escape.URLEscape(v)
 	}
+	return md, nil
+}
+
+// unescapeKey reverses escapeKey.
+func unescapeKey(key string) string {
+	return escape.HexUnescape(key)
+}
+
+// EscapeKey returns the name of the blob that this package stores for key,
+// as seen by other tools like azcopy or the Azure portal; see the package
+// documentation for the escaping rules. Keys that need no escaping are
+// returned unchanged.
+func EscapeKey(key string) string {
+	return escapeKey(key, false)
+}
+
+// UnescapeKey returns the key under which this package exposes the blob
+// named name, reversing EscapeKey.
+func UnescapeKey(name string) string {
+	return unescapeKey(name)
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	key = b.blobName(key)
+	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
+	if opts.BufferSize == 0 {
+		opts.BufferSize = defaultUploadBlockSize
+		if b.opts.UploadBlockSize > 0 {
+			opts.BufferSize = b.opts.UploadBlockSize
+		}
+	}
+	maxBuffers := defaultUploadBuffers
+	if b.opts.UploadBuffers > 0 {
+		maxBuffers = b.opts.UploadBuffers
+	}
+
+	md, err := escapeMetadata(opts.Metadata)
+	if err != nil {
+		return nil, err
+	}
 	uploadOpts := &azblob.UploadStreamToBlockBlobOptions{
 		BufferSize: opts.BufferSize,
-		MaxBuffers: defaultUploadBuffers,
+		MaxBuffers: maxBuffers,
 		Metadata:   md,
 		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
 			CacheControl:       opts.CacheControl,
@@ -1015,24 +1696,36 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 			ContentMD5:         opts.ContentMD5,
 			ContentType:        contentType,
 		},
+		BlobAccessTier: b.opts.AccessTier,
 	}
+	settings := &writeSettings{size: -1}
 	if opts.BeforeWrite != nil {
 		asFunc := func(i interface{}) bool {
-			p, ok := i.(**azblob.UploadStreamToBlockBlobOptions)
-			if !ok {
-				return false
+			switch p := i.(type) {
+			case **azblob.UploadStreamToBlockBlobOptions:
+				*p = uploadOpts
+				return true
+			case **writeSettings:
+				*p = settings
+				return true
 			}
-			*p = uploadOpts
-			return true
+			return false
 		}
 		if err := opts.BeforeWrite(asFunc); err != nil {
 			return nil, err
 		}
 	}
+	if uploadOpts.TransferManager == nil {
+		uploadOpts.TransferManager = b.newPooledTransferManager(uploadOpts.BufferSize, uploadOpts.MaxBuffers)
+	}
 	return &writer{
-		ctx:          ctx,
+		ctx:          withUploadProgressState(ctx, settings.progress),
+		bucket:       b,
 		blockBlobURL: &blockBlobURL,
 		uploadOpts:   uploadOpts,
+		settings:     settings,
+		uploadMem:    b.uploadMem,
+		maxUploadMem: b.opts.MaxUploadBufferMemory,
 		donec:        make(chan struct{}),
 	}, nil
 }
@@ -1045,10 +1738,16 @@ func (w *writer) Write(p []byte) (int, error) {
 	if w.w == nil {
 		pr, pw := io.Pipe()
 		w.w = pw
+		if n := w.bucket.opts.WriteBufferSize; n > 0 {
+			w.buf = newBufferedPipe(pw, n)
+		}
 		if err := w.open(pr); err != nil {
 			return 0, err
 		}
 	}
+	if w.buf != nil {
+		return w.buf.Write(p)
+	}
 	return w.w.Write(p)
 }
 
@@ -1056,32 +1755,405 @@ func (w *writer) open(pr *io.PipeReader) error {
 	go func() {
 		defer close(w.donec)
 
+		if w.settings.checkType {
+			if w.err = w.bucket.checkBlobType(w.ctx, w.blockBlobURL.BlobURL, w.settings.replaceType); w.err != nil {
+				if pr != nil {
+					pr.CloseWithError(w.err)
+				}
+				return
+			}
+		}
 		var body io.Reader
 		if pr == nil {
 			body = http.NoBody
 		} else {
 			body = pr
 		}
-		_, w.err = azblob.UploadStreamToBlockBlob(w.ctx, body, *w.blockBlobURL, *w.uploadOpts)
+		if w.uploadMem != nil {
+			n := uploadBufferMemory(w.uploadOpts)
+			if n > w.maxUploadMem {
+				n = w.maxUploadMem
+			}
+			if w.err = w.uploadMem.Acquire(w.ctx, n); w.err != nil {
+				if pr != nil {
+					pr.CloseWithError(w.err)
+				}
+				return
+			}
+			defer w.uploadMem.Release(n)
+		}
+		if pr != nil {
+			// Stop reading from the pipe as soon as the context is done,
+			// instead of uploading the rest of the data in vain.
+			stopc := make(chan struct{})
+			defer close(stopc)
+			go func() {
+				select {
+				case <-w.ctx.Done():
+					pr.CloseWithError(w.ctx.Err())
+				case <-stopc:
+				}
+			}()
+		}
+		size, sized := w.settings.size, w.settings.size >= 0
+		if sized {
+			body = &sizeCheckReader{r: body, size: size}
+		}
+		if sized && size <= int64(w.uploadOpts.BufferSize) {
+			w.err = w.uploadSingle(body, size)
+		} else {
+			_, w.err = azblob.UploadStreamToBlockBlob(w.ctx, body, *w.blockBlobURL, *w.uploadOpts)
+		}
 		if w.err != nil {
 			if pr != nil {
 				pr.CloseWithError(w.err)
 			}
+			// The blocks staged so far are left for Azure to discard:
+			// discarding them would require committing the blob again.
+			// See DiscardUncommittedBlocks.
 			return
 		}
 	}()
 	return nil
 }
 
+// pooledTransferManager is an azblob.TransferManager that takes its buffers
+// from a sync.Pool shared by all writers of a bucket, so that buffers are
+// recycled between uploads instead of being allocated for each one.
+// Each writer gets its own pooledTransferManager to bound the concurrency of
+// its upload.
+type pooledTransferManager struct {
+	pool *sync.Pool
+	size int
+	sem  chan struct{} // bounds the number of buffers handed out by Get
+}
+
+// newPooledTransferManager returns a TransferManager for a single upload
+// with the given buffer size and maximum number of concurrent buffers,
+// applying the same defaults as azblob.UploadStreamToBlockBlob.
+func (b *bucket) newPooledTransferManager(size, maxBuffers int) *pooledTransferManager {
+	if size < 1024*1024 {
+		size = 1024 * 1024
+	}
+	if maxBuffers < 1 {
+		maxBuffers = 1
+	}
+	pool, _ := b.bufferPools.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} { return make([]byte, size) },
+	})
+	return &pooledTransferManager{
+		pool: pool.(*sync.Pool),
+		size: size,
+		sem:  make(chan struct{}, maxBuffers),
+	}
+}
+
+// Get implements azblob.TransferManager.Get. Like azblob's static buffer
+// manager, it blocks while maxBuffers buffers are in use, which also bounds
+// the number of concurrent block uploads.
+func (m *pooledTransferManager) Get() []byte {
+	m.sem <- struct{}{}
+	return m.pool.Get().([]byte)
+}
+
+// Put implements azblob.TransferManager.Put.
+func (m *pooledTransferManager) Put(b []byte) {
+	// azblob puts back the slice it uploaded, which may be shorter than the
+	// buffer; restore its full length.
+	if cap(b) >= m.size {
+		m.pool.Put(b[:m.size])
+	}
+	<-m.sem
+}
+
+// Run implements azblob.TransferManager.Run.
+func (m *pooledTransferManager) Run(f func()) {
+	go f()
+}
+
+// Close implements azblob.TransferManager.Close.
+func (m *pooledTransferManager) Close() {}
+
+// uploadBufferMemory returns the maximum number of bytes of buffers used by
+// azblob.UploadStreamToBlockBlob with o, applying the same defaults.
+func uploadBufferMemory(o *azblob.UploadStreamToBlockBlobOptions) int64 {
+	bufferSize, maxBuffers := int64(o.BufferSize), int64(o.MaxBuffers)
+	if bufferSize < 1024*1024 {
+		bufferSize = 1024 * 1024
+	}
+	if maxBuffers == 0 {
+		maxBuffers = 1
+	}
+	return bufferSize * maxBuffers
+}
+
 // Close completes the writer and closes it. Any error occurring during write will
 // be returned. If a writer is closed before any Write is called, Close will
 // create an empty file at the given key.
+//
+// If w's context is done before the upload completes, the upload stops and
+// Close returns the context's error once it has. The blocks staged so far
+// are left for Azure to discard after a week; see DiscardUncommittedBlocks.
 func (w *writer) Close() error {
 	if w.w == nil {
 		w.open(nil)
-	} else if err := w.w.Close(); err != nil {
-		return err
+	} else {
+		closer := io.Closer(w.w)
+		if w.buf != nil {
+			closer = w.buf
+		}
+		if err := closer.Close(); err != nil {
+			return err
+		}
 	}
+	// The upload stops promptly once the context is done, so wait for it
+	// either way: no request of the upload outlives Close.
 	<-w.donec
+	if w.err != nil && w.ctx.Err() != nil {
+		return w.ctx.Err()
+	}
 	return w.err
 }
+
+// readSettings holds the settings of a read that have no field in the azblob
+// types exposed to blob.ReaderOptions.BeforeRead. Options like
+// ReadTimingHooks set them through its As function.
+type readSettings struct {
+	timingHooks     *ReadTimingHooks
+	encryptionCheck *EncryptionCheck
+	cacheBusting    bool
+}
+
+// MaxReadRetries is the number of times a read retries when reading the
+// blob's content fails midway, instead of the default of 3. Each retry
+// resumes the read where it failed. To use it, set
+// blob.ReaderOptions.BeforeRead to the BeforeRead method:
+//
+//   opts := &blob.ReaderOptions{BeforeRead: azureblob.MaxReadRetries(5).BeforeRead}
+//   r, err := bucket.NewReader(ctx, key, opts)
+type MaxReadRetries int
+
+// BeforeRead applies n to a read; see MaxReadRetries.
+func (n MaxReadRetries) BeforeRead(asFunc func(interface{}) bool) error {
+	var opts *azblob.RetryReaderOptions
+	if !asFunc(&opts) {
+		return errors.New("azureblob: MaxReadRetries can only be used with azureblob buckets")
+	}
+	opts.MaxRetryRequests = int(n)
+	return nil
+}
+
+// BlobURL returns the URL of the blob at key, without a SAS or other
+// credentials, e.g. to store a permanent reference to the blob. The URL
+// only grants access to the blob if its container is public; use
+// blob.Bucket.SignedURL for a URL that grants access temporarily.
+func BlobURL(b *blob.Bucket, key string) (string, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return "", err
+	}
+	u := drv.containerURL.NewBlobURL(drv.blobName(key)).URL()
+	u.RawQuery = "" // drop the bucket's SAS token
+	return u.String(), nil
+}
+
+// SnapshotURL is like BlobURL, but returns the URL of the snapshot of the
+// blob at key identified by snapshot, as returned by CreateSnapshot. Unlike
+// the URL of the blob, it always refers to the same content.
+func SnapshotURL(b *blob.Bucket, key, snapshot string) (string, error) {
+	return blobURLWith(b, key, func(p *azblob.BlobURLParts) { p.Snapshot = snapshot })
+}
+
+// VersionURL is like BlobURL, but returns the URL of the version of the blob
+// at key identified by versionID (see ListObjectVersion). Unlike the URL of
+// the blob, it always refers to the same content.
+func VersionURL(b *blob.Bucket, key, versionID string) (string, error) {
+	return blobURLWith(b, key, func(p *azblob.BlobURLParts) { p.VersionID = versionID })
+}
+
+// blobURLWith returns BlobURL(b, key) with the changes made by set.
+func blobURLWith(b *blob.Bucket, key string, set func(*azblob.BlobURLParts)) (string, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return "", err
+	}
+	parts := azblob.NewBlobURLParts(drv.containerURL.NewBlobURL(drv.blobName(key)).URL())
+	parts.SAS = azblob.SASQueryParameters{} // drop the bucket's SAS token
+	parts.UnparsedParams = ""
+	set(&parts)
+	u := parts.URL()
+	return u.String(), nil
+}
+
+// driverBucket returns the azureblob driver underlying b.
+func driverBucket(b *blob.Bucket) (*bucket, error) {
+	var drv *bucket
+	if b == nil || !b.As(&drv) {
+		return nil, errors.New("azureblob: bucket was not opened with azureblob")
+	}
+	return drv, nil
+}
+
+// wrapError wraps err the same way *blob.Bucket does for errors returned by
+// the driver, so that gcerrors.Code and Bucket.ErrorAs work for errors
+// returned by the package-level helpers.
+func (b *bucket) wrapError(err error, key string) error {
+	if err == nil {
+		return nil
+	}
+	msg := "azureblob"
+	if key != "" {
+		msg += fmt.Sprintf(" (key %q)", key)
+	}
+	return gcerr.New(b.ErrorCode(err), err, 2, msg)
+}
+
+// doRaw sends a request for an operation that azblob has no wrapper for
+// through b's pipeline, with body as the request body if it isn't nil.
+// Unsuccessful responses are returned as an azblob.StorageError, just like
+// the errors returned by azblob.
+func (b *bucket) doRaw(ctx context.Context, method string, u url.URL, header http.Header, body io.ReadSeeker) (*http.Response, error) {
+	req, err := pipeline.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azblob.ServiceVersion)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := b.pipeline.Do(ctx, nil, req)
+	if err != nil {
+		return nil, err
+	}
+	httpResp := resp.Response()
+	if httpResp.StatusCode >= 200 && httpResp.StatusCode < 300 {
+		return httpResp, nil
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// The service code and details are populated during unmarshalling.
+	serr := azblob.NewResponseError(nil, httpResp, httpResp.Status)
+	if len(respBody) > 0 {
+		if err := xml.Unmarshal(respBody, &serr); err != nil {
+			return nil, azblob.NewResponseError(err, httpResp, "failed to unmarshal response body")
+		}
+	}
+	return nil, serr
+}
+
+// SetExpiry sets the time at which the blob at key is automatically deleted.
+// If expireOn is the zero time, any existing expiry is removed.
+// Blob expiry is only supported for accounts with hierarchical namespace
+// enabled.
+func SetExpiry(ctx context.Context, b *blob.Bucket, key string, expireOn time.Time) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	u := drv.containerURL.NewBlobURL(drv.blobName(key)).URL()
+	q := u.Query()
+	q.Set("comp", "expiry")
+	u.RawQuery = q.Encode()
+	header := http.Header{}
+	if expireOn.IsZero() {
+		header.Set("x-ms-expiry-option", string(azblob.BlobExpiryOptionsNeverExpire))
+	} else {
+		header.Set("x-ms-expiry-option", string(azblob.BlobExpiryOptionsAbsolute))
+		header.Set("x-ms-expiry-time", expireOn.UTC().Format(http.TimeFormat))
+	}
+	resp, err := drv.doRaw(ctx, http.MethodPut, u, header, nil)
+	if err != nil {
+		return drv.wrapError(err, key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RehydrateCopyOptions sets options for CopyToRehydrate.
+type RehydrateCopyOptions struct {
+	// Priority is the rehydrate priority for the copy:
+	// azblob.RehydratePriorityStandard (the default) or
+	// azblob.RehydratePriorityHigh.
+	Priority azblob.RehydratePriorityType
+
+	// PollInterval is how often the copy status is checked while waiting for
+	// the copy to complete. Defaults to one minute.
+	PollInterval time.Duration
+
+	// Progress, if set, is called with the progress of the copy; see
+	// CopyProgress.
+	Progress CopyProgress
+}
+
+// CopyToRehydrate copies the blob at srcKey, which is in the Archive tier, to
+// dstKey in tier, which must be an online tier (Hot or Cool). This rehydrates
+// the copy without changing the tier of the source blob. The tier is set in
+// the copy request itself, so the copy is never in another tier.
+// CopyToRehydrate returns once the copy, and therefore the rehydration, is
+// complete, which may take several hours; use ctx to bound the wait.
+// opts may be nil.
+func CopyToRehydrate(ctx context.Context, b *blob.Bucket, dstKey, srcKey string, tier azblob.AccessTierType, opts *RehydrateCopyOptions) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &RehydrateCopyOptions{}
+	}
+	if tier != azblob.AccessTierHot && tier != azblob.AccessTierCool {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: CopyToRehydrate: tier must be %s or %s, got %q", azblob.AccessTierHot, azblob.AccessTierCool, tier)
+	}
+	priority := opts.Priority
+	if err := checkRehydratePriority("CopyToRehydrate", priority); err != nil {
+		return err
+	}
+	if priority == azblob.RehydratePriorityNone {
+		priority = azblob.RehydratePriorityStandard
+	}
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultRehydratePollInterval
+	}
+
+	dstBlobURL := drv.containerURL.NewBlobURL(drv.blobName(dstKey))
+	srcURL := drv.containerURL.NewBlobURL(drv.blobName(srcKey)).URL()
+	// azblob's StartCopyFromURL doesn't support setting the rehydrate
+	// priority, so issue the request directly.
+	header := http.Header{}
+	header.Set("x-ms-copy-source", srcURL.String())
+	header.Set("x-ms-access-tier", string(tier))
+	header.Set("x-ms-rehydrate-priority", string(priority))
+	resp, err := drv.doRaw(ctx, http.MethodPut, dstBlobURL.URL(), header, nil)
+	if err != nil {
+		return drv.wrapError(err, dstKey)
+	}
+	resp.Body.Close()
+	copyStatus := azblob.CopyStatusType(resp.Header.Get("x-ms-copy-status"))
+	return drv.wrapError(waitForCopy(ctx, dstBlobURL, copyStatus, pollInterval, opts.Progress), dstKey)
+}
+
+// attributesConditionsKey is the context key used to pass an
+// azblob.ModifiedAccessConditions to the driver's Attributes.
+type attributesConditionsKey struct{}
+
+// AttributesIfModifiedSince is like b.Attributes, but only returns the
+// attributes if the blob at key has been modified since the given time.
+// Otherwise it returns an error for which errors.Is(err, ErrNotModified) is
+// true, without transferring the blob's properties. This is a cheap way to
+// poll a blob for changes.
+func AttributesIfModifiedSince(ctx context.Context, b *blob.Bucket, key string, since time.Time) (*blob.Attributes, error) {
+	mac := azblob.ModifiedAccessConditions{IfModifiedSince: since}
+	return b.Attributes(context.WithValue(ctx, attributesConditionsKey{}, mac), key)
+}
+
+// AttributesIfNoneMatch is like b.Attributes, but only returns the attributes
+// if the ETag of the blob at key differs from etag (as returned in
+// Attributes.ETag). Otherwise it returns an error for which
+// errors.Is(err, ErrNotModified) is true.
+func AttributesIfNoneMatch(ctx context.Context, b *blob.Bucket, key string, etag string) (*blob.Attributes, error) {
+	mac := azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETag(etag)}
+	return b.Attributes(context.WithValue(ctx, attributesConditionsKey{}, mac), key)
+}
@@ -15,15 +15,22 @@
 package azureblob
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
@@ -31,7 +38,9 @@ import (
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/driver"
 	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/gcerrors"
 	"gocloud.dev/internal/testing/setup"
+	"gocloud.dev/internal/useragent"
 )
 
 // Prerequisites for -record mode
@@ -167,6 +176,10 @@ func (verifyContentLanguage) BucketCheck(b *blob.Bucket) error {
 	if !b.As(&u) {
 		return errors.New("Bucket.As failed")
 	}
+	var su *azblob.ServiceURL
+	if !b.As(&su) {
+		return errors.New("Bucket.As failed for ServiceURL")
+	}
 	return nil
 }
 
@@ -175,6 +188,16 @@ func (verifyContentLanguage) ErrorCheck(b *blob.Bucket, err error) error {
 	if !b.ErrorAs(err, &to) {
 		return errors.New("Bucket.ErrorAs failed")
 	}
+	details, ok := ErrorDetailsOf(err)
+	if !ok {
+		return errors.New("ErrorDetailsOf failed")
+	}
+	if details.StatusCode != http.StatusNotFound || details.ServiceCode != "BlobNotFound" {
+		return fmt.Errorf("got ErrorDetails %+v want status %d and code BlobNotFound", details, http.StatusNotFound)
+	}
+	if details.RequestID == "" {
+		return errors.New("ErrorDetails has no RequestID")
+	}
 	return nil
 }
 
@@ -187,6 +210,10 @@ func (verifyContentLanguage) BeforeRead(as func(interface{}) bool) error {
 	if !as(&ac) {
 		return fmt.Errorf("BeforeRead As failed to get %T", ac)
 	}
+	var ro *azblob.RetryReaderOptions
+	if !as(&ro) {
+		return fmt.Errorf("BeforeRead As failed to get %T", ro)
+	}
 	return nil
 }
 
@@ -214,6 +241,34 @@ func (verifyContentLanguage) BeforeCopy(as func(interface{}) bool) error {
 	if !as(&bac) {
 		return errors.New("BeforeCopy.As failed for BlobAccessConditions")
 	}
+
+	var directive *MetadataDirective
+	if !as(&directive) {
+		return errors.New("BeforeCopy.As failed for MetadataDirective")
+	}
+	if *directive != MetadataDirectiveCopy {
+		return fmt.Errorf("got MetadataDirective %v want %v", *directive, MetadataDirectiveCopy)
+	}
+
+	var tags azblob.BlobTagsMap
+	if !as(&tags) {
+		return errors.New("BeforeCopy.As failed for BlobTagsMap")
+	}
+
+	var tagDirective *TagDirective
+	if !as(&tagDirective) {
+		return errors.New("BeforeCopy.As failed for TagDirective")
+	}
+
+	var tier *azblob.AccessTierType
+	if !as(&tier) {
+		return errors.New("BeforeCopy.As failed for AccessTierType")
+	}
+
+	var progress *CopyProgress
+	if !as(&progress) {
+		return errors.New("BeforeCopy.As failed for CopyProgress")
+	}
 	return nil
 }
 
@@ -241,6 +296,21 @@ func (verifyContentLanguage) AttributesCheck(attrs *blob.Attributes) error {
 	if got := resp.ContentLanguage(); got != language {
 		return fmt.Errorf("got %q want %q", got, language)
 	}
+	bt, ok := AttributesBlobType(attrs)
+	if !ok {
+		return errors.New("AttributesBlobType returned false")
+	}
+	if bt.Type != azblob.BlobBlockBlob {
+		return fmt.Errorf("got blob type %q want %q", bt.Type, azblob.BlobBlockBlob)
+	}
+	// The blob was written without a tier, so it has the account's default.
+	tier, ok := AttributesTier(attrs)
+	if !ok {
+		return errors.New("AttributesTier returned false")
+	}
+	if tier.Tier == azblob.AccessTierNone || !tier.Inferred {
+		return fmt.Errorf("got tier %+v want an inferred tier", tier)
+	}
 	return nil
 }
 
@@ -252,6 +322,10 @@ func (verifyContentLanguage) ReaderCheck(r *blob.Reader) error {
 	if got := resp.ContentLanguage(); got != language {
 		return fmt.Errorf("got %q want %q", got, language)
 	}
+	// The whole blob was read, so there is no Content-Range.
+	if cr, ok := ReaderContentRange(r); ok {
+		return fmt.Errorf("got ContentRange %+v for a full read", cr)
+	}
 	return nil
 }
 
@@ -270,6 +344,12 @@ func (verifyContentLanguage) ListObjectCheck(o *blob.ListObject) error {
 	if got := *item.Properties.ContentLanguage; got != language {
 		return fmt.Errorf("got %q want %q", got, language)
 	}
+	if got := ListObjectETag(o); got == "" {
+		return errors.New("ListObjectETag returned an empty ETag")
+	}
+	if ListObjectCreationTime(o).IsZero() {
+		return errors.New("ListObjectCreationTime returned the zero time")
+	}
 	return nil
 }
 
@@ -281,6 +361,7 @@ func TestOpenBucket(t *testing.T) {
 		containerName string
 		want          string
 		wantErr       bool
+		wantErrIs     error
 	}{
 		{
 			description:   "nil pipeline results in error",
@@ -293,12 +374,19 @@ func TestOpenBucket(t *testing.T) {
 			description:   "empty account name results in error",
 			containerName: "foo",
 			wantErr:       true,
+			wantErrIs:     ErrNoAccountName,
 		},
 		{
 			description: "empty container name results in error",
 			accountName: "myaccount",
 			wantErr:     true,
 		},
+		{
+			description:   "invalid container name results in error",
+			accountName:   "myaccount",
+			containerName: "Foo",
+			wantErr:       true,
+		},
 		{
 			description:   "success",
 			accountName:   "myaccount",
@@ -319,6 +407,12 @@ func TestOpenBucket(t *testing.T) {
 			if (err != nil) != test.wantErr {
 				t.Errorf("got err %v want error %v", err, test.wantErr)
 			}
+			if test.wantErrIs != nil && !errors.Is(err, test.wantErrIs) {
+				t.Errorf("got err %v want errors.Is %v", err, test.wantErrIs)
+			}
+			if err != nil && strings.Count(err.Error(), "azureblob") != 1 {
+				t.Errorf("got err %q, want a single azureblob prefix", err)
+			}
 			if err == nil && drv != nil && drv.name != test.want {
 				t.Errorf("got %q want %q", drv.name, test.want)
 			}
@@ -334,6 +428,79 @@ func TestOpenBucket(t *testing.T) {
 	}
 }
 
+func TestOpenBucketFromEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string // URL of the container
+		wantErr  bool
+	}{
+		{"https://myaccount.blob.core.windows.net/mycontainer", "https://myaccount.blob.core.windows.net/mycontainer", false},
+		{"https://myaccount.blob.core.usgovcloudapi.net/mycontainer/", "https://myaccount.blob.core.usgovcloudapi.net/mycontainer", false},
+		{"https://myaccount.blob.core.windows.net/mycontainer?sv=2019-12-12&sig=xyz", "https://myaccount.blob.core.windows.net/mycontainer?sv=2019-12-12&sig=xyz", false},
+		{"http://127.0.0.1:10000/devstoreaccount1/mycontainer", "http://127.0.0.1:10000/devstoreaccount1/mycontainer", false},
+		// Missing container.
+		{"https://myaccount.blob.core.windows.net", "", true},
+		{"https://myaccount.blob.core.windows.net/", "", true},
+		{"http://127.0.0.1:10000/devstoreaccount1", "", true},
+		// Blob URL.
+		{"https://myaccount.blob.core.windows.net/mycontainer/myblob", "", true},
+		// Missing account.
+		{"https://localdomain/mycontainer", "", true},
+		// Not an absolute URL.
+		{"myaccount.blob.core.windows.net/mycontainer", "", true},
+		// Invalid container name.
+		{"https://myaccount.blob.core.windows.net/MyContainer", "", true},
+		// Invalid protocol.
+		{"ftp://myaccount.blob.core.windows.net/mycontainer", "", true},
+	}
+
+	ctx := context.Background()
+	p := NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			b, err := OpenBucketFromEndpoint(ctx, p, test.endpoint, nil)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got err %v want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer b.Close()
+			var u *azblob.ContainerURL
+			if !b.As(&u) {
+				t.Fatal("Bucket.As failed")
+			}
+			if got := u.String(); got != test.want {
+				t.Errorf("got URL %q want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateContainerName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"foo", false},
+		{"my-container-1", false},
+		{"$root", false},
+		{"fo", true},
+		{strings.Repeat("a", 64), true},
+		{"Foo", true},
+		{"foo_bar", true},
+		{"-foo", true},
+		{"foo-", true},
+		{"foo--bar", true},
+	}
+	for _, test := range tests {
+		err := validateContainerName(test.name)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got err %v want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
 func TestOpenerFromEnv(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -525,6 +692,35 @@ func TestURLOpenerForParams(t *testing.T) {
 			},
 			wantOpts: Options{StorageDomain: "blob.core.usgovcloudapi.net"},
 		},
+		{
+			name: "AccessTier",
+			query: url.Values{
+				"tier": {"cool"},
+			},
+			wantOpts: Options{AccessTier: azblob.AccessTierCool},
+		},
+		{
+			name: "upload tuning",
+			query: url.Values{
+				"block_size":  {"4194304"},
+				"max_buffers": {"8"},
+			},
+			wantOpts: Options{UploadBlockSize: 4194304, UploadBuffers: 8},
+		},
+		{
+			name: "CheckContainer",
+			query: url.Values{
+				"check_container": {"true"},
+			},
+			wantOpts: Options{CheckContainer: true},
+		},
+		{
+			name: "RawKeys",
+			query: url.Values{
+				"raw_keys": {"true"},
+			},
+			wantOpts: Options{RawKeys: true},
+		},
 		{
 			name: "duplicate StorageDomain",
 			query: url.Values{
@@ -588,6 +784,25 @@ func TestOpenBucketFromURL(t *testing.T) {
 		{"azblob://mybucket?cdn=true", false},
 		// With invalid CDN.
 		{"azblob://mybucket?cdn=42", true},
+		// With access tier.
+		{"azblob://mybucket?tier=Cool", false},
+		{"azblob://mybucket?tier=archive", false},
+		// With invalid access tier.
+		{"azblob://mybucket?tier=Frozen", true},
+		// With directory placeholders.
+		{"azblob://mybucket?dir_placeholder=/.keep", false},
+		// With upload tuning.
+		{"azblob://mybucket?block_size=4194304&max_buffers=8", false},
+		// With invalid upload tuning.
+		{"azblob://mybucket?block_size=0", true},
+		{"azblob://mybucket?max_buffers=many", true},
+		// With raw keys.
+		{"azblob://mybucket?raw_keys=true", false},
+		{"azblob://mybucket?raw_keys=maybe", true},
+		// Anonymous.
+		{"azblob://mybucket?anon=true", false},
+		// With invalid anon.
+		{"azblob://mybucket?anon=maybe", true},
 		// Invalid parameter.
 		{"azblob://mybucket?param=value", true},
 	}
@@ -603,3 +818,776 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+func TestNoAccountNameFromEnv(t *testing.T) {
+	prev := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	os.Setenv("AZURE_STORAGE_ACCOUNT", "")
+	defer os.Setenv("AZURE_STORAGE_ACCOUNT", prev)
+
+	if _, err := DefaultAccountName(); !errors.Is(err, ErrNoAccountName) {
+		t.Errorf("DefaultAccountName: got err %v want errors.Is %v", err, ErrNoAccountName)
+	}
+	u, err := url.Parse("azblob://mycontainer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := new(lazyCredsOpener).OpenBucketURL(context.Background(), u); !errors.Is(err, ErrNoAccountName) {
+		t.Errorf("OpenBucketURL: got err %v want errors.Is %v", err, ErrNoAccountName)
+	}
+}
+
+func TestNewURLOpener(t *testing.T) {
+	// The environment is ignored.
+	prev := os.Getenv("AZURE_STORAGE_APPLICATION_ID")
+	os.Setenv("AZURE_STORAGE_APPLICATION_ID", "from-env")
+	defer os.Setenv("AZURE_STORAGE_APPLICATION_ID", prev)
+
+	ctx := context.Background()
+	popts := azblob.PipelineOptions{Telemetry: azblob.TelemetryOptions{Value: " my-app/1.0"}}
+	for _, domain := range []StorageDomain{"blob.core.usgovcloudapi.net", "blob.core.chinacloudapi.cn"} {
+		o, err := NewURLOpener(accountName, "bXlrZXk=", "", Options{StorageDomain: domain}, popts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Options.Credential == nil {
+			t.Error("Options.Credential = <nil>; want non-nil")
+		}
+		if got := o.PipelineOptions.Telemetry.Value; got != popts.Telemetry.Value {
+			t.Errorf("got Telemetry.Value %q want %q", got, popts.Telemetry.Value)
+		}
+		u, err := url.Parse("azblob://mycontainer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := o.OpenBucketURL(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := BlobURL(b, "my-key")
+		b.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("https://%s.%s/mycontainer/my-key", accountName, domain); got != want {
+			t.Errorf("got URL %q want %q", got, want)
+		}
+	}
+}
+
+func TestOpenBucketURLAnonymous(t *testing.T) {
+	var gotQuery url.Values
+	var gotAuth, gotUA string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery, gotAuth, gotUA = r.URL.Query(), r.Header.Get("Authorization"), r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+
+	cred, err := NewCredential(accountName, AccountKey(base64.StdEncoding.EncodeToString([]byte("key"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	popts := azblob.PipelineOptions{Telemetry: azblob.TelemetryOptions{Value: " my-app/1.0"}}
+	opener := &URLOpener{
+		AccountName:     accountName,
+		Pipeline:        NewPipeline(cred, popts),
+		PipelineOptions: popts,
+		Options:         Options{Credential: cred, SASToken: "sig=abc"},
+	}
+	ctx := context.Background()
+	for _, anon := range []bool{false, true} {
+		u, err := url.Parse(fmt.Sprintf("azblob://mycontainer?protocol=http&domain=%s&anon=%v", strings.TrimPrefix(srv.URL, "http://"), anon))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := opener.OpenBucketURL(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Attributes(ctx, "my-key"); err != nil {
+			t.Error(err)
+		}
+		b.Close()
+		if got := gotQuery.Get("sig") != "" || gotAuth != ""; got == anon {
+			t.Errorf("anon=%v: got query %v and Authorization %q", anon, gotQuery, gotAuth)
+		}
+		// The opener's pipeline options are used either way.
+		if !strings.Contains(gotUA, "my-app/1.0") {
+			t.Errorf("anon=%v: got User-Agent %q, want it to contain the application ID", anon, gotUA)
+		}
+	}
+}
+
+func TestPipelineApplicationID(t *testing.T) {
+	var gotUA string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+	userAgent := func(p pipeline.Pipeline) string {
+		t.Helper()
+		b, err := OpenBucket(context.Background(), p, accountName, "mycontainer",
+			&Options{Protocol: "http", StorageDomain: StorageDomain(strings.TrimPrefix(srv.URL, "http://"))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		if _, err := b.Attributes(context.Background(), "my-key"); err != nil {
+			t.Error(err)
+		}
+		return gotUA
+	}
+
+	prefix := useragent.AzureUserAgentPrefix("blob")
+	// opts.Telemetry.Value is appended as is.
+	for _, value := range []string{"", " my-app/1.0", "/suffix"} {
+		opts := azblob.PipelineOptions{Telemetry: azblob.TelemetryOptions{Value: value}}
+		want := prefix + value + " Azure-Storage/"
+		if got := userAgent(NewPipeline(azblob.NewAnonymousCredential(), opts)); !strings.HasPrefix(got, want) {
+			t.Errorf("Telemetry.Value %q: got User-Agent %q, want prefix %q", value, got, want)
+		}
+	}
+
+	// AZURE_STORAGE_APPLICATION_ID is added to the pipelines of the default
+	// URL opener.
+	prev := os.Getenv("AZURE_STORAGE_APPLICATION_ID")
+	defer os.Setenv("AZURE_STORAGE_APPLICATION_ID", prev)
+	for _, appID := range []string{"", "my-app/1.0"} {
+		os.Setenv("AZURE_STORAGE_APPLICATION_ID", appID)
+		o, err := openerFromAnon(accountName, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := prefix + " "
+		if appID != "" {
+			want += appID + " "
+		}
+		if got := userAgent(o.Pipeline); !strings.HasPrefix(got, want+"Azure-Storage/") {
+			t.Errorf("AZURE_STORAGE_APPLICATION_ID %q: got User-Agent %q, want prefix %q", appID, got, want)
+		}
+	}
+	// Values that aren't allowed in a User-Agent are rejected.
+	for _, appID := range []string{"my-app\r\nX-Injected: 1", "my-app/\u00e9"} {
+		os.Setenv("AZURE_STORAGE_APPLICATION_ID", appID)
+		if _, err := openerFromAnon(accountName, Options{}); err == nil {
+			t.Errorf("AZURE_STORAGE_APPLICATION_ID %q: got nil error", appID)
+		}
+	}
+}
+
+// newTestBucket returns a *blob.Bucket whose requests are served by handler,
+// using the local emulator URL layout, and a function to clean up.
+// opts may be nil; Protocol and StorageDomain are overwritten.
+func newTestBucket(t *testing.T, opts *Options, handler http.HandlerFunc) (*blob.Bucket, func()) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	p := NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{MaxTries: 1},
+	})
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.Protocol = "http"
+	opts.StorageDomain = StorageDomain(strings.TrimPrefix(srv.URL, "http://"))
+	b, err := OpenBucket(context.Background(), p, accountName, "mycontainer", opts)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return b, func() {
+		b.Close()
+		srv.Close()
+	}
+}
+
+func TestReaderRetryOptions(t *testing.T) {
+	tests := []struct {
+		description  string
+		beforeRead   func(asFunc func(interface{}) bool) error
+		wantRequests int
+	}{
+		{
+			description:  "default retries",
+			wantRequests: 1 + defaultMaxDownloadRetryRequests,
+		},
+		{
+			description: "no retries",
+			beforeRead: func(asFunc func(interface{}) bool) error {
+				var ro *azblob.RetryReaderOptions
+				if !asFunc(&ro) {
+					return errors.New("As failed")
+				}
+				ro.MaxRetryRequests = 0
+				return nil
+			},
+			wantRequests: 1,
+		},
+		{
+			description:  "MaxReadRetries",
+			beforeRead:   MaxReadRetries(5).BeforeRead,
+			wantRequests: 6,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			// The server promises a body but doesn't send it, so reads of
+			// the body fail with a retryable error.
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				mu.Unlock()
+				w.Header().Set("Content-Length", "10")
+			})
+			defer done()
+			r, err := b.NewReader(context.Background(), "my-key", &blob.ReaderOptions{BeforeRead: test.beforeRead})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := ioutil.ReadAll(r); err == nil {
+				t.Error("got nil error reading truncated body")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if requests != test.wantRequests {
+				t.Errorf("got %d requests want %d", requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestBlobURL(t *testing.T) {
+	for _, sasToken := range []SASToken{"", "sv=2019-12-12&sig=abc"} {
+		b, err := OpenBucket(context.Background(), NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}), accountName, "mycontainer", &Options{SASToken: sasToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := BlobURL(b, "dir/my key")
+		b.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "https://" + string(accountName) + ".blob.core.windows.net/mycontainer/dir/my%20key"; got != want {
+			t.Errorf("SASToken %q: got %q want %q", sasToken, got, want)
+		}
+	}
+}
+
+func TestSnapshotAndVersionURL(t *testing.T) {
+	b, err := OpenBucket(context.Background(), NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}), accountName, "mycontainer", &Options{SASToken: "sv=2019-12-12&sig=abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	base := "https://" + string(accountName) + ".blob.core.windows.net/mycontainer/dir/my%20key"
+
+	got, err := SnapshotURL(b, "dir/my key", "2021-05-03T00:00:00.0000000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := base + "?snapshot=2021-05-03T00:00:00.0000000Z"; got != want {
+		t.Errorf("SnapshotURL: got %q want %q", got, want)
+	}
+	got, err = VersionURL(b, "dir/my key", "2021-05-03T00:00:00.1234567Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := base + "?versionid=2021-05-03T00:00:00.1234567Z"; got != want {
+		t.Errorf("VersionURL: got %q want %q", got, want)
+	}
+}
+
+func TestReadPastEnd(t *testing.T) {
+	const content = "hello"
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			return
+		}
+		var start int
+		fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-", &start)
+		if start >= len(content) {
+			w.Header().Set("x-ms-error-code", "InvalidRange")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, content[start:])
+	})
+	defer done()
+
+	ctx := context.Background()
+	for _, offset := range []int64{2, 5, 10} {
+		r, err := b.NewRangeReader(ctx, "my-key", offset, -1, nil)
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		want := ""
+		if offset < int64(len(content)) {
+			want = content[offset:]
+		}
+		if string(got) != want {
+			t.Errorf("offset %d: got %q want %q", offset, got, want)
+		}
+		if r.Size() != int64(len(content)) || r.ContentType() != "text/plain" {
+			t.Errorf("offset %d: got size %d and content type %q", offset, r.Size(), r.ContentType())
+		}
+	}
+}
+
+func TestSignedURLWithSASToken(t *testing.T) {
+	b, done := newTestBucket(t, &Options{SASToken: "sv=2019-12-12&sig=abc"}, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	})
+	defer done()
+	_, err := b.SignedURL(context.Background(), "my-key", nil)
+	if !errors.Is(err, ErrNoSharedKeyCredential) {
+		t.Errorf("got error %v want %v", err, ErrNoSharedKeyCredential)
+	}
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error code %v want FailedPrecondition", gcerrors.Code(err))
+	}
+}
+
+func TestSetExpiry(t *testing.T) {
+	ctx := context.Background()
+	expireOn := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var gotQuery url.Values
+	var gotHeader http.Header
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/gocloudblobtests/mycontainer/my-key" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		gotQuery, gotHeader = r.URL.Query(), r.Header
+		if r.Header.Get("x-ms-expiry-option") == "NeverExpire" {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer done()
+	if err := SetExpiry(ctx, b, "my-key", expireOn); err != nil {
+		t.Fatal(err)
+	}
+	if got := gotQuery.Get("comp"); got != "expiry" {
+		t.Errorf("got comp=%q want %q", got, "expiry")
+	}
+	if got := gotHeader.Get("x-ms-expiry-option"); got != "Absolute" {
+		t.Errorf("got x-ms-expiry-option %q want %q", got, "Absolute")
+	}
+	if got, want := gotHeader.Get("x-ms-expiry-time"), "Wed, 02 Jan 2030 03:04:05 GMT"; got != want {
+		t.Errorf("got x-ms-expiry-time %q want %q", got, want)
+	}
+
+	err := SetExpiry(ctx, b, "my-key", time.Time{})
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v want NotFound", err)
+	}
+	var serr azblob.StorageError
+	if !b.ErrorAs(err, &serr) || serr.ServiceCode() != azblob.ServiceCodeBlobNotFound {
+		t.Errorf("ErrorAs failed for %v", err)
+	}
+}
+
+func TestCopyToRehydrate(t *testing.T) {
+	ctx := context.Background()
+
+	var gotHeader http.Header
+	polls := 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			gotHeader = r.Header
+			w.Header().Set("x-ms-copy-status", "pending")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodHead:
+			polls++
+			if polls < 2 {
+				w.Header().Set("x-ms-copy-status", "pending")
+			} else {
+				w.Header().Set("x-ms-copy-status", "success")
+			}
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+		}
+	})
+	defer done()
+
+	opts := &RehydrateCopyOptions{Priority: azblob.RehydratePriorityHigh, PollInterval: time.Millisecond}
+	if err := CopyToRehydrate(ctx, b, "dst", "src", azblob.AccessTierCool, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := gotHeader.Get("x-ms-copy-source"); !strings.HasSuffix(got, "/mycontainer/src") {
+		t.Errorf("got x-ms-copy-source %q", got)
+	}
+	if got := gotHeader.Get("x-ms-access-tier"); got != "Cool" {
+		t.Errorf("got x-ms-access-tier %q want %q", got, "Cool")
+	}
+	if got := gotHeader.Get("x-ms-rehydrate-priority"); got != "High" {
+		t.Errorf("got x-ms-rehydrate-priority %q want %q", got, "High")
+	}
+	if polls != 2 {
+		t.Errorf("got %d polls want 2", polls)
+	}
+
+	err := CopyToRehydrate(ctx, b, "dst", "src", azblob.AccessTierArchive, nil)
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+}
+
+func TestCopyAccessTier(t *testing.T) {
+	tests := []struct {
+		description string
+		bucketTier  azblob.AccessTierType
+		copyTier    azblob.AccessTierType // set by BeforeCopy or CopyFromURLOptions
+		want        string
+	}{
+		{description: "no tier"},
+		{description: "bucket tier", bucketTier: azblob.AccessTierArchive, want: "Archive"},
+		{description: "copy tier", copyTier: azblob.AccessTierCool, want: "Cool"},
+		{description: "copy tier overrides bucket tier", bucketTier: azblob.AccessTierArchive, copyTier: azblob.AccessTierCool, want: "Cool"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var gotTier string
+			b, done := newTestBucket(t, &Options{AccessTier: test.bucketTier}, func(w http.ResponseWriter, r *http.Request) {
+				gotTier = r.Header.Get("x-ms-access-tier")
+				w.Header().Set("x-ms-copy-status", "success")
+				w.WriteHeader(http.StatusAccepted)
+			})
+			defer done()
+			ctx := context.Background()
+
+			opts := &blob.CopyOptions{}
+			if test.copyTier != azblob.AccessTierNone {
+				opts.BeforeCopy = func(as func(interface{}) bool) error {
+					var at *azblob.AccessTierType
+					if !as(&at) {
+						return errors.New("BeforeCopy.As failed for AccessTierType")
+					}
+					*at = test.copyTier
+					return nil
+				}
+			}
+			if err := b.Copy(ctx, "dst", "src", opts); err != nil {
+				t.Fatal(err)
+			}
+			if gotTier != test.want {
+				t.Errorf("Copy: got x-ms-access-tier %q want %q", gotTier, test.want)
+			}
+
+			gotTier = ""
+			urlOpts := &CopyFromURLOptions{AccessTier: test.copyTier}
+			if err := CopyFromURL(ctx, b, "dst", "https://other.blob.core.windows.net/c/src", urlOpts); err != nil {
+				t.Fatal(err)
+			}
+			if gotTier != test.want {
+				t.Errorf("CopyFromURL: got x-ms-access-tier %q want %q", gotTier, test.want)
+			}
+		})
+	}
+}
+
+func TestWriterAccessTier(t *testing.T) {
+	tests := []struct {
+		description string
+		tier        azblob.AccessTierType
+		beforeWrite func(asFunc func(interface{}) bool) error
+		want        string
+	}{
+		{description: "default", want: ""},
+		{description: "bucket tier", tier: azblob.AccessTierCool, want: "Cool"},
+		{
+			description: "overridden by BeforeWrite",
+			tier:        azblob.AccessTierCool,
+			beforeWrite: func(asFunc func(interface{}) bool) error {
+				var opts *azblob.UploadStreamToBlockBlobOptions
+				if !asFunc(&opts) {
+					return errors.New("BeforeWrite As failed")
+				}
+				opts.BlobAccessTier = azblob.AccessTierArchive
+				return nil
+			},
+			want: "Archive",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var got string
+			b, done := newTestBucket(t, &Options{AccessTier: test.tier}, func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("x-ms-access-tier")
+				w.WriteHeader(http.StatusCreated)
+			})
+			defer done()
+			opts := &blob.WriterOptions{BeforeWrite: test.beforeWrite}
+			if err := b.WriteAll(context.Background(), "my-key", []byte("hello"), opts); err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got x-ms-access-tier %q want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteBufferSize(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, &Options{WriteBufferSize: 64}, fake.ServeHTTP)
+	defer done()
+
+	w, err := b.NewWriter(ctx, "my-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want strings.Builder
+	for i := 0; i < 1000; i++ {
+		chunk := fmt.Sprintf("%d,", i)
+		want.WriteString(chunk)
+		if _, err := io.WriteString(w, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fake.content("my-key")); got != want.String() {
+		t.Errorf("got %d bytes of content, want %d", len(got), want.Len())
+	}
+}
+
+func TestUploadBlockSize(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	// azblob uploads blocks of at least 1 MiB.
+	const blockSize = 1024 * 1024
+	b, done := newTestBucket(t, &Options{UploadBlockSize: blockSize, UploadBuffers: 2}, fake.ServeHTTP)
+	defer done()
+
+	// WriteAll would upload the data as a single block.
+	w, err := b.NewWriter(ctx, "my-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte("a"), 2*blockSize+452)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	list, err := GetBlockList(ctx, b, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, blk := range list.Committed {
+		got = append(got, blk.Size)
+	}
+	if want := []int64{blockSize, blockSize, 452}; !cmp.Equal(got, want) {
+		t.Errorf("got block sizes %v want %v", got, want)
+	}
+}
+
+func TestMaxUploadBufferMemory(t *testing.T) {
+	ctx := context.Background()
+	b, done := newTestBucket(t, &Options{MaxUploadBufferMemory: 1024 * 1024}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer done()
+
+	// w1 reserves all of the buffer memory until it is closed.
+	w1, err := b.NewWriter(ctx, "key1", &blob.WriterOptions{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w1.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// w2 can't start uploading while w1 is open.
+	ctx2, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	w2, err := b.NewWriter(ctx2, "key2", &blob.WriterOptions{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w2.Write([]byte("world")); err == nil {
+		t.Error("got nil error writing while buffer memory is exhausted, want error")
+	}
+	w2.Close()
+
+	if err := w1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Now that w1 is done, a new writer succeeds.
+	if err := b.WriteAll(ctx, "key3", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPooledTransferManager(t *testing.T) {
+	const size = 1024 * 1024
+	b := &bucket{}
+	m1 := b.newPooledTransferManager(size, 2)
+	m2 := b.newPooledTransferManager(size, 2)
+	if m1.pool != m2.pool {
+		t.Error("got different pools for the same buffer size, want shared")
+	}
+	if m3 := b.newPooledTransferManager(2*size, 2); m3.pool == m1.pool {
+		t.Error("got the same pool for different buffer sizes")
+	}
+	if m := b.newPooledTransferManager(10, 0); m.size != size || cap(m.sem) != 1 {
+		t.Errorf("got size %d, max buffers %d; want defaults %d, 1", m.size, cap(m.sem), size)
+	}
+
+	buf := m1.Get()
+	if len(buf) != size {
+		t.Fatalf("got buffer of length %d want %d", len(buf), size)
+	}
+	// azblob puts back the part of the buffer it used.
+	m1.Put(buf[:5])
+	if got := len(m2.Get()); got != size {
+		t.Errorf("got buffer of length %d after Put of a short slice, want %d", got, size)
+	}
+
+	// Get bounds the number of live buffers, and so the number of concurrent
+	// uploads, the way azblob uses the manager: Get, then Run a function that
+	// Puts the buffer back.
+	release := make(chan struct{})
+	var live, maxLive int32
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			buf := m1.Get()
+			mu.Lock()
+			live++
+			if live > maxLive {
+				maxLive = live
+			}
+			mu.Unlock()
+			wg.Add(1)
+			m1.Run(func() {
+				defer wg.Done()
+				<-release
+				mu.Lock()
+				live--
+				mu.Unlock()
+				m1.Put(buf)
+			})
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if maxLive != 2 {
+		t.Errorf("got %d live buffers want 2", maxLive)
+	}
+}
+
+func TestAttributesIfModifiedSince(t *testing.T) {
+	ctx := context.Background()
+	modTime := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil {
+			t.Errorf("bad If-Modified-Since header: %v", err)
+		}
+		if !modTime.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	})
+	defer done()
+
+	attrs, err := AttributesIfModifiedSince(ctx, b, "my-key", modTime.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attrs.ModTime.Equal(modTime) {
+		t.Errorf("got ModTime %v want %v", attrs.ModTime, modTime)
+	}
+
+	_, err = AttributesIfModifiedSince(ctx, b, "my-key", modTime)
+	if !errors.Is(err, ErrNotModified) {
+		t.Errorf("got error %v want ErrNotModified", err)
+	}
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got code %v want FailedPrecondition", gcerrors.Code(err))
+	}
+}
+
+func TestRawKeys(t *testing.T) {
+	for _, raw := range []bool{false, true} {
+		var gotPath string
+		b, done := newTestBucket(t, &Options{RawKeys: raw}, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("comp") == "list" {
+				writeListResponse(w, map[string]string{"a__0x5c__b": `"1"`})
+				return
+			}
+			gotPath = r.URL.Path
+			w.Header().Set("ETag", `"1"`)
+		})
+		defer done()
+		ctx := context.Background()
+
+		wantName, wantKey := "dir__0x2f__", `a\b`
+		if raw {
+			wantName, wantKey = "dir/", "a__0x5c__b"
+		}
+		if _, err := b.Attributes(ctx, "dir/"); err != nil {
+			t.Fatal(err)
+		}
+		if want := "/" + string(accountName) + "/mycontainer/" + wantName; gotPath != want {
+			t.Errorf("raw %v: Attributes: got path %q want %q", raw, gotPath, want)
+		}
+		u, err := SnapshotURL(b, "dir/", "2021-05-03T00:00:00.0000000Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(u, "/mycontainer/"+wantName+"?") {
+			t.Errorf("raw %v: SnapshotURL: got %q want blob name %q", raw, u, wantName)
+		}
+		obj, err := b.List(nil).Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Key != wantKey {
+			t.Errorf("raw %v: List: got key %q want %q", raw, obj.Key, wantKey)
+		}
+	}
+}
+
+func TestEscapeKey(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"dir/my-key", "dir/my-key"},
+		{"a\\b", "a__0x5c__b"},
+		{"dir/", "dir__0x2f__"},
+		{"../x", "..__0x2f__x"},
+		{"tab\tkey", "tab__0x9__key"},
+	}
+	for _, test := range tests {
+		if got := EscapeKey(test.key); got != test.want {
+			t.Errorf("EscapeKey(%q): got %q want %q", test.key, got, test.want)
+		}
+		if got := UnescapeKey(test.want); got != test.key {
+			t.Errorf("UnescapeKey(%q): got %q want %q", test.want, got, test.key)
+		}
+	}
+}
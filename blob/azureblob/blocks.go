@@ -0,0 +1,417 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/uuid"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+// Block describes a block of a block blob.
+type Block struct {
+	// ID is the block ID, as passed to StageBlock.
+	ID string
+	// Size is the size of the block in bytes.
+	Size int64
+}
+
+// BlockList is the list of blocks of a block blob.
+type BlockList struct {
+	// Committed are the blocks that make up the content of the blob, in order.
+	Committed []Block
+	// Uncommitted are the blocks that have been staged but not committed.
+	Uncommitted []Block
+}
+
+// StageBlock uploads data as a new block of the blob at key, without
+// committing it; the blob's content doesn't change until the block is
+// committed with CommitBlockList. Staged blocks survive across processes, so
+// an interrupted upload can be resumed by checking GetBlockList for the
+// blocks that were already staged.
+//
+// blockID identifies the block within the blob; all block IDs of a blob must
+// have the same length, at most 64 bytes. Staging a block with the ID of an
+// existing uncommitted block replaces it.
+// Uncommitted blocks are discarded by Azure after a week.
+func StageBlock(ctx context.Context, b *blob.Bucket, key, blockID string, data io.ReadSeeker) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	blockBlobURL := drv.containerURL.NewBlockBlobURL(drv.blobName(key))
+	_, err = blockBlobURL.StageBlock(ctx, encodeBlockID(blockID), data, azblob.LeaseAccessConditions{}, nil, azblob.ClientProvidedKeyOptions{})
+	return drv.wrapError(err, key)
+}
+
+// StageBlockFromURL is like StageBlock, but populates the block with data
+// that is already in Azure Storage instead of uploading it: the block is
+// copied server-side from count bytes of the blob at sourceURL, starting at
+// offset. A count of zero copies to the end of the source blob.
+//
+// sourceURL must be readable by Azure without further credentials, so it
+// must be public or include a SAS (see blob.Bucket.SignedURL); it may be in
+// another container or account.
+func StageBlockFromURL(ctx context.Context, b *blob.Bucket, key, blockID, sourceURL string, offset, count int64) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	src, err := parseSourceURL("StageBlockFromURL", sourceURL)
+	if err != nil {
+		return err
+	}
+	blockBlobURL := drv.containerURL.NewBlockBlobURL(drv.blobName(key))
+	_, err = blockBlobURL.StageBlockFromURL(ctx, encodeBlockID(blockID), *src, offset, count, azblob.LeaseAccessConditions{}, azblob.ModifiedAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	return drv.wrapError(err, key)
+}
+
+// GetBlockList returns the committed and uncommitted blocks of the blob at
+// key.
+func GetBlockList(ctx context.Context, b *blob.Bucket, key string) (*BlockList, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return nil, err
+	}
+	blockBlobURL := drv.containerURL.NewBlockBlobURL(drv.blobName(key))
+	resp, err := blockBlobURL.GetBlockList(ctx, azblob.BlockListAll, azblob.LeaseAccessConditions{})
+	if err != nil {
+		return nil, drv.wrapError(err, key)
+	}
+	committed, err := decodeBlocks(resp.CommittedBlocks)
+	if err != nil {
+		return nil, err
+	}
+	uncommitted, err := decodeBlocks(resp.UncommittedBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockList{Committed: committed, Uncommitted: uncommitted}, nil
+}
+
+// CommitBlockList sets the content of the blob at key to the blocks with the
+// given IDs, in order. Each block may be either a staged block or a
+// committed block of the current blob. Uncommitted blocks that aren't in
+// blockIDs are discarded.
+//
+// opts may be nil. Only the ContentType, CacheControl, ContentDisposition,
+// ContentEncoding, ContentLanguage, ContentMD5, and Metadata fields of opts
+// are used.
+func CommitBlockList(ctx context.Context, b *blob.Bucket, key string, blockIDs []string, opts *blob.WriterOptions) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &blob.WriterOptions{}
+	}
+	md, err := escapeMetadata(opts.Metadata)
+	if err != nil {
+		return err
+	}
+	h := azblob.BlobHTTPHeaders{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentMD5:         opts.ContentMD5,
+		ContentType:        opts.ContentType,
+	}
+	encoded := make([]string, len(blockIDs))
+	for i, id := range blockIDs {
+		encoded[i] = encodeBlockID(id)
+	}
+	blockBlobURL := drv.containerURL.NewBlockBlobURL(drv.blobName(key))
+	_, err = blockBlobURL.CommitBlockList(ctx, encoded, h, md, azblob.BlobAccessConditions{}, azblob.AccessTierNone, nil /* BlobTagsMap */, azblob.ClientProvidedKeyOptions{})
+	return drv.wrapError(err, key)
+}
+
+// AppendBlock appends data to the end of the block blob at key, creating the
+// blob if it doesn't exist. It stages data as a new block and commits it
+// after the blob's existing committed blocks, so blobs can be extended
+// without using append blobs. The blob's properties, metadata, access tier,
+// and index tags are kept.
+//
+// If the blob is modified concurrently, AppendBlock fails with an error
+// whose code is gcerrors.FailedPrecondition, or gcerrors.AlreadyExists if the
+// blob was created concurrently; the caller may retry.
+// AppendBlock fails with the same code if the blob has content that wasn't
+// written in blocks, as can happen for blobs not written by this package.
+func AppendBlock(ctx context.Context, b *blob.Bucket, key string, data io.ReadSeeker) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	blockBlobURL := drv.containerURL.NewBlockBlobURL(drv.blobName(key))
+	ac := azblob.BlobAccessConditions{}
+	var committed, uncommitted []azblob.Block
+	resp, err := blockBlobURL.GetBlockList(ctx, azblob.BlockListAll, azblob.LeaseAccessConditions{})
+	exists := err == nil && resp.ETag() != "" // a blob with only uncommitted blocks has no ETag
+	switch {
+	case exists:
+		committed, uncommitted = resp.CommittedBlocks, resp.UncommittedBlocks
+		if len(committed) == 0 && resp.BlobContentLength() > 0 {
+			return gcerr.Newf(gcerr.FailedPrecondition, nil, "azureblob: AppendBlock: blob %q has no committed blocks", key)
+		}
+		// Fail if the blob is modified before the new block is committed.
+		ac.ModifiedAccessConditions.IfMatch = resp.ETag()
+	case err == nil || drv.ErrorCode(err) == gcerr.NotFound:
+		if err == nil {
+			uncommitted = resp.UncommittedBlocks
+		}
+		// Fail if the blob is created before the new block is committed.
+		ac.ModifiedAccessConditions.IfNoneMatch = azblob.ETagAny
+	default:
+		return drv.wrapError(err, key)
+	}
+
+	blockIDs := make([]string, 0, len(committed)+1)
+	for _, blk := range committed {
+		blockIDs = append(blockIDs, blk.Name)
+	}
+	// All of the blob's block IDs must have the same length, including
+	// those of uncommitted blocks staged by others.
+	newID, err := newBlockID(append(committed, uncommitted...))
+	if err != nil {
+		return err
+	}
+	if _, err := blockBlobURL.StageBlock(ctx, newID, data, azblob.LeaseAccessConditions{}, nil, azblob.ClientProvidedKeyOptions{}); err != nil {
+		return drv.wrapError(err, key)
+	}
+	blockIDs = append(blockIDs, newID)
+
+	// Committing replaces the blob's properties, access tier, and index
+	// tags, so carry them over.
+	h := azblob.BlobHTTPHeaders{}
+	var md azblob.Metadata
+	tier := azblob.AccessTierNone
+	var tags azblob.BlobTagsMap
+	if exists {
+		props, err := blockBlobURL.GetProperties(ctx, azblob.BlobAccessConditions{ModifiedAccessConditions: ac.ModifiedAccessConditions}, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return drv.wrapError(err, key)
+		}
+		h = props.NewHTTPHeaders()
+		// The MD5 of the whole blob changes; let Azure drop it.
+		h.ContentMD5 = nil
+		md = props.NewMetadata()
+		if tier, tags, err = recommitOptions(ctx, blockBlobURL, props); err != nil {
+			return drv.wrapError(err, key)
+		}
+	}
+	_, err = blockBlobURL.CommitBlockList(ctx, blockIDs, h, md, ac, tier, tags, azblob.ClientProvidedKeyOptions{})
+	return drv.wrapError(err, key)
+}
+
+// UncommittedUpload describes a blob that has staged blocks but was never
+// committed, such as one left behind by an interrupted upload.
+type UncommittedUpload struct {
+	// Key is the key of the blob.
+	Key string
+	// Blocks are the blob's uncommitted blocks.
+	Blocks []Block
+	// Size is the total size of Blocks in bytes, i.e., the storage they use.
+	Size int64
+}
+
+// ListUncommittedUploads returns the blobs under prefix that have staged
+// blocks but no committed content, so that half-finished uploads can be found
+// and cleaned up with DiscardUncommittedBlocks before Azure discards them.
+//
+// Blobs with committed content and stray uncommitted blocks aren't
+// returned: a listing can't tell them apart from other blobs, and reading
+// the block list of every blob would cost a request per blob.
+func ListUncommittedUploads(ctx context.Context, b *blob.Bucket, prefix string) ([]*UncommittedUpload, error) {
+	incl := &ListInclude{Uncommitted: true}
+	iter := b.List(&blob.ListOptions{Prefix: prefix, BeforeList: incl.BeforeList})
+	var uploads []*UncommittedUpload
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return uploads, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Blobs that were never committed are listed as empty.
+		if obj.Size != 0 {
+			continue
+		}
+		// Only block blobs have blocks.
+		var item azblob.BlobItemInternal
+		if obj.As(&item) && item.Properties.BlobType != azblob.BlobNone && item.Properties.BlobType != azblob.BlobBlockBlob {
+			continue
+		}
+		list, err := GetBlockList(ctx, b, obj.Key)
+		switch gcerrors.Code(err) {
+		case gcerrors.NotFound:
+			// Committed or discarded since it was listed.
+			continue
+		case gcerrors.FailedPrecondition:
+			// Not a block blob, e.g. replaced by an append blob since it was
+			// listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(list.Committed) > 0 || len(list.Uncommitted) == 0 {
+			continue
+		}
+		u := &UncommittedUpload{Key: obj.Key, Blocks: list.Uncommitted}
+		for _, blk := range list.Uncommitted {
+			u.Size += blk.Size
+		}
+		uploads = append(uploads, u)
+	}
+}
+
+// DiscardUncommittedBlocks discards the uncommitted blocks of the blob at
+// key, such as those left behind by StageBlock or by an interrupted upload,
+// including the uploads of writers whose context was canceled. Otherwise
+// Azure discards them a week after they were staged, and they count as used
+// storage until then.
+//
+// Azure has no operation that only discards blocks. If the blob exists, its
+// committed blocks are committed again, keeping its content, properties,
+// metadata, access tier, and index tags but changing its ETag and
+// modification time. If it doesn't, an empty blob is committed and then
+// deleted, which other readers may observe. Either way, all of the
+// uncommitted blocks of the blob are discarded, so an upload to key that is
+// still in progress fails; only call DiscardUncommittedBlocks for keys that
+// aren't being written.
+func DiscardUncommittedBlocks(ctx context.Context, b *blob.Bucket, key string) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	err = drv.discardUncommittedBlocks(ctx, drv.containerURL.NewBlockBlobURL(drv.blobName(key)))
+	return drv.wrapError(err, key)
+}
+
+func (b *bucket) discardUncommittedBlocks(ctx context.Context, blockBlobURL azblob.BlockBlobURL) error {
+	list, err := blockBlobURL.GetBlockList(ctx, azblob.BlockListAll, azblob.LeaseAccessConditions{})
+	if err != nil {
+		if b.ErrorCode(err) == gcerr.NotFound {
+			return nil
+		}
+		return err
+	}
+	if len(list.UncommittedBlocks) == 0 {
+		return nil
+	}
+	props, err := blockBlobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil && b.ErrorCode(err) != gcerr.NotFound {
+		return err
+	}
+	if err == nil {
+		committed := make([]string, len(list.CommittedBlocks))
+		for i, blk := range list.CommittedBlocks {
+			committed[i] = blk.Name
+		}
+		tier, tags, err := recommitOptions(ctx, blockBlobURL, props)
+		if err != nil {
+			return err
+		}
+		ac := azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: props.ETag()}}
+		_, err = blockBlobURL.CommitBlockList(ctx, committed, props.NewHTTPHeaders(), props.NewMetadata(), ac, tier, tags, azblob.ClientProvidedKeyOptions{})
+		return err
+	}
+	// The blob only has uncommitted blocks.
+	ac := azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny}}
+	resp, err := blockBlobURL.CommitBlockList(ctx, nil, azblob.BlobHTTPHeaders{}, nil, ac, azblob.AccessTierNone, nil /* BlobTagsMap */, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return err
+	}
+	ac = azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: resp.ETag()}}
+	_, err = blockBlobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, ac)
+	return err
+}
+
+// recommitOptions returns the access tier and the index tags to commit the
+// block list of the existing blob at blockBlobURL, whose properties are
+// props, with, so that they are kept: committing a block list otherwise
+// resets the tier to the account's default and removes the tags.
+func recommitOptions(ctx context.Context, blockBlobURL azblob.BlockBlobURL, props *azblob.BlobGetPropertiesResponse) (azblob.AccessTierType, azblob.BlobTagsMap, error) {
+	tier := azblob.AccessTierNone
+	if props.AccessTierInferred() != "true" {
+		tier = azblob.AccessTierType(props.AccessTier())
+	}
+	if props.TagCount() == 0 {
+		return tier, nil, nil
+	}
+	tags, err := blockBlobURL.GetTags(ctx, nil, nil, nil, nil, nil)
+	if err != nil {
+		return azblob.AccessTierNone, nil, err
+	}
+	return tier, azblob.BlobTagsMap(tagsToMap(tags.BlobTagSet)), nil
+}
+
+// maxBlockIDAttempts bounds the number of random IDs tried by newBlockID,
+// since short IDs leave few unused ones, or none.
+const maxBlockIDAttempts = 100
+
+// newBlockID returns a new encoded block ID that doesn't collide with the IDs
+// of blocks and has the same length, as Azure requires. It fails with
+// FailedPrecondition if it can't find one.
+func newBlockID(blocks []azblob.Block) (string, error) {
+	if len(blocks) == 0 {
+		return encodeBlockID(uuid.New().String()), nil
+	}
+	existing := make(map[string]bool, len(blocks))
+	for _, blk := range blocks {
+		existing[blk.Name] = true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(blocks[0].Name)
+	if err != nil {
+		return "", fmt.Errorf("azureblob: invalid block ID %q: %v", blocks[0].Name, err)
+	}
+	if len(decoded) == 0 {
+		return "", gcerr.Newf(gcerr.FailedPrecondition, nil, "azureblob: the blob has an empty block ID, so no block can be added")
+	}
+	for i := 0; i < maxBlockIDAttempts; i++ {
+		id := make([]byte, len(decoded))
+		u := uuid.New()
+		copy(id, u[:])
+		if encoded := base64.StdEncoding.EncodeToString(id); !existing[encoded] {
+			return encoded, nil
+		}
+	}
+	return "", gcerr.Newf(gcerr.FailedPrecondition, nil, "azureblob: found no unused block ID of %d bytes for the blob", len(decoded))
+}
+
+// encodeBlockID encodes a block ID as required by Azure.
+func encodeBlockID(id string) string {
+	return base64.StdEncoding.EncodeToString([]byte(id))
+}
+
+func decodeBlocks(blocks []azblob.Block) ([]Block, error) {
+	var ret []Block
+	for _, blk := range blocks {
+		id, err := base64.StdEncoding.DecodeString(blk.Name)
+		if err != nil {
+			return nil, fmt.Errorf("azureblob: invalid block ID %q: %v", blk.Name, err)
+		}
+		ret = append(ret, Block{ID: string(id), Size: blk.Size})
+	}
+	return ret, nil
+}
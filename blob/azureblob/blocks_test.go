@@ -0,0 +1,607 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// fakeBlockBlobs is an in-memory implementation of the parts of the Azure
+// block blob API used for staging and committing blocks.
+type fakeBlockBlobs struct {
+	mu          sync.Mutex
+	blocks      map[string][]byte   // "blobname/blockid" -> data, for all blocks
+	committed   map[string][]string // blob name -> committed block IDs
+	uncommitted map[string][]string // blob name -> uncommitted block IDs
+	versions    map[string]int      // blob name -> number of commits, used as ETag
+	tags        map[string]string   // blob name -> x-ms-tags of the last commit
+	tiers       map[string]string   // blob name -> x-ms-access-tier of the last commit
+	blobTypes   map[string]string   // blob name -> BlobType to list, if set
+	md5s        map[string]string   // blob name -> x-ms-blob-content-md5 of the last commit
+}
+
+func newFakeBlockBlobs() *fakeBlockBlobs {
+	return &fakeBlockBlobs{
+		blocks:      map[string][]byte{},
+		committed:   map[string][]string{},
+		uncommitted: map[string][]string{},
+		versions:    map[string]int{},
+		tags:        map[string]string{},
+		tiers:       map[string]string{},
+		blobTypes:   map[string]string{},
+		md5s:        map[string]string{},
+	}
+}
+
+func (f *fakeBlockBlobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/gocloudblobtests/mycontainer/")
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && q.Get("comp") == "list":
+		f.serveList(w, q)
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		data, _ := ioutil.ReadAll(r.Body)
+		if src := r.Header.Get("x-ms-copy-source"); src != "" {
+			u, err := url.Parse(src)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data = f.content(strings.TrimPrefix(u.Path, "/gocloudblobtests/mycontainer/"))
+			var start, end int
+			if _, err := fmt.Sscanf(r.Header.Get("x-ms-source-range"), "bytes=%d-%d", &start, &end); err == nil {
+				data = data[start : end+1]
+			}
+		}
+		id := q.Get("blockid")
+		// All of a blob's block IDs must have the same length.
+		for _, other := range append(f.committed[name], f.uncommitted[name]...) {
+			if len(other) != len(id) {
+				w.Header().Set("x-ms-error-code", "InvalidBlobOrBlock")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		f.blocks[name+"/"+id] = data
+		f.uncommitted[name] = append(f.uncommitted[name], id)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, exists := f.committed[name]
+		if m := r.Header.Get("If-Match"); m != "" && (!exists || m != f.etag(name)) {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			w.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.committed[name] = list.Latest
+		f.tags[name] = r.Header.Get("x-ms-tags")
+		f.tiers[name] = r.Header.Get("x-ms-access-tier")
+		f.md5s[name] = r.Header.Get("x-ms-blob-content-md5")
+		f.versions[name]++
+		delete(f.uncommitted, name)
+		w.Header().Set("ETag", f.etag(name))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && q.Get("comp") == "blocklist":
+		if _, ok := f.committed[name]; !ok && len(f.uncommitted[name]) == 0 {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, ok := f.committed[name]; ok {
+			w.Header().Set("ETag", f.etag(name))
+		}
+		var sb strings.Builder
+		sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList><CommittedBlocks>`)
+		for _, id := range f.committed[name] {
+			fmt.Fprintf(&sb, "<Block><Name>%s</Name><Size>%d</Size></Block>", id, len(f.blocks[name+"/"+id]))
+		}
+		sb.WriteString("</CommittedBlocks><UncommittedBlocks>")
+		for _, id := range f.uncommitted[name] {
+			fmt.Fprintf(&sb, "<Block><Name>%s</Name><Size>%d</Size></Block>", id, len(f.blocks[name+"/"+id]))
+		}
+		sb.WriteString("</UncommittedBlocks></BlockList>")
+		fmt.Fprint(w, sb.String())
+	case r.Method == http.MethodGet && q.Get("comp") == "tags":
+		tags, _ := url.ParseQuery(f.tags[name])
+		var sb strings.Builder
+		sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><Tags><TagSet>`)
+		for k := range tags {
+			fmt.Fprintf(&sb, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", k, tags.Get(k))
+		}
+		sb.WriteString("</TagSet></Tags>")
+		fmt.Fprint(w, sb.String())
+	case r.Method == http.MethodHead:
+		if _, ok := f.committed[name]; !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etag(name))
+		if tags, _ := url.ParseQuery(f.tags[name]); len(tags) > 0 {
+			w.Header().Set("x-ms-tag-count", fmt.Sprint(len(tags)))
+		}
+		if tier := f.tiers[name]; tier != "" {
+			w.Header().Set("x-ms-access-tier", tier)
+		} else {
+			w.Header().Set("x-ms-access-tier", "Hot")
+			w.Header().Set("x-ms-access-tier-inferred", "true")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		if md5 := f.md5s[name]; md5 != "" {
+			w.Header().Set("Content-MD5", md5)
+		}
+	case r.Method == http.MethodGet:
+		if _, ok := f.committed[name]; !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etag(name))
+		fmt.Fprint(w, string(f.content(name)))
+	case r.Method == http.MethodDelete:
+		if _, ok := f.committed[name]; !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != f.etag(name) {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		delete(f.committed, name)
+		delete(f.uncommitted, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// serveList serves a flat List Blobs request, including blobs that were
+// never committed if requested.
+func (f *fakeBlockBlobs) serveList(w http.ResponseWriter, q url.Values) {
+	names := map[string]bool{}
+	for name := range f.committed {
+		names[name] = true
+	}
+	if strings.Contains(q.Get("include"), "uncommittedblobs") {
+		for name, ids := range f.uncommitted {
+			if len(ids) > 0 {
+				names[name] = true
+			}
+		}
+	}
+	var sorted []string
+	for name := range names {
+		if strings.HasPrefix(name, q.Get("prefix")) {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="mycontainer"><Blobs>`)
+	for _, name := range sorted {
+		var blobType string
+		if t := f.blobTypes[name]; t != "" {
+			blobType = "<BlobType>" + t + "</BlobType>"
+		}
+		fmt.Fprintf(&sb, `<Blob><Name>%s</Name><Properties><Last-Modified>Mon, 03 May 2021 00:00:00 GMT</Last-Modified><Etag>%s</Etag><Content-Length>%d</Content-Length>%s</Properties></Blob>`, name, f.etag(name), len(f.content(name)), blobType)
+	}
+	sb.WriteString(`</Blobs><NextMarker /></EnumerationResults>`)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, sb.String())
+}
+
+// etag returns the ETag of the committed blob name.
+func (f *fakeBlockBlobs) etag(name string) string {
+	return fmt.Sprintf(`"%d"`, f.versions[name])
+}
+
+// content returns the committed content of the blob name.
+func (f *fakeBlockBlobs) content(name string) []byte {
+	var buf bytes.Buffer
+	for _, id := range f.committed[name] {
+		buf.Write(f.blocks[name+"/"+id])
+	}
+	return buf.Bytes()
+}
+
+func TestStageAndCommitBlocks(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	if err := StageBlock(ctx, b, "my-key", "block-1", strings.NewReader("world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := StageBlock(ctx, b, "my-key", "block-0", strings.NewReader("hello ")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetBlockList(ctx, b, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BlockList{Uncommitted: []Block{{ID: "block-1", Size: 5}, {ID: "block-0", Size: 6}}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("GetBlockList diff (-got +want):\n%s", diff)
+	}
+
+	if err := CommitBlockList(ctx, b, "my-key", []string{"block-0", "block-1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err = GetBlockList(ctx, b, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &BlockList{Committed: []Block{{ID: "block-0", Size: 6}, {ID: "block-1", Size: 5}}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("GetBlockList diff (-got +want):\n%s", diff)
+	}
+	if got := string(fake.content("my-key")); got != "hello world" {
+		t.Errorf("got content %q want %q", got, "hello world")
+	}
+}
+
+func TestStageBlockFromURL(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	if err := StageBlock(ctx, b, "src", "block-0", strings.NewReader("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitBlockList(ctx, b, "src", []string{"block-0"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var containerURL *azblob.ContainerURL
+	if !b.As(&containerURL) {
+		t.Fatal("As failed")
+	}
+	srcBlobURL := containerURL.NewBlobURL("src")
+	srcURL := srcBlobURL.URL()
+	if err := StageBlockFromURL(ctx, b, "dst", "block-0", srcURL.String(), 6, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := StageBlock(ctx, b, "dst", "block-1", strings.NewReader(", hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitBlockList(ctx, b, "dst", []string{"block-0", "block-1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(fake.content("dst")), "world, hello"; got != want {
+		t.Errorf("got content %q want %q", got, want)
+	}
+
+	// The error doesn't leak the URL, which may contain a SAS.
+	err := StageBlockFromURL(ctx, b, "dst", "block-2", "https://host/%zz?sig=secret", 0, 0)
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q contains the source URL", err)
+	}
+}
+
+func TestDiscardUncommittedBlocks(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	// A blob that exists keeps its content, tier, and tags.
+	beforeWrite := func(as func(interface{}) bool) error {
+		var opts *azblob.UploadStreamToBlockBlobOptions
+		if !as(&opts) {
+			return errors.New("BeforeWrite.As failed")
+		}
+		opts.BlobAccessTier = azblob.AccessTierCool
+		opts.BlobTagsMap = azblob.BlobTagsMap{"state": "done"}
+		return nil
+	}
+	if err := b.WriteAll(ctx, "exists", []byte("hello"), &blob.WriterOptions{BeforeWrite: beforeWrite}); err != nil {
+		t.Fatal(err)
+	}
+	// The staged block's ID must have the length of the blob's block IDs.
+	list, err := GetBlockList(ctx, b, "exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagedID := strings.Repeat("s", len(list.Committed[0].ID))
+	// A blob that only has uncommitted blocks disappears.
+	for _, key := range []string{"exists", "new"} {
+		if err := StageBlock(ctx, b, key, stagedID, strings.NewReader("world")); err != nil {
+			t.Fatal(err)
+		}
+		if err := DiscardUncommittedBlocks(ctx, b, key); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(fake.uncommitted[key]); n != 0 {
+			t.Errorf("%s: got %d uncommitted blocks want 0", key, n)
+		}
+	}
+	if got := string(fake.content("exists")); got != "hello" {
+		t.Errorf("got content %q want %q", got, "hello")
+	}
+	if got := fake.tiers["exists"]; got != "Cool" {
+		t.Errorf("got tier %q want %q", got, "Cool")
+	}
+	if got := fake.tags["exists"]; got != "state=done" {
+		t.Errorf("got tags %q want %q", got, "state=done")
+	}
+	if _, ok := fake.committed["new"]; ok {
+		t.Error("blob with only uncommitted blocks exists after DiscardUncommittedBlocks")
+	}
+	// Nothing to discard.
+	if err := DiscardUncommittedBlocks(ctx, b, "missing"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListUncommittedUploads(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Get("comp") == "blocklist" {
+			switch strings.TrimPrefix(r.URL.Path, "/gocloudblobtests/mycontainer/") {
+			case "dir/append":
+				t.Error("got block list request for an append blob")
+			case "dir/page":
+				// Listed without a blob type.
+				w.Header().Set("x-ms-error-code", "InvalidBlobType")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	})
+	defer done()
+
+	// Empty blobs of other types are skipped.
+	fake.committed["dir/append"] = nil
+	fake.blobTypes["dir/append"] = "AppendBlob"
+	fake.committed["dir/page"] = nil
+
+	if err := b.WriteAll(ctx, "dir/committed", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "dir/empty", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	list, err := GetBlockList(ctx, b, "dir/committed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := StageBlock(ctx, b, "dir/committed", strings.Repeat("s", len(list.Committed[0].ID)), strings.NewReader("world")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"dir/stale", "other"} {
+		if err := StageBlock(ctx, b, key, "block-0", strings.NewReader("world")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ListUncommittedUploads(ctx, b, "dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*UncommittedUpload{{Key: "dir/stale", Blocks: []Block{{ID: "block-0", Size: 5}}, Size: 5}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ListUncommittedUploads diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestCanceledUploadKeepsBlob(t *testing.T) {
+	fake := newFakeBlockBlobs()
+	const maxMem = 4 * 1024 * 1024
+	b, done := newTestBucket(t, &Options{MaxUploadBufferMemory: maxMem}, fake.ServeHTTP)
+	defer done()
+	if err := b.WriteAll(context.Background(), "my-key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	etag := fake.etag("my-key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := b.NewWriter(ctx, "my-key", &blob.WriterOptions{BufferSize: 1024 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 1024*1024+1)); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the first block to be staged.
+	waitFor(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.uncommitted["my-key"]) > 0
+	})
+	cancel()
+	if err := w.Close(); gcerrors.Code(err) != gcerrors.Canceled {
+		t.Errorf("got error %v want Canceled", err)
+	}
+	// The upload stopped before Close returned, releasing its buffer memory.
+	drv, err := driverBucket(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drv.uploadMem.TryAcquire(maxMem) {
+		t.Error("upload is still running after Close")
+	}
+	// The staged blocks are left alone, and so is the existing blob.
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if got := fake.etag("my-key"); got != etag {
+		t.Errorf("got ETag %s want %s", got, etag)
+	}
+	if got := string(fake.content("my-key")); got != "hello" {
+		t.Errorf("got content %q want %q", got, "hello")
+	}
+}
+
+// waitFor waits up to 10 seconds for cond to become true.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("timed out waiting for condition")
+		}
+	}
+}
+
+func TestAppendBlock(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	for _, data := range []string{"hello", " ", "world"} {
+		if err := AppendBlock(ctx, b, "my-key", strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := string(fake.content("my-key")); got != "hello world" {
+		t.Errorf("got content %q want %q", got, "hello world")
+	}
+	got, err := GetBlockList(ctx, b, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Committed) != 3 {
+		t.Fatalf("got %d committed blocks want 3", len(got.Committed))
+	}
+	for _, blk := range got.Committed[1:] {
+		if len(blk.ID) != len(got.Committed[0].ID) {
+			t.Errorf("got block IDs of different lengths: %q, %q", got.Committed[0].ID, blk.ID)
+		}
+	}
+
+	// The access tier and index tags are kept.
+	beforeWrite := func(as func(interface{}) bool) error {
+		var opts *azblob.UploadStreamToBlockBlobOptions
+		if !as(&opts) {
+			return errors.New("BeforeWrite.As failed")
+		}
+		opts.BlobAccessTier = azblob.AccessTierCool
+		opts.BlobTagsMap = azblob.BlobTagsMap{"state": "done"}
+		return nil
+	}
+	if err := b.WriteAll(ctx, "tiered", []byte("hello"), &blob.WriterOptions{BeforeWrite: beforeWrite}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendBlock(ctx, b, "tiered", strings.NewReader(" world")); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.tiers["tiered"]; got != string(azblob.AccessTierCool) {
+		t.Errorf("got tier %q want %q", got, azblob.AccessTierCool)
+	}
+	if got := fake.tags["tiered"]; got != "state=done" {
+		t.Errorf("got tags %q want %q", got, "state=done")
+	}
+
+	// The new block ID has the length of the blob's uncommitted blocks.
+	if err := StageBlock(ctx, b, "staged", "block-0", strings.NewReader("staged")); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendBlock(ctx, b, "staged", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fake.content("staged")); got != "hello" {
+		t.Errorf("got content %q want %q", got, "hello")
+	}
+}
+
+func TestAppendBlockConcurrentModification(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		// Simulate another writer committing between the read of the block
+		// list and the commit.
+		if r.Method == http.MethodPut && r.URL.Query().Get("comp") == "blocklist" {
+			fake.mu.Lock()
+			fake.versions["my-key"]++
+			fake.mu.Unlock()
+		}
+		fake.ServeHTTP(w, r)
+	})
+	defer done()
+
+	if err := AppendBlock(ctx, b, "my-key", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	err := AppendBlock(ctx, b, "my-key", strings.NewReader("world"))
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v want FailedPrecondition", err)
+	}
+	if got := string(fake.content("my-key")); got != "hello" {
+		t.Errorf("got content %q want %q", got, "hello")
+	}
+}
+
+func TestNewBlockID(t *testing.T) {
+	id := func(b ...byte) azblob.Block {
+		return azblob.Block{Name: base64.StdEncoding.EncodeToString(b)}
+	}
+	existing := []azblob.Block{id(1, 2, 3, 4), id(5, 6, 7, 8)}
+	got, err := newBlockID(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(existing[0].Name) || got == existing[0].Name || got == existing[1].Name {
+		t.Errorf("got ID %q, want a new one as long as %q", got, existing[0].Name)
+	}
+
+	// All of the 1-byte IDs are used.
+	var full []azblob.Block
+	for i := 0; i < 256; i++ {
+		full = append(full, id(byte(i)))
+	}
+	for _, blocks := range [][]azblob.Block{{id()}, full} {
+		if _, err := newBlockID(blocks); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+			t.Errorf("%d blocks with IDs like %q: got error %v want FailedPrecondition", len(blocks), blocks[0].Name, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+)
+
+const defaultCacheMaxSize = 64 * 1024 * 1024
+
+// CacheOptions sets options for NewCache.
+type CacheOptions struct {
+	// MaxSize is the maximum total size in bytes of the cached blobs.
+	// When it is exceeded, the least recently read blobs are dropped from
+	// the cache; blobs larger than MaxSize are never cached.
+	// Defaults to 64 MiB.
+	MaxSize int64
+}
+
+// Cache is an in-memory read-through cache of the contents of the blobs of a
+// bucket, for blobs that are read often but rarely change, like
+// configuration files.
+//
+// Reads of a cached blob revalidate it with a conditional request on its
+// ETag; if the blob hasn't changed, Azure responds without sending its
+// contents again, and the cached contents are returned. Reads always return
+// the current contents of the blob, but cost a request each.
+//
+// A Cache is safe for concurrent use.
+type Cache struct {
+	b       *blob.Bucket
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64                    // total size of the cached blobs
+	entries map[string]*list.Element // by key; values are *cacheEntry
+	lru     *list.List               // most recently read first
+}
+
+type cacheEntry struct {
+	key  string
+	etag azblob.ETag
+	data []byte
+}
+
+// NewCache returns a Cache for the blobs of b. opts may be nil.
+func NewCache(b *blob.Bucket, opts *CacheOptions) *Cache {
+	maxSize := int64(defaultCacheMaxSize)
+	if opts != nil && opts.MaxSize > 0 {
+		maxSize = opts.MaxSize
+	}
+	return &Cache{
+		b:       b,
+		maxSize: maxSize,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// ReadAll returns the contents of the blob at key, from the cache if the
+// blob hasn't changed since it was cached. The returned slice may be shared
+// with other callers and must not be modified.
+func (c *Cache) ReadAll(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	var cached *cacheEntry
+	if e, ok := c.entries[key]; ok {
+		cached = e.Value.(*cacheEntry)
+	}
+	c.mu.Unlock()
+
+	var opts *blob.ReaderOptions
+	if cached != nil {
+		opts = &blob.ReaderOptions{BeforeRead: func(asFunc func(interface{}) bool) error {
+			var ac *azblob.BlobAccessConditions
+			if !asFunc(&ac) {
+				return errors.New("azureblob: Cache can only be used with azureblob buckets")
+			}
+			ac.ModifiedAccessConditions.IfNoneMatch = cached.etag
+			return nil
+		}}
+	}
+	r, err := c.b.NewReader(ctx, key, opts)
+	if errors.Is(err, ErrNotModified) {
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(e)
+		}
+		c.mu.Unlock()
+		return cached.data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var resp azblob.DownloadResponse
+	if !r.As(&resp) {
+		return nil, fmt.Errorf("azureblob: Cache: unexpected reader for %q", key)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.put(&cacheEntry{key: key, etag: resp.ETag(), data: data})
+	return data, nil
+}
+
+// put adds e to the cache, replacing any entry for the same key and dropping
+// the least recently read entries to stay within c.maxSize.
+func (c *Cache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[e.key]; ok {
+		c.remove(old)
+	}
+	if int64(len(e.data)) > c.maxSize || e.etag == azblob.ETagNone {
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += int64(len(e.data))
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.data))
+}
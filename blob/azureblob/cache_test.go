@@ -0,0 +1,77 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	var mu sync.Mutex
+	contents := map[string]string{"a": "aaaa", "b": "bbbb"}
+	versions := map[string]int{"a": 1, "b": 1}
+	downloads := 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := path.Base(r.URL.Path)
+		etag := fmt.Sprintf(`"%d"`, versions[key])
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, contents[key])
+	})
+	defer done()
+	ctx := context.Background()
+	c := NewCache(b, &CacheOptions{MaxSize: 6})
+
+	read := func(key, want string, wantDownloads int) {
+		t.Helper()
+		got, err := c.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("ReadAll(%q) got %q want %q", key, got, want)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if downloads != wantDownloads {
+			t.Errorf("ReadAll(%q): got %d downloads want %d", key, downloads, wantDownloads)
+		}
+	}
+
+	read("a", "aaaa", 1)
+	read("a", "aaaa", 1) // revalidated
+
+	mu.Lock()
+	contents["a"], versions["a"] = "AAAA", 2
+	mu.Unlock()
+	read("a", "AAAA", 2)
+	read("a", "AAAA", 2)
+
+	// Caching b evicts a, as both don't fit.
+	read("b", "bbbb", 3)
+	read("b", "bbbb", 3)
+	read("a", "AAAA", 4)
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// cacheBustParam is the query parameter added to the URL of reads made with
+// CacheBusting. Azure ignores it.
+const cacheBustParam = "cachebust"
+
+// cacheBustSeq makes cache-busting values unique within the process, even
+// for reads started in the same nanosecond.
+var cacheBustSeq uint64
+
+// CacheBusting makes a read add a unique query parameter to the blob's URL,
+// so that a CDN (see Options.IsCDN) forwards it to the storage account
+// instead of serving a cached copy. Use it to read blobs that were just
+// written, before the CDN's cache expires. To use it, set
+// blob.ReaderOptions.BeforeRead to the BeforeRead method:
+//
+//   opts := &blob.ReaderOptions{BeforeRead: azureblob.CacheBusting{}.BeforeRead}
+//   r, err := bucket.NewReader(ctx, key, opts)
+//
+// It only bypasses the cache if the CDN endpoint caches every unique URL,
+// which is the default query string caching behavior of Azure CDN; with
+// other behaviors the parameter is ignored and the read may be stale. To
+// always read from the storage account directly, open a second bucket for
+// the account without IsCDN instead.
+type CacheBusting struct{}
+
+// BeforeRead applies c to a read; see CacheBusting.
+func (c CacheBusting) BeforeRead(asFunc func(interface{}) bool) error {
+	var s *readSettings
+	if !asFunc(&s) {
+		return errors.New("azureblob: CacheBusting can only be used with azureblob buckets")
+	}
+	s.cacheBusting = true
+	return nil
+}
+
+// cacheBustURL returns u, using p, with a unique cache-busting query
+// parameter.
+func cacheBustURL(u azblob.BlockBlobURL, p pipeline.Pipeline) azblob.BlockBlobURL {
+	raw := u.URL()
+	q := raw.Query()
+	n := atomic.AddUint64(&cacheBustSeq, 1)
+	q.Set(cacheBustParam, strconv.FormatInt(time.Now().UnixNano(), 36)+"-"+strconv.FormatUint(n, 36))
+	raw.RawQuery = q.Encode()
+	return azblob.NewBlockBlobURL(raw, p)
+}
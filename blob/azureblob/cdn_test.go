@@ -0,0 +1,42 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestCacheBusting(t *testing.T) {
+	var got []string
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Query().Get(cacheBustParam))
+		fmt.Fprint(w, "hello")
+	})
+	defer done()
+
+	busting := &blob.ReaderOptions{BeforeRead: CacheBusting{}.BeforeRead}
+	for _, opts := range []*blob.ReaderOptions{nil, busting, busting} {
+		if _, err := readAll(b, "my-key", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 3 || got[0] != "" || got[1] == "" || got[2] == "" || got[1] == got[2] {
+		t.Errorf("got %s values %q, want none for the first read and unique ones for the others", cacheBustParam, got)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// ContainerExists reports whether the bucket's container exists.
+//
+// Errors for operations on blobs in a missing container have the code
+// gcerrors.NotFound, as for missing blobs; use ContainerExists or
+// IsContainerNotFound to tell the two apart.
+func ContainerExists(ctx context.Context, b *blob.Bucket) (bool, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return false, err
+	}
+	_, err = drv.containerURL.GetProperties(ctx, azblob.LeaseAccessConditions{})
+	if err == nil {
+		return true, nil
+	}
+	if drv.ErrorCode(err) == gcerrors.NotFound {
+		return false, nil
+	}
+	return false, drv.wrapError(err, "")
+}
+
+// IsContainerNotFound reports whether err was caused by the bucket's
+// container not existing, as opposed to a missing blob.
+func IsContainerNotFound(err error) bool {
+	var serr azblob.StorageError
+	return errors.As(err, &serr) && serr.ServiceCode() == azblob.ServiceCodeContainerNotFound
+}
+
+// ContainerAccessPolicy is the access policy of a container.
+type ContainerAccessPolicy struct {
+	// PublicAccess is the level of anonymous read access to the container:
+	// azblob.PublicAccessNone, azblob.PublicAccessBlob for blobs only, or
+	// azblob.PublicAccessContainer for blobs and listing.
+	PublicAccess azblob.PublicAccessType
+	// Identifiers are the stored access policies of the container, which
+	// SASs can refer to by ID. Azure allows at most five.
+	Identifiers []azblob.SignedIdentifier
+}
+
+// GetAccessPolicy returns the access policy of the bucket's container.
+func GetAccessPolicy(ctx context.Context, b *blob.Bucket) (*ContainerAccessPolicy, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := drv.containerURL.GetAccessPolicy(ctx, azblob.LeaseAccessConditions{})
+	if err != nil {
+		return nil, drv.wrapError(err, "")
+	}
+	return &ContainerAccessPolicy{PublicAccess: resp.BlobPublicAccess(), Identifiers: resp.Items}, nil
+}
+
+// SetAccessPolicy replaces the access policy of the bucket's container with
+// p. Existing stored access policies that aren't in p.Identifiers are
+// removed, which revokes any SAS that refers to them.
+func SetAccessPolicy(ctx context.Context, b *blob.Bucket, p *ContainerAccessPolicy) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	_, err = drv.containerURL.SetAccessPolicy(ctx, p.PublicAccess, p.Identifiers, azblob.ContainerAccessConditions{})
+	return drv.wrapError(err, "")
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/gcerrors"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	ctx := context.Background()
+	var gotAccess, gotBody string
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/gocloudblobtests/mycontainer" || q.Get("restype") != "container" || q.Get("comp") != "acl" {
+			t.Errorf("got %s %s", r.Method, r.URL)
+		}
+		switch r.Method {
+		case http.MethodPut:
+			gotAccess = r.Header.Get("x-ms-blob-public-access")
+			body, _ := ioutil.ReadAll(r.Body)
+			gotBody = string(body)
+		case http.MethodGet:
+			w.Header().Set("x-ms-blob-public-access", "blob")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><SignedIdentifiers><SignedIdentifier><Id>readers</Id><AccessPolicy><Start>2021-01-01T00:00:00.0000000Z</Start><Expiry>2022-01-01T00:00:00.0000000Z</Expiry><Permission>r</Permission></AccessPolicy></SignedIdentifier></SignedIdentifiers>`)
+		}
+	})
+	defer done()
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiry := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	perm := "r"
+	want := &ContainerAccessPolicy{
+		PublicAccess: azblob.PublicAccessBlob,
+		Identifiers: []azblob.SignedIdentifier{{
+			ID:           "readers",
+			AccessPolicy: azblob.AccessPolicy{Start: &start, Expiry: &expiry, Permission: &perm},
+		}},
+	}
+	if err := SetAccessPolicy(ctx, b, want); err != nil {
+		t.Fatal(err)
+	}
+	if gotAccess != "blob" {
+		t.Errorf("got x-ms-blob-public-access %q want %q", gotAccess, "blob")
+	}
+	if !strings.Contains(gotBody, "<Id>readers</Id>") {
+		t.Errorf("got body %q, missing identifier", gotBody)
+	}
+
+	got, err := GetAccessPolicy(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("GetAccessPolicy diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestCheckContainer(t *testing.T) {
+	for _, exists := range []bool{true, false} {
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path != "/gocloudblobtests/mycontainer" || r.URL.Query().Get("restype") != "container" {
+				t.Errorf("got %s %s, want a container request", r.Method, r.URL)
+			}
+			if !exists {
+				w.Header().Set("x-ms-error-code", "ContainerNotFound")
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		p := NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{Retry: azblob.RetryOptions{MaxTries: 1}})
+		b, err := OpenBucket(context.Background(), p, accountName, "mycontainer", &Options{
+			Protocol:       "http",
+			StorageDomain:  StorageDomain(strings.TrimPrefix(srv.URL, "http://")),
+			CheckContainer: true,
+		})
+		srv.Close()
+		if exists {
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Close()
+		} else if gcerrors.Code(err) != gcerrors.NotFound || !IsContainerNotFound(err) {
+			t.Errorf("got error %v want ContainerNotFound", err)
+		}
+		if requests != 1 {
+			t.Errorf("got %d requests want 1", requests)
+		}
+	}
+}
+
+func TestContainerExists(t *testing.T) {
+	ctx := context.Background()
+	exists := true
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if !exists {
+			w.Header().Set("x-ms-error-code", "ContainerNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path != "/gocloudblobtests/mycontainer" {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer done()
+
+	for _, exists = range []bool{true, false} {
+		got, err := ContainerExists(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != exists {
+			t.Errorf("got ContainerExists %v want %v", got, exists)
+		}
+		_, err = b.Attributes(ctx, "my-key")
+		if gcerrors.Code(err) != gcerrors.NotFound {
+			t.Errorf("got error %v want NotFound", err)
+		}
+		if got := IsContainerNotFound(err); got != !exists {
+			t.Errorf("got IsContainerNotFound %v want %v", got, !exists)
+		}
+	}
+}
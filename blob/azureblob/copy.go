@@ -0,0 +1,347 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/internal/gcerr"
+)
+
+// CopyConditions sets conditions for copies. To use them, set
+// blob.CopyOptions.BeforeCopy to the BeforeCopy method:
+//
+//   conds := &azureblob.CopyConditions{SourceIfMatch: etag, IfNotExists: true}
+//   err := bucket.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{BeforeCopy: conds.BeforeCopy})
+//
+// A copy whose source condition is not met fails with an error whose code is
+// gcerrors.FailedPrecondition. A copy with IfNotExists fails with an error
+// whose code is gcerrors.AlreadyExists if the destination exists.
+type CopyConditions struct {
+	// SourceIfMatch, if set, makes the copy fail unless the ETag of the
+	// source blob matches it, so that a source modified since it was last
+	// read isn't copied.
+	SourceIfMatch string
+
+	// IfNotExists makes the copy fail if the destination blob exists.
+	IfNotExists bool
+
+	// IfMatch, if set, makes the copy fail unless the ETag of the
+	// destination blob matches it, so that a concurrent write to the
+	// destination isn't clobbered.
+	IfMatch string
+}
+
+// BeforeCopy applies c to a copy; see CopyConditions.
+func (c *CopyConditions) BeforeCopy(asFunc func(interface{}) bool) error {
+	var src *azblob.ModifiedAccessConditions
+	var dst *azblob.BlobAccessConditions
+	if !asFunc(&src) || !asFunc(&dst) {
+		return errors.New("azureblob: CopyConditions can only be used with azureblob buckets")
+	}
+	if c.SourceIfMatch != "" {
+		src.IfMatch = azblob.ETag(c.SourceIfMatch)
+	}
+	if c.IfNotExists {
+		dst.ModifiedAccessConditions.IfNoneMatch = azblob.ETagAny
+	}
+	if c.IfMatch != "" {
+		dst.ModifiedAccessConditions.IfMatch = azblob.ETag(c.IfMatch)
+	}
+	return nil
+}
+
+// MetadataDirective tells whether a copy keeps the metadata of the source
+// blob or replaces it; see CopyMetadata.
+type MetadataDirective string
+
+const (
+	// MetadataDirectiveCopy copies the metadata of the source blob to the
+	// destination. It is the default.
+	MetadataDirectiveCopy MetadataDirective = "COPY"
+	// MetadataDirectiveReplace sets the metadata of the destination to
+	// CopyMetadata.Metadata instead, ignoring the source's.
+	MetadataDirectiveReplace MetadataDirective = "REPLACE"
+)
+
+// CopyMetadata sets the metadata of the destination of a copy. To use it, set
+// blob.CopyOptions.BeforeCopy to the BeforeCopy method:
+//
+//   md := &azureblob.CopyMetadata{Directive: azureblob.MetadataDirectiveReplace, Metadata: m}
+//   err := bucket.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{BeforeCopy: md.BeforeCopy})
+type CopyMetadata struct {
+	// Directive is MetadataDirectiveCopy (the default) or
+	// MetadataDirectiveReplace.
+	Directive MetadataDirective
+	// Metadata is the metadata of the destination with
+	// MetadataDirectiveReplace. It is escaped like
+	// blob.WriterOptions.Metadata. If it is empty, the destination has no
+	// metadata.
+	Metadata map[string]string
+}
+
+// BeforeCopy applies c to a copy; see CopyMetadata.
+func (c *CopyMetadata) BeforeCopy(asFunc func(interface{}) bool) error {
+	switch c.Directive {
+	case "", MetadataDirectiveCopy:
+		return nil
+	case MetadataDirectiveReplace:
+	default:
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: CopyMetadata: unknown directive %q", c.Directive)
+	}
+	var md azblob.Metadata
+	var directive *MetadataDirective
+	if !asFunc(&md) || !asFunc(&directive) {
+		return errors.New("azureblob: CopyMetadata can only be used with azureblob buckets")
+	}
+	escaped, err := escapeMetadata(c.Metadata)
+	if err != nil {
+		return gcerr.New(gcerr.InvalidArgument, err, 1, "azureblob: CopyMetadata")
+	}
+	for k := range md {
+		delete(md, k)
+	}
+	for k, v := range escaped {
+		md[k] = v
+	}
+	*directive = c.Directive
+	return nil
+}
+
+// TagDirective tells whether a copy copies the index tags of the source
+// blob to the destination or replaces them; see CopyTags.
+type TagDirective string
+
+const (
+	// TagDirectiveCopy sets the tags of the destination to those of the
+	// source.
+	TagDirectiveCopy TagDirective = "COPY"
+	// TagDirectiveReplace sets the tags of the destination to CopyTags.Tags
+	// instead, ignoring the source's.
+	TagDirectiveReplace TagDirective = "REPLACE"
+)
+
+// CopyTags sets the index tags of the destination of a copy, independently
+// of its metadata (see CopyMetadata). To use it, set
+// blob.CopyOptions.BeforeCopy to the BeforeCopy method:
+//
+//   tags := &azureblob.CopyTags{Directive: azureblob.TagDirectiveReplace, Tags: m}
+//   err := bucket.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{BeforeCopy: tags.BeforeCopy})
+//
+// Without a directive, the copy leaves the tags of the destination as Azure
+// sets them.
+type CopyTags struct {
+	// Directive is TagDirectiveCopy or TagDirectiveReplace.
+	Directive TagDirective
+	// Tags are the tags of the destination with TagDirectiveReplace. If it
+	// is empty, the destination has no tags.
+	Tags map[string]string
+}
+
+// BeforeCopy applies c to a copy; see CopyTags.
+func (c *CopyTags) BeforeCopy(asFunc func(interface{}) bool) error {
+	switch c.Directive {
+	case "":
+		return nil
+	case TagDirectiveCopy, TagDirectiveReplace:
+	default:
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: CopyTags: unknown directive %q", c.Directive)
+	}
+	var tags azblob.BlobTagsMap
+	var directive *TagDirective
+	if !asFunc(&tags) || !asFunc(&directive) {
+		return errors.New("azureblob: CopyTags can only be used with azureblob buckets")
+	}
+	for k := range tags {
+		delete(tags, k)
+	}
+	if c.Directive == TagDirectiveReplace {
+		for k, v := range c.Tags {
+			tags[k] = v
+		}
+	}
+	*directive = c.Directive
+	return nil
+}
+
+// applyTagDirective sets the tags of dst after a copy from the blob named
+// srcName, according to directive and the tags sent with the copy.
+func (b *bucket) applyTagDirective(ctx context.Context, dst azblob.BlobURL, srcName string, directive TagDirective, tags azblob.BlobTagsMap) error {
+	switch {
+	case directive == TagDirectiveCopy:
+		// The tag condition applies to the destination, not the source.
+		srcTags, err := b.containerURL.NewBlobURL(srcName).GetTags(withoutIfTags(ctx), nil, nil, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		tags = azblob.BlobTagsMap(tagsToMap(srcTags.BlobTagSet))
+	case directive == TagDirectiveReplace && len(tags) == 0:
+		// An empty set of tags can't be sent with the copy, so clear them
+		// after it.
+	default:
+		return nil
+	}
+	if tags == nil {
+		tags = azblob.BlobTagsMap{}
+	}
+	_, err := dst.SetTags(ctx, nil, nil, nil, nil, nil, nil, tags)
+	return err
+}
+
+// CopyFromURLOptions sets options for CopyFromURL.
+type CopyFromURLOptions struct {
+	// SourceSAS is a SAS token, with or without the leading "?", that grants
+	// read access to the source. It is appended to the source URL, so that
+	// private blobs in other storage accounts can be copied. It isn't
+	// needed if the source is public or its URL is already signed.
+	SourceSAS string
+	// AccessTier, if set, is the access tier of the copy, overriding
+	// Options.AccessTier.
+	AccessTier azblob.AccessTierType
+	// Progress, if set, is called with the progress of the copy; see
+	// CopyProgress.
+	Progress CopyProgress
+}
+
+// parseSourceURL parses a source URL passed to op. The returned error
+// doesn't include the URL, which may contain a SAS.
+func parseSourceURL(op, s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, gcerr.New(gcerr.InvalidArgument, err, 2, "azureblob: "+op+": invalid source URL")
+	}
+	return u, nil
+}
+
+// CopyFromURL copies the blob at srcURL, which may be in another storage
+// account, to the blob at dstKey in b, server-side, and waits for the copy
+// to complete. opts may be nil.
+func CopyFromURL(ctx context.Context, b *blob.Bucket, dstKey, srcURL string, opts *CopyFromURLOptions) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	src, err := parseSourceURL("CopyFromURL", srcURL)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &CopyFromURLOptions{}
+	}
+	if opts.SourceSAS != "" {
+		sas := strings.TrimPrefix(opts.SourceSAS, "?")
+		if src.RawQuery != "" {
+			sas = src.RawQuery + "&" + sas
+		}
+		src.RawQuery = sas
+	}
+	tier := drv.opts.AccessTier
+	if opts.AccessTier != azblob.AccessTierNone {
+		tier = opts.AccessTier
+	}
+	blobURL := drv.containerURL.NewBlobURL(drv.blobName(dstKey))
+	resp, err := blobURL.StartCopyFromURL(ctx, *src, nil, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{}, tier, nil /* BlobTagsMap */)
+	if err != nil {
+		return drv.wrapError(err, dstKey)
+	}
+	return drv.wrapError(waitForCopy(ctx, blobURL, resp.CopyStatus(), defaultCopyPollInterval, opts.Progress), dstKey)
+}
+
+// CopyProgress is called with the number of bytes copied so far and the
+// total number of bytes to copy, each time the status of a pending
+// server-side copy is polled. Copies otherwise wait for the copy to complete
+// without any sign of progress. It isn't called for copies that complete
+// immediately.
+//
+// To use it with blob.Bucket.Copy, set blob.CopyOptions.BeforeCopy to the
+// BeforeCopy method:
+//
+//   progress := azureblob.CopyProgress(func(copied, total int64) { ... })
+//   err := bucket.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{BeforeCopy: progress.BeforeCopy})
+//
+// CopyFromURL and CopyToRehydrate take it as an option.
+type CopyProgress func(copied, total int64)
+
+// BeforeCopy applies fn to a copy; see CopyProgress.
+func (fn CopyProgress) BeforeCopy(asFunc func(interface{}) bool) error {
+	var progress *CopyProgress
+	if !asFunc(&progress) {
+		return errors.New("azureblob: CopyProgress can only be used with azureblob buckets")
+	}
+	*progress = fn
+	return nil
+}
+
+type copyPollTimeoutKey struct{}
+
+// WithCopyPollTimeout returns a context that bounds each request made to poll
+// the status of a pending server-side copy by d, in addition to ctx. A poll
+// that times out counts as a failed poll: the copy keeps being polled, and
+// gives up after a few consecutive failures like for any other error. This
+// keeps a hung status request from stalling a long copy until ctx expires.
+// It applies to blob.Bucket.Copy and the copy functions of this package.
+//
+// Like the server-side request timeout, d has a granularity of a second; it
+// is rounded up to a whole number of seconds.
+func WithCopyPollTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, copyPollTimeoutKey{}, d)
+}
+
+// copyPollContext returns the context to use for a single copy status poll,
+// derived from ctx, and its cancel function.
+func copyPollContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	d, ok := ctx.Value(copyPollTimeoutKey{}).(time.Duration)
+	if !ok || d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	// azblob's retry policy bounds each try by the time left until the
+	// deadline truncated to whole seconds, so a deadline exactly d away
+	// would allow d-1s, and none at all below a second. Leave some slack so
+	// that the try is bounded by d itself.
+	d = (d + time.Second - 1).Truncate(time.Second)
+	return context.WithTimeout(ctx, d+time.Second/2)
+}
+
+// reportCopyProgress calls fn, if not nil, with the progress in the
+// x-ms-copy-progress header value progress, which is of the form
+// "copied/total". Malformed values are ignored.
+func reportCopyProgress(fn CopyProgress, progress string) {
+	if fn == nil {
+		return
+	}
+	i := strings.IndexByte(progress, '/')
+	if i < 0 {
+		return
+	}
+	copied, err := strconv.ParseInt(progress[:i], 10, 64)
+	if err != nil {
+		return
+	}
+	total, err := strconv.ParseInt(progress[i+1:], 10, 64)
+	if err != nil {
+		return
+	}
+	fn(copied, total)
+}
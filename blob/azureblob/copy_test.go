@@ -0,0 +1,327 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestCopyConditions(t *testing.T) {
+	const srcETag, dstETag = `"src"`, `"dst"`
+	tests := []struct {
+		description string
+		conds       CopyConditions
+		want        gcerrors.ErrorCode
+	}{
+		{description: "no conditions", want: gcerrors.OK},
+		{description: "source matches", conds: CopyConditions{SourceIfMatch: srcETag}, want: gcerrors.OK},
+		{description: "source changed", conds: CopyConditions{SourceIfMatch: `"old"`}, want: gcerrors.FailedPrecondition},
+		{description: "destination exists", conds: CopyConditions{IfNotExists: true}, want: gcerrors.AlreadyExists},
+		{description: "destination matches", conds: CopyConditions{IfMatch: dstETag}, want: gcerrors.OK},
+		{description: "destination changed", conds: CopyConditions{IfMatch: `"old"`}, want: gcerrors.FailedPrecondition},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Header.Get("x-ms-source-if-match") != "" && r.Header.Get("x-ms-source-if-match") != srcETag:
+					w.Header().Set("x-ms-error-code", "SourceConditionNotMet")
+					w.WriteHeader(http.StatusPreconditionFailed)
+				case r.Header.Get("If-None-Match") == "*":
+					w.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+					w.WriteHeader(http.StatusConflict)
+				case r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != dstETag:
+					w.Header().Set("x-ms-error-code", "ConditionNotMet")
+					w.WriteHeader(http.StatusPreconditionFailed)
+				default:
+					w.Header().Set("x-ms-copy-status", "success")
+					w.WriteHeader(http.StatusAccepted)
+				}
+			})
+			defer done()
+			err := b.Copy(context.Background(), "dst", "src", &blob.CopyOptions{BeforeCopy: test.conds.BeforeCopy})
+			if got := gcerrors.Code(err); got != test.want {
+				t.Errorf("got error %v want code %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCopyFromURL(t *testing.T) {
+	const src = "https://other.blob.core.windows.net/c/my-key"
+	tests := []struct {
+		description string
+		srcURL      string
+		opts        *CopyFromURLOptions
+		want        string
+	}{
+		{description: "public source", srcURL: src, want: src},
+		{description: "source SAS", srcURL: src, opts: &CopyFromURLOptions{SourceSAS: "?sv=2019-12-12&sig=abc"}, want: src + "?sv=2019-12-12&sig=abc"},
+		{description: "source SAS without ?", srcURL: src, opts: &CopyFromURLOptions{SourceSAS: "sig=abc"}, want: src + "?sig=abc"},
+		{description: "source with query", srcURL: src + "?snapshot=1", opts: &CopyFromURLOptions{SourceSAS: "sig=abc"}, want: src + "?snapshot=1&sig=abc"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var gotPath, gotSource string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotSource = r.URL.Path, r.Header.Get("x-ms-copy-source")
+				w.Header().Set("x-ms-copy-status", "success")
+				w.WriteHeader(http.StatusAccepted)
+			})
+			defer done()
+			if err := CopyFromURL(context.Background(), b, "dst", test.srcURL, test.opts); err != nil {
+				t.Fatal(err)
+			}
+			if want := "/gocloudblobtests/mycontainer/dst"; gotPath != want {
+				t.Errorf("got path %q want %q", gotPath, want)
+			}
+			if gotSource != test.want {
+				t.Errorf("got copy source %q want %q", gotSource, test.want)
+			}
+		})
+	}
+
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {})
+	defer done()
+	if err := CopyFromURL(context.Background(), b, "dst", "://bad", nil); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+}
+
+func TestCopyMetadata(t *testing.T) {
+	tests := []struct {
+		description string
+		md          CopyMetadata
+		wantMD      map[string]string // x-ms-meta-* headers of the copy
+		wantClear   bool
+		wantErr     gcerrors.ErrorCode
+	}{
+		{description: "default", wantMD: map[string]string{}},
+		{description: "copy", md: CopyMetadata{Directive: MetadataDirectiveCopy, Metadata: map[string]string{"a": "b"}}, wantMD: map[string]string{}},
+		{description: "replace", md: CopyMetadata{Directive: MetadataDirectiveReplace, Metadata: map[string]string{"owner": "a/b"}}, wantMD: map[string]string{"owner": "a%2Fb"}},
+		{description: "replace with none", md: CopyMetadata{Directive: MetadataDirectiveReplace}, wantMD: map[string]string{}, wantClear: true},
+		{description: "unknown directive", md: CopyMetadata{Directive: "MERGE"}, wantErr: gcerrors.InvalidArgument},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			gotMD := map[string]string{}
+			gotClear := false
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("comp") == "metadata" {
+					gotClear = true
+					return
+				}
+				for k, v := range r.Header {
+					if strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+						gotMD[strings.TrimPrefix(strings.ToLower(k), "x-ms-meta-")] = v[0]
+					}
+				}
+				w.Header().Set("x-ms-copy-status", "success")
+				w.WriteHeader(http.StatusAccepted)
+			})
+			defer done()
+			err := b.Copy(context.Background(), "dst", "src", &blob.CopyOptions{BeforeCopy: test.md.BeforeCopy})
+			if got := gcerrors.Code(err); got != test.wantErr {
+				t.Fatalf("got error %v want code %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(gotMD, test.wantMD); diff != "" {
+				t.Errorf("metadata diff (-got +want):\n%s", diff)
+			}
+			if gotClear != test.wantClear {
+				t.Errorf("got metadata cleared %v want %v", gotClear, test.wantClear)
+			}
+		})
+	}
+}
+
+func TestCopyTags(t *testing.T) {
+	tests := []struct {
+		description string
+		tags        CopyTags
+		wantHeader  string // x-ms-tags header of the copy
+		wantSet     string // body of the Set Blob Tags request, if any
+		wantErr     gcerrors.ErrorCode
+	}{
+		{description: "default"},
+		{description: "copy", tags: CopyTags{Directive: TagDirectiveCopy, Tags: map[string]string{"a": "b"}}, wantSet: "<Key>owner</Key><Value>me</Value>"},
+		{description: "replace", tags: CopyTags{Directive: TagDirectiveReplace, Tags: map[string]string{"owner": "you"}}, wantHeader: "owner=you"},
+		{description: "replace with none", tags: CopyTags{Directive: TagDirectiveReplace}, wantSet: "<TagSet></TagSet>"},
+		{description: "unknown directive", tags: CopyTags{Directive: "MERGE"}, wantErr: gcerrors.InvalidArgument},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var gotHeader, gotSet string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Query().Get("comp") == "tags" && r.Method == http.MethodGet:
+					if r.URL.Path != "/gocloudblobtests/mycontainer/src" {
+						t.Errorf("got tags read from %q want the source", r.URL.Path)
+					}
+					fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Tags><TagSet><Tag><Key>owner</Key><Value>me</Value></Tag></TagSet></Tags>`)
+				case r.URL.Query().Get("comp") == "tags":
+					body, _ := ioutil.ReadAll(r.Body)
+					gotSet = string(body)
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					gotHeader = r.Header.Get("x-ms-tags")
+					w.Header().Set("x-ms-copy-status", "success")
+					w.WriteHeader(http.StatusAccepted)
+				}
+			})
+			defer done()
+			err := b.Copy(context.Background(), "dst", "src", &blob.CopyOptions{BeforeCopy: test.tags.BeforeCopy})
+			if got := gcerrors.Code(err); got != test.wantErr {
+				t.Fatalf("got error %v want code %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if gotHeader != test.wantHeader {
+				t.Errorf("got x-ms-tags %q want %q", gotHeader, test.wantHeader)
+			}
+			if (gotSet == "") != (test.wantSet == "") || !strings.Contains(gotSet, test.wantSet) {
+				t.Errorf("got tags set %q want it to contain %q", gotSet, test.wantSet)
+			}
+		})
+	}
+}
+
+func TestCopyProgress(t *testing.T) {
+	tests := []struct {
+		description string
+		progress    []string // x-ms-copy-progress of each poll
+		copy        func(b *blob.Bucket, fn CopyProgress) error
+		want        []string
+	}{
+		{
+			description: "Copy",
+			progress:    []string{"40/100", "100/100"},
+			copy: func(b *blob.Bucket, fn CopyProgress) error {
+				return b.Copy(context.Background(), "dst", "src", &blob.CopyOptions{BeforeCopy: fn.BeforeCopy})
+			},
+			want: []string{"40/100", "100/100"},
+		},
+		{
+			// CopyToRehydrate has a configurable poll interval, so it is used
+			// to check more polls.
+			description: "CopyToRehydrate",
+			progress:    []string{"0/100", "40/100", "bad", "100/100"},
+			copy: func(b *blob.Bucket, fn CopyProgress) error {
+				opts := &RehydrateCopyOptions{PollInterval: time.Millisecond, Progress: fn}
+				return CopyToRehydrate(context.Background(), b, "dst", "src", "Hot", opts)
+			},
+			want: []string{"0/100", "40/100", "100/100"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			polls := 0
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut {
+					w.Header().Set("x-ms-copy-status", "pending")
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+				w.Header().Set("x-ms-copy-progress", test.progress[polls])
+				if polls++; polls < len(test.progress) {
+					w.Header().Set("x-ms-copy-status", "pending")
+				} else {
+					w.Header().Set("x-ms-copy-status", "success")
+				}
+			})
+			defer done()
+
+			var got []string
+			err := test.copy(b, func(copied, total int64) {
+				got = append(got, fmt.Sprintf("%d/%d", copied, total))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("got progress %v want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithCopyPollTimeout(t *testing.T) {
+	polls := 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.Header().Set("x-ms-copy-status", "pending")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if polls++; polls == 1 {
+			// The first poll hangs until the client gives up on it.
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("x-ms-copy-status", "success")
+	})
+	defer done()
+
+	// Rounded up to a second, the shortest timeout possible.
+	ctx := WithCopyPollTimeout(context.Background(), time.Millisecond)
+	opts := &RehydrateCopyOptions{PollInterval: time.Millisecond}
+	if err := CopyToRehydrate(ctx, b, "dst", "src", "Hot", opts); err != nil {
+		t.Fatal(err)
+	}
+	if polls != 2 {
+		t.Errorf("got %d polls want 2", polls)
+	}
+}
+
+func TestCopyPollCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("got %s request while waiting to poll, want none", r.Method)
+		}
+		w.Header().Set("x-ms-copy-status", "pending")
+		w.WriteHeader(http.StatusAccepted)
+		// Cancel while the copy waits for its first poll.
+		cancel()
+	})
+	defer done()
+
+	start := time.Now()
+	opts := &RehydrateCopyOptions{PollInterval: time.Hour}
+	if err := CopyToRehydrate(ctx, b, "dst", "src", "Hot", opts); err == nil {
+		t.Fatal("got nil error want cancellation")
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("copy took %v to notice cancellation", d)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/internal/gcerr"
+)
+
+// blobName returns the name of the blob for key, as escapeKey, except that
+// a trailing "/" isn't escaped if Options.DirectoryPlaceholder is "/", and
+// that nothing is escaped if Options.RawKeys is set.
+func (b *bucket) blobName(key string) string {
+	if b.opts.RawKeys {
+		return key
+	}
+	if b.opts.DirectoryPlaceholder == "/" && strings.HasSuffix(key, "/") && !strings.HasSuffix(key, "../") {
+		return escapeKey(strings.TrimSuffix(key, "/"), false) + "/"
+	}
+	return escapeKey(key, false)
+}
+
+// blobPrefix is like blobName, for a prefix or delimiter.
+func (b *bucket) blobPrefix(prefix string) string {
+	if b.opts.RawKeys {
+		return prefix
+	}
+	return escapeKey(prefix, true)
+}
+
+// keyFromBlobName reverses blobName, returning the key for the blob named
+// name.
+func (b *bucket) keyFromBlobName(name string) string {
+	if b.opts.RawKeys {
+		return name
+	}
+	return unescapeKey(name)
+}
+
+// CreateDirectoryPlaceholder creates a zero-byte blob that represents the
+// directory dir, named dir followed by Options.DirectoryPlaceholder, so that
+// tools that show such blobs as folders show dir even if it is empty. dir
+// may end in "/". It returns an error whose code is
+// gcerrors.FailedPrecondition if the bucket has no DirectoryPlaceholder.
+func CreateDirectoryPlaceholder(ctx context.Context, b *blob.Bucket, dir string) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	suffix := drv.opts.DirectoryPlaceholder
+	if suffix == "" {
+		return gcerr.Newf(gcerr.FailedPrecondition, nil, "azureblob: CreateDirectoryPlaceholder: Options.DirectoryPlaceholder is not set")
+	}
+	key := strings.TrimSuffix(dir, "/") + suffix
+	return b.WriteAll(ctx, key, nil, &blob.WriterOptions{ContentType: "application/octet-stream"})
+}
+
+// IsDirectoryPlaceholder reports whether obj, as returned by b.List, is a
+// directory placeholder blob, i.e., an empty blob whose key ends in
+// Options.DirectoryPlaceholder. Its directory is the key without that
+// suffix. It returns false if the bucket has no DirectoryPlaceholder.
+func IsDirectoryPlaceholder(b *blob.Bucket, obj *blob.ListObject) bool {
+	drv, err := driverBucket(b)
+	if err != nil || drv.opts.DirectoryPlaceholder == "" {
+		return false
+	}
+	return !obj.IsDir && obj.Size == 0 && strings.HasSuffix(obj.Key, drv.opts.DirectoryPlaceholder)
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/gcerrors"
+)
+
+func TestDirectoryPlaceholder(t *testing.T) {
+	tests := []struct {
+		placeholder string
+		dir         string
+		wantBlob    string // name of the placeholder blob
+		wantErr     gcerrors.ErrorCode
+	}{
+		{placeholder: "/", dir: "a/b", wantBlob: "a/b/"},
+		{placeholder: "/", dir: "a/b/", wantBlob: "a/b/"},
+		{placeholder: "/.keep", dir: "a/b/", wantBlob: "a/b/.keep"},
+		{dir: "a/b", wantErr: gcerrors.FailedPrecondition},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("%q %q", test.placeholder, test.dir), func(t *testing.T) {
+			ctx := context.Background()
+			fake := newFakeBlockBlobs()
+			b, done := newTestBucket(t, &Options{DirectoryPlaceholder: test.placeholder}, fake.ServeHTTP)
+			defer done()
+			err := CreateDirectoryPlaceholder(ctx, b, test.dir)
+			if got := gcerrors.Code(err); got != test.wantErr {
+				t.Fatalf("got error %v want code %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, ok := fake.committed[test.wantBlob]; !ok {
+				t.Errorf("blob %q wasn't created", test.wantBlob)
+			}
+			// The placeholder round-trips through its key.
+			key := test.wantBlob
+			if ok, err := b.Exists(ctx, key); err != nil || !ok {
+				t.Errorf("got Exists(%q) %v, %v want true", key, ok, err)
+			}
+			if err := b.Delete(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := fake.committed[test.wantBlob]; ok {
+				t.Errorf("blob %q wasn't deleted", test.wantBlob)
+			}
+		})
+	}
+}
+
+func TestIsDirectoryPlaceholder(t *testing.T) {
+	b, done := newTestBucket(t, &Options{DirectoryPlaceholder: "/"}, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`+
+			`<Blob><Name>a/</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>0</Content-Length></Properties></Blob>`+
+			`<Blob><Name>a/b</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`+
+			`<Blob><Name>c__0x2f__</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`+
+			`</Blobs><NextMarker /></EnumerationResults>`)
+	})
+	defer done()
+
+	var got []string
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if IsDirectoryPlaceholder(b, obj) {
+			got = append(got, obj.Key)
+		}
+	}
+	if want := []string{"a/"}; !cmp.Equal(got, want) {
+		t.Errorf("got placeholders %v want %v", got, want)
+	}
+}
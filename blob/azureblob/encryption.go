@@ -0,0 +1,70 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotEncrypted is returned (possibly wrapped) by reads using
+// EncryptionCheck when the blob isn't encrypted as expected. Use errors.Is
+// to detect it.
+// Its error code is gcerrors.FailedPrecondition.
+var ErrNotEncrypted = errors.New("azureblob: blob is not encrypted as expected")
+
+// EncryptionCheck makes a read fail with ErrNotEncrypted, before returning
+// any data, unless Azure reports that the blob is server-side encrypted and,
+// if Scope is not empty, that it is encrypted with the encryption scope
+// named Scope. To use it, set blob.ReaderOptions.BeforeRead to the
+// BeforeRead method:
+//
+//   check := &azureblob.EncryptionCheck{Scope: "myscope"}
+//   r, err := bucket.NewReader(ctx, key, &blob.ReaderOptions{BeforeRead: check.BeforeRead})
+//
+// The check relies on the x-ms-server-encrypted and x-ms-encryption-scope
+// headers of the download response, so it costs no extra request.
+type EncryptionCheck struct {
+	// Scope, if set, is the name of the encryption scope the blob must be
+	// encrypted with.
+	Scope string
+}
+
+// BeforeRead applies c to a read; see EncryptionCheck.
+func (c *EncryptionCheck) BeforeRead(asFunc func(interface{}) bool) error {
+	var s *readSettings
+	if !asFunc(&s) {
+		return errors.New("azureblob: EncryptionCheck can only be used with azureblob buckets")
+	}
+	check := *c
+	s.encryptionCheck = &check
+	return nil
+}
+
+// checkEncryption returns an error wrapping ErrNotEncrypted if c is not nil
+// and h, the headers of a response for a blob, don't meet its expectations.
+func checkEncryption(c *EncryptionCheck, h http.Header) error {
+	if c == nil {
+		return nil
+	}
+	if h.Get("x-ms-server-encrypted") != "true" {
+		return fmt.Errorf("%w: not server-side encrypted", ErrNotEncrypted)
+	}
+	if got := h.Get("x-ms-encryption-scope"); c.Scope != "" && got != c.Scope {
+		return fmt.Errorf("%w: encryption scope is %q, want %q", ErrNotEncrypted, got, c.Scope)
+	}
+	return nil
+}
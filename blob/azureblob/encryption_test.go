@@ -0,0 +1,89 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestEncryptionCheck(t *testing.T) {
+	tests := []struct {
+		description string
+		encrypted   string
+		gotScope    string
+		scope       string
+		wantErr     bool
+	}{
+		{description: "encrypted", encrypted: "true"},
+		{description: "not encrypted", encrypted: "false", wantErr: true},
+		{description: "no header", wantErr: true},
+		{description: "scope matches", encrypted: "true", gotScope: "myscope", scope: "myscope"},
+		{description: "scope differs", encrypted: "true", gotScope: "other", scope: "myscope", wantErr: true},
+		{description: "default scope", encrypted: "true", scope: "myscope", wantErr: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				if test.encrypted != "" {
+					w.Header().Set("x-ms-server-encrypted", test.encrypted)
+				}
+				if test.gotScope != "" {
+					w.Header().Set("x-ms-encryption-scope", test.gotScope)
+				}
+				w.Write([]byte("hello"))
+			})
+			defer done()
+
+			check := &EncryptionCheck{Scope: test.scope}
+			data, err := readAll(b, "my-key", &blob.ReaderOptions{BeforeRead: check.BeforeRead})
+			if test.wantErr {
+				if !errors.Is(err, ErrNotEncrypted) || gcerrors.Code(err) != gcerrors.FailedPrecondition {
+					t.Errorf("got %q, %v want ErrNotEncrypted with code FailedPrecondition", data, err)
+				}
+				return
+			}
+			if err != nil || string(data) != "hello" {
+				t.Errorf("got %q, %v want %q", data, err, "hello")
+			}
+		})
+	}
+
+	// Without the check, reads don't look at the encryption headers.
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	defer done()
+	if _, err := b.ReadAll(context.Background(), "my-key"); err != nil {
+		t.Error(err)
+	}
+}
+
+// readAll reads the blob at key like b.ReadAll, but with opts.
+func readAll(b *blob.Bucket, key string, opts *blob.ReaderOptions) ([]byte, error) {
+	r, err := b.NewReader(context.Background(), key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
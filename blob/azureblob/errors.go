@@ -0,0 +1,80 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// ErrorDetails describes an error returned by Azure.
+type ErrorDetails struct {
+	// ServiceCode is Azure's error code, e.g. "BlobNotFound".
+	ServiceCode string
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// RequestID is the x-ms-request-id that Azure assigned to the request;
+	// Azure support uses it to look up the request.
+	RequestID string
+	// ClientRequestID is the x-ms-client-request-id of the request (see
+	// WithClientRequestID).
+	ClientRequestID string
+	// RetryAfter is how long Azure asked the client to wait before retrying,
+	// from the Retry-After header of throttled requests (whose error code is
+	// gcerrors.ResourceExhausted). It is 0 if Azure didn't say.
+	RetryAfter time.Duration
+}
+
+// ErrorDetailsOf returns the details of the Azure error in err's chain, for
+// example an error returned by a blob.Bucket opened with this package. It
+// returns false if err isn't caused by an error response from Azure.
+func ErrorDetailsOf(err error) (*ErrorDetails, bool) {
+	var serr azblob.StorageError
+	if !errors.As(err, &serr) {
+		return nil, false
+	}
+	d := &ErrorDetails{ServiceCode: string(serr.ServiceCode())}
+	if resp := serr.Response(); resp != nil {
+		d.StatusCode = resp.StatusCode
+		d.RequestID = resp.Header.Get("x-ms-request-id")
+		d.ClientRequestID = resp.Header.Get("x-ms-client-request-id")
+		d.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return d, true
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date. It returns 0 if s is empty or invalid.
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(s); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gocloud.dev/gcerrors"
+)
+
+func TestErrorDetailsOf(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-client-request-id", r.Header.Get("x-ms-client-request-id"))
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer done()
+
+	_, err := b.Attributes(WithClientRequestID(context.Background(), "client-id"), "my-key")
+	got, ok := ErrorDetailsOf(err)
+	if !ok {
+		t.Fatalf("ErrorDetailsOf(%v) failed", err)
+	}
+	if got.ClientRequestID != "client-id" {
+		t.Errorf("got ClientRequestID %q want %q", got.ClientRequestID, "client-id")
+	}
+
+	if _, ok := ErrorDetailsOf(errors.New("not from Azure")); ok {
+		t.Error("ErrorDetailsOf succeeded for a non-Azure error")
+	}
+}
+
+func TestThrottling(t *testing.T) {
+	tests := []struct {
+		description    string
+		status         int
+		retryAfter     string
+		wantRetryAfter time.Duration
+	}{
+		{description: "too many requests", status: http.StatusTooManyRequests, retryAfter: "7", wantRetryAfter: 7 * time.Second},
+		{description: "server busy", status: http.StatusServiceUnavailable},
+		{description: "invalid Retry-After", status: http.StatusServiceUnavailable, retryAfter: "soon"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.Header().Set("x-ms-error-code", "ServerBusy")
+				w.WriteHeader(test.status)
+			})
+			defer done()
+
+			_, err := b.Attributes(context.Background(), "my-key")
+			if got := gcerrors.Code(err); got != gcerrors.ResourceExhausted {
+				t.Errorf("got error code %v want ResourceExhausted", got)
+			}
+			d, ok := ErrorDetailsOf(err)
+			if !ok {
+				t.Fatalf("ErrorDetailsOf(%v) failed", err)
+			}
+			if d.RetryAfter != test.wantRetryAfter {
+				t.Errorf("got RetryAfter %v want %v", d.RetryAfter, test.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("got %v for a date an hour from now", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)); got != 0 {
+		t.Errorf("got %v for a date in the past", got)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+)
+
+// gzipEncoding is the Content-Encoding of blobs written by NewGzipWriter.
+const gzipEncoding = "gzip"
+
+// gzipSniffLen is the number of bytes of uncompressed content buffered to
+// detect its content type, matching blob.Writer.
+const gzipSniffLen = 512
+
+// NewGzipWriter is like blob.Bucket.NewWriter, but gzip-compresses the
+// content written to it and sets the blob's ContentEncoding to "gzip", so
+// that it is decompressed by NewGzipReader and by HTTP clients that support
+// compression. If opts.ContentType is empty, it is detected from the
+// uncompressed content.
+//
+// As with blob.Writer, the blob is not written until Close returns nil, and
+// canceling ctx aborts the write. If a Write fails, the write is aborted
+// too: Close returns the error and doesn't write a truncated blob.
+func NewGzipWriter(ctx context.Context, b *blob.Bucket, key string, opts *blob.WriterOptions) (io.WriteCloser, error) {
+	if _, err := driverBucket(b); err != nil {
+		return nil, err
+	}
+	var o blob.WriterOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.ContentEncoding = gzipEncoding
+	ctx, cancel := context.WithCancel(ctx)
+	w := &gzipWriter{ctx: ctx, cancel: cancel, b: b, key: key, opts: &o}
+	if o.ContentType != "" {
+		if err := w.open(); err != nil {
+			w.fail(err)
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// gzipWriter compresses its input into a blob.Writer. The blob.Writer is
+// opened lazily when the content type must be detected.
+type gzipWriter struct {
+	ctx    context.Context
+	cancel func() // cancels ctx, aborting the write
+	b      *blob.Bucket
+	key    string
+	opts   *blob.WriterOptions
+
+	sniff []byte // uncompressed content buffered until w is opened
+	w     *blob.Writer
+	gz    *gzip.Writer
+	err   error // the error that aborted the write, if any
+}
+
+func (w *gzipWriter) open() error {
+	if w.opts.ContentType == "" {
+		w.opts.ContentType = http.DetectContentType(w.sniff)
+	}
+	bw, err := w.b.NewWriter(w.ctx, w.key, w.opts)
+	if err != nil {
+		return err
+	}
+	w.w = bw
+	w.gz = gzip.NewWriter(bw)
+	if len(w.sniff) > 0 {
+		_, err = w.gz.Write(w.sniff)
+		w.sniff = nil
+	}
+	return err
+}
+
+// fail aborts the write after err, so that closing the blob.Writer doesn't
+// commit the content written so far.
+func (w *gzipWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.gz != nil {
+		n, err := w.gz.Write(p)
+		if err != nil {
+			w.fail(err)
+		}
+		return n, err
+	}
+	w.sniff = append(w.sniff, p...)
+	if len(w.sniff) >= gzipSniffLen {
+		if err := w.open(); err != nil {
+			w.fail(err)
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes the compressed stream and closes the underlying blob.Writer.
+// If the write was aborted, Close returns the error that aborted it.
+func (w *gzipWriter) Close() error {
+	defer w.cancel()
+	if w.err == nil && w.gz == nil {
+		if err := w.open(); err != nil {
+			w.fail(err)
+		}
+	}
+	if w.err == nil {
+		if err := w.gz.Close(); err != nil {
+			w.fail(err)
+		}
+	}
+	if w.w == nil {
+		return w.err
+	}
+	// After a failure, w's context is canceled, so this doesn't commit the
+	// blob; its error is then only the cancellation.
+	err := w.w.Close()
+	if w.err != nil {
+		return w.err
+	}
+	return err
+}
+
+// NewGzipReader is like blob.Bucket.NewReader, but decompresses the content
+// of the blob if its ContentEncoding is "gzip". Content that has already
+// been decompressed by the HTTP transport is returned as is.
+func NewGzipReader(ctx context.Context, b *blob.Bucket, key string, opts *blob.ReaderOptions) (io.ReadCloser, error) {
+	r, err := b.NewReader(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	var resp azblob.DownloadResponse
+	if !r.As(&resp) || resp.ContentEncoding() != gzipEncoding {
+		return r, nil
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &gzipReader{Reader: gz, r: r}, nil
+}
+
+// gzipReader decompresses the content of a blob.Reader.
+type gzipReader struct {
+	*gzip.Reader
+	r *blob.Reader
+}
+
+func (r *gzipReader) Close() error {
+	err := r.Reader.Close()
+	if cerr := r.r.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestGzip(t *testing.T) {
+	const content = "<html><body>hello, hello, hello, hello</body></html>"
+	var mu sync.Mutex
+	var stored []byte
+	var encoding, contentType string
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Query().Get("comp") == "block":
+			// The content is small enough to be uploaded as a single block.
+			stored, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut:
+			encoding = r.Header.Get("x-ms-blob-content-encoding")
+			contentType = r.Header.Get("x-ms-blob-content-type")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet:
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(stored)
+		}
+	})
+	defer done()
+	ctx := context.Background()
+
+	for _, opts := range []*blob.WriterOptions{nil, {ContentType: "text/plain"}} {
+		w, err := NewGzipWriter(ctx, b, "my-key", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		wantType := "text/html; charset=utf-8"
+		if opts != nil {
+			wantType = opts.ContentType
+		}
+		if encoding != "gzip" || contentType != wantType {
+			t.Errorf("got ContentEncoding %q and ContentType %q, want %q and %q", encoding, contentType, "gzip", wantType)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(stored))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadAll(zr); string(got) != content {
+			t.Errorf("got stored content %q decompressed, want %q", got, content)
+		}
+
+		r, err := NewGzipReader(ctx, b, "my-key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("got content %q, want %q", got, content)
+		}
+	}
+
+	// Blobs that aren't compressed are read as is.
+	encoding, stored = "", []byte("plain")
+	r, err := NewGzipReader(ctx, b, "my-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, _ := ioutil.ReadAll(r); string(got) != "plain" {
+		t.Errorf("got content %q, want %q", got, "plain")
+	}
+}
+
+func TestGzipWriteError(t *testing.T) {
+	var mu sync.Mutex
+	committed := false
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Query().Get("comp") == "blocklist" {
+			committed = true
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("x-ms-error-code", "AuthorizationFailure")
+		w.WriteHeader(http.StatusForbidden)
+	})
+	defer done()
+
+	w, err := NewGzipWriter(context.Background(), b, "my-key", &blob.WriterOptions{BufferSize: 1024 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Random content doesn't compress, so it is uploaded in several blocks,
+	// and writing fails once staging the first one has.
+	rnd := rand.New(rand.NewSource(1))
+	p := make([]byte, 64*1024)
+	var writeErr error
+	for i := 0; i < 1024 && writeErr == nil; i++ {
+		rnd.Read(p)
+		_, writeErr = w.Write(p)
+	}
+	if writeErr == nil {
+		t.Fatal("got nil error from Write after staging failed")
+	}
+	if _, err := w.Write(p); err != writeErr {
+		t.Errorf("got error %v from Write after the failure, want %v", err, writeErr)
+	}
+	if err := w.Close(); err != writeErr {
+		t.Errorf("got Close error %v want %v", err, writeErr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if committed {
+		t.Error("the blob was committed after a failed write")
+	}
+}
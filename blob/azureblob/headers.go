@@ -0,0 +1,106 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+type requestHeadersKey struct{}
+
+// WithRequestHeaders returns a context that makes bucket operations using it
+// send the headers in h with each request to Azure, in addition to (or
+// replacing) the headers set by this package. Headers set by earlier calls
+// on ctx are kept unless h replaces them.
+//
+// Headers are added before requests are signed, so they may include
+// x-ms-* headers that are covered by the signature.
+func WithRequestHeaders(ctx context.Context, h http.Header) context.Context {
+	merged := http.Header{}
+	if prev, ok := ctx.Value(requestHeadersKey{}).(http.Header); ok {
+		for k, v := range prev {
+			merged[k] = v
+		}
+	}
+	for k, v := range h {
+		merged[http.CanonicalHeaderKey(k)] = v
+	}
+	return context.WithValue(ctx, requestHeadersKey{}, merged)
+}
+
+// WithClientRequestID returns a context that makes bucket operations using
+// it send id as the x-ms-client-request-id of each request, instead of a
+// random ID. Azure records the ID in its logs, so it can be used to
+// correlate an operation with Azure's side of it.
+func WithClientRequestID(ctx context.Context, id string) context.Context {
+	return WithRequestHeaders(ctx, http.Header{"X-Ms-Client-Request-Id": {id}})
+}
+
+// headerPipeline is a pipeline.Pipeline that adds the headers set with
+// WithRequestHeaders to each request, those set with
+// WithImmutabilityPolicy to the requests that commit a blob, and the
+// condition set with WithIfTags to the requests that support it. It also
+// reports the progress of uploads made with UploadProgress, and redacts the
+// SAS signature from the URLs in the errors it returns; see redactSAS.
+type headerPipeline struct {
+	pipeline.Pipeline
+}
+
+func (p headerPipeline) Do(ctx context.Context, methodFactory pipeline.Factory, request pipeline.Request) (pipeline.Response, error) {
+	if h, ok := ctx.Value(requestHeadersKey{}).(http.Header); ok {
+		for k, v := range h {
+			request.Header[k] = v
+		}
+	}
+	if h, ok := ctx.Value(commitHeadersKey{}).(http.Header); ok && isCommitRequest(request) {
+		for k, v := range h {
+			request.Header[k] = v
+		}
+	}
+	if expr, ok := ctx.Value(ifTagsKey{}).(string); ok && supportsIfTags(request) {
+		request.Header.Set("x-ms-if-tags", expr)
+	}
+	resp, err := p.Pipeline.Do(ctx, methodFactory, request)
+	if progress, ok := ctx.Value(uploadProgressStateKey{}).(*uploadProgressState); ok && err == nil && isUploadRequest(request) {
+		progress.add(request.ContentLength)
+	}
+	return resp, redactSAS(err)
+}
+
+// redactSAS redacts the SAS signature from the URL of a *url.Error in err's
+// chain, as returned for transport failures, so that logging err doesn't leak
+// the SAS token. Azure's own errors already redact it.
+func redactSAS(err error) error {
+	var uerr *url.Error
+	if !errors.As(err, &uerr) {
+		return err
+	}
+	u, perr := url.Parse(uerr.URL)
+	if perr != nil {
+		return err
+	}
+	q := u.Query()
+	if _, ok := q["sig"]; ok {
+		q.Set("sig", "REDACTED")
+		u.RawQuery = q.Encode()
+		uerr.URL = u.String()
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestHeaders(t *testing.T) {
+	var got http.Header
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Header().Set("x-ms-client-request-id", r.Header.Get("x-ms-client-request-id"))
+	})
+	defer done()
+
+	ctx := WithRequestHeaders(context.Background(), http.Header{"x-custom": {"1"}, "X-Other": {"a"}})
+	ctx = WithClientRequestID(ctx, "my-request")
+	ctx = WithRequestHeaders(ctx, http.Header{"X-Custom": {"2"}})
+	if _, err := b.Attributes(ctx, "my-key"); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{
+		"x-ms-client-request-id": "my-request",
+		"X-Custom":               "2",
+		"X-Other":                "a",
+	} {
+		if v := got.Get(k); v != want {
+			t.Errorf("got header %s %q want %q", k, v, want)
+		}
+	}
+
+	// Without the headers in the context, a random request ID is used.
+	if _, err := b.Attributes(context.Background(), "my-key"); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get("x-ms-client-request-id"); v == "" || v == "my-request" {
+		t.Errorf("got x-ms-client-request-id %q want a random ID", v)
+	}
+	if v := got.Get("X-Custom"); v != "" {
+		t.Errorf("got X-Custom %q want none", v)
+	}
+}
+
+func TestErrorsRedactSAS(t *testing.T) {
+	b, done := newTestBucket(t, &Options{SASToken: "sv=2019-12-12&sig=secret"}, func(w http.ResponseWriter, r *http.Request) {
+		// Fail the request in the transport.
+		panic(http.ErrAbortHandler)
+	})
+	defer done()
+
+	_, err := b.Attributes(context.Background(), "my-key")
+	if err == nil {
+		t.Fatal("got nil error")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks the SAS signature: %v", err)
+	}
+	if !strings.Contains(err.Error(), "sig=REDACTED") {
+		t.Errorf("got error %v, want the URL with the signature redacted", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+)
+
+// immutabilityServiceVersion is the first Azure Storage service version that
+// supports setting an immutability policy when writing a blob. azblob
+// doesn't support it, so the headers are added by headerPipeline.
+const immutabilityServiceVersion = "2020-10-02"
+
+// ImmutabilityPolicyMode is the mode of a blob's immutability policy.
+type ImmutabilityPolicyMode string
+
+const (
+	// ImmutabilityPolicyUnlocked allows the retention period to be changed
+	// or the policy to be deleted.
+	ImmutabilityPolicyUnlocked ImmutabilityPolicyMode = "Unlocked"
+	// ImmutabilityPolicyLocked only allows the retention period to be
+	// extended.
+	ImmutabilityPolicyLocked ImmutabilityPolicyMode = "Locked"
+)
+
+// commitHeadersKey is the context key for headers that headerPipeline adds
+// to the requests that create or commit a blob.
+type commitHeadersKey struct{}
+
+// WithImmutabilityPolicy returns a context that makes writes using it apply
+// a time-based retention policy to the blob, in mode, until the given time.
+// The policy is set by the request that commits the blob's content, so the
+// blob is never mutable. Requests that only stage blocks don't carry it.
+//
+// The container must have version-level immutability support enabled, or
+// the write fails.
+func WithImmutabilityPolicy(ctx context.Context, until time.Time, mode ImmutabilityPolicyMode) context.Context {
+	return context.WithValue(ctx, commitHeadersKey{}, http.Header{
+		"X-Ms-Version":                        {immutabilityServiceVersion},
+		"X-Ms-Immutability-Policy-Until-Date": {until.UTC().Format(http.TimeFormat)},
+		"X-Ms-Immutability-Policy-Mode":       {string(mode)},
+	})
+}
+
+// isCommitRequest reports whether request creates a blob or commits its
+// content: Put Blob, Put Block List, and Copy Blob.
+func isCommitRequest(request pipeline.Request) bool {
+	if request.Method != http.MethodPut {
+		return false
+	}
+	q := request.URL.Query()
+	if q.Get("restype") != "" {
+		return false
+	}
+	comp := q.Get("comp")
+	return comp == "" || comp == "blocklist"
+}
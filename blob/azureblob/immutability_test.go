@@ -0,0 +1,80 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+func TestWithImmutabilityPolicy(t *testing.T) {
+	until := time.Date(2031, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		description string
+		size        int
+	}{
+		{description: "single request", size: 10},
+		{description: "staged blocks", size: 3 * 1024 * 1024},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			got := map[string]http.Header{} // by comp
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+				mu.Lock()
+				got[r.URL.Query().Get("comp")] = r.Header
+				mu.Unlock()
+				w.WriteHeader(http.StatusCreated)
+			})
+			defer done()
+
+			ctx := WithImmutabilityPolicy(context.Background(), until, ImmutabilityPolicyLocked)
+			opts := &blob.WriterOptions{BufferSize: 1024 * 1024}
+			if err := b.WriteAll(ctx, "my-key", bytes.Repeat([]byte("a"), test.size), opts); err != nil {
+				t.Fatal(err)
+			}
+			for comp, h := range got {
+				wantPolicy := comp != "block"
+				if gotPolicy := h.Get("x-ms-immutability-policy-until-date") != ""; gotPolicy != wantPolicy {
+					t.Errorf("comp=%q: got immutability policy %v want %v", comp, gotPolicy, wantPolicy)
+				}
+				if !wantPolicy {
+					continue
+				}
+				if v := h.Get("x-ms-immutability-policy-until-date"); v != "Sat, 01 Mar 2031 12:00:00 GMT" {
+					t.Errorf("comp=%q: got until date %q", comp, v)
+				}
+				if v := h.Get("x-ms-immutability-policy-mode"); v != "Locked" {
+					t.Errorf("comp=%q: got mode %q", comp, v)
+				}
+				if v := h.Get("x-ms-version"); v != immutabilityServiceVersion {
+					t.Errorf("comp=%q: got x-ms-version %q", comp, v)
+				}
+			}
+			if test.size > opts.BufferSize && got["blocklist"] == nil {
+				t.Error("blob wasn't uploaded in blocks")
+			}
+		})
+	}
+}
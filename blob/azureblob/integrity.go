@@ -0,0 +1,108 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/internal/gcerr"
+)
+
+// WithContentCRC64 returns a context that makes range reads using it ask
+// Azure for the CRC64 of the range read, available from ReaderContentCRC64.
+// Azure only computes it for ranges of at most 4 MiB, and rejects the read
+// if the range is larger or if the whole blob is read; use
+// blob.Bucket.NewRangeReader with a length instead of NewReader.
+func WithContentCRC64(ctx context.Context) context.Context {
+	return WithRequestHeaders(ctx, http.Header{"X-Ms-Range-Get-Content-Crc64": {"true"}})
+}
+
+// ReaderContentCRC64 returns the CRC64 of the content read by r, as
+// computed by Azure, or nil if Azure didn't return one. Azure returns it for
+// range reads made with a context from WithContentCRC64.
+//
+// Azure doesn't return a CRC64 when getting the attributes of a blob, so
+// there is no equivalent for blob.Attributes.
+func ReaderContentCRC64(r *blob.Reader) []byte {
+	var resp azblob.DownloadResponse
+	if !r.As(&resp) {
+		return nil
+	}
+	v := resp.Response().Header.Get("x-ms-content-crc64")
+	if v == "" {
+		return nil
+	}
+	crc, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil
+	}
+	return crc
+}
+
+// WriteAllVerified is like b.WriteAll, but also sends opts.ContentMD5 as the
+// transactional MD5 of the upload, so that Azure itself rejects the write if
+// the bytes it receives don't match, instead of only storing the MD5 as a
+// property of the blob. If opts.ContentMD5 is empty, the MD5 of data is
+// used, which still guards against corruption in transit.
+//
+// The data is uploaded in a single request, so it may be at most
+// azblob.BlockBlobMaxUploadBlobBytes long. opts.BeforeWrite is not called.
+// If the MD5 doesn't match, WriteAllVerified returns an error whose code is
+// gcerrors.FailedPrecondition, and the blob is left unchanged. opts may be
+// nil.
+func WriteAllVerified(ctx context.Context, b *blob.Bucket, key string, data []byte, opts *blob.WriterOptions) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &blob.WriterOptions{}
+	}
+	if len(data) > azblob.BlockBlobMaxUploadBlobBytes {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: WriteAllVerified: data is %d bytes, more than the limit of %d", len(data), azblob.BlockBlobMaxUploadBlobBytes)
+	}
+	md, err := escapeMetadata(opts.Metadata)
+	if err != nil {
+		return gcerr.New(gcerr.InvalidArgument, err, 1, "azureblob: WriteAllVerified")
+	}
+	contentMD5 := opts.ContentMD5
+	if len(contentMD5) == 0 {
+		sum := md5.Sum(data)
+		contentMD5 = sum[:]
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	h := azblob.BlobHTTPHeaders{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentMD5:         contentMD5,
+		ContentType:        contentType,
+	}
+	// azblob's Upload doesn't support setting the transactional MD5.
+	ctx = WithRequestHeaders(ctx, http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(contentMD5)}})
+	blockBlobURL := drv.containerURL.NewBlockBlobURL(drv.blobName(key))
+	_, err = blockBlobURL.Upload(ctx, bytes.NewReader(data), h, md, azblob.BlobAccessConditions{}, drv.opts.AccessTier, nil /* BlobTagsMap */, azblob.ClientProvidedKeyOptions{})
+	return drv.wrapError(err, key)
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestReaderContentCRC64(t *testing.T) {
+	crc := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-ms-range-get-content-crc64") == "true" {
+			w.Header().Set("x-ms-content-crc64", base64.StdEncoding.EncodeToString(crc))
+		}
+		w.Header().Set("Content-Range", "bytes 0-4/10")
+		w.Write([]byte("hello"))
+	})
+	defer done()
+
+	for _, withCRC := range []bool{false, true} {
+		ctx := context.Background()
+		if withCRC {
+			ctx = WithContentCRC64(ctx)
+		}
+		r, err := b.NewRangeReader(ctx, "my-key", 0, 5, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ReaderContentCRC64(r)
+		r.Close()
+		if withCRC && !bytes.Equal(got, crc) || !withCRC && got != nil {
+			t.Errorf("WithContentCRC64 %v: got CRC64 %v", withCRC, got)
+		}
+	}
+}
+
+func TestWriteAllVerified(t *testing.T) {
+	data := []byte("hello")
+	sum := md5.Sum(data)
+	tests := []struct {
+		description string
+		opts        *blob.WriterOptions
+		want        gcerrors.ErrorCode
+	}{
+		{description: "computed MD5"},
+		{description: "matching MD5", opts: &blob.WriterOptions{ContentMD5: sum[:]}},
+		{description: "mismatched MD5", opts: &blob.WriterOptions{ContentMD5: []byte("0123456789abcdef")}, want: gcerrors.FailedPrecondition},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var gotStored string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				got := md5.Sum(body)
+				if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(got[:]) {
+					w.Header().Set("x-ms-error-code", "Md5Mismatch")
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				gotStored = r.Header.Get("x-ms-blob-content-md5")
+				w.WriteHeader(http.StatusCreated)
+			})
+			defer done()
+			err := WriteAllVerified(context.Background(), b, "my-key", data, test.opts)
+			if got := gcerrors.Code(err); got != test.want {
+				t.Fatalf("got error %v want code %v", err, test.want)
+			}
+			if err == nil && gotStored != base64.StdEncoding.EncodeToString(sum[:]) {
+				t.Errorf("got x-ms-blob-content-md5 %q", gotStored)
+			}
+		})
+	}
+}
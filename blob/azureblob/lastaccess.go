@@ -0,0 +1,166 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+)
+
+// lastAccessServiceVersion is the first Azure Storage service version that
+// returns the last access time of blobs. It's newer than the version used by
+// azblob, so listing with access times is done with raw requests.
+const lastAccessServiceVersion = "2020-02-10"
+
+// AccessedObject describes a blob returned by ListNotAccessedSince.
+type AccessedObject struct {
+	// Key is the key of the blob.
+	Key string
+	// Size is the size of the blob in bytes.
+	Size int64
+	// ModTime is the time the blob was last modified.
+	ModTime time.Time
+	// LastAccessedOn is the time the blob was last read or written, as
+	// tracked by Azure. It is the zero time if access tracking is not
+	// enabled for the account.
+	LastAccessedOn time.Time
+}
+
+// ListNotAccessedSince lists the blobs whose keys start with prefix and that
+// haven't been accessed since the given time, and calls fn for each of them
+// in key order. It stops at the first error returned by fn and returns it.
+//
+// Access times come from the list response, so no per-blob requests are
+// made. They are only available if last access time tracking is enabled in
+// the storage account's lifecycle management settings; otherwise blobs are
+// filtered by their modification time instead.
+func ListNotAccessedSince(ctx context.Context, b *blob.Bucket, prefix string, since time.Time, fn func(*AccessedObject) error) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	marker := ""
+	for {
+		u := drv.containerURL.URL()
+		q := u.Query()
+		q.Set("restype", "container")
+		q.Set("comp", "list")
+		q.Set("maxresults", strconv.Itoa(defaultPageSize))
+		if prefix != "" {
+			q.Set("prefix", drv.blobPrefix(prefix))
+		}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		u.RawQuery = q.Encode()
+		resp, err := drv.doRaw(ctx, http.MethodGet, u, http.Header{"X-Ms-Version": {lastAccessServiceVersion}}, nil)
+		if err != nil {
+			return drv.wrapError(err, prefix)
+		}
+		var list accessList
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, item := range list.Blobs {
+			obj, err := item.object(drv)
+			if err != nil {
+				return err
+			}
+			accessed := obj.LastAccessedOn
+			if accessed.IsZero() {
+				accessed = obj.ModTime
+			}
+			if accessed.After(since) {
+				continue
+			}
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if list.NextMarker == "" {
+			return nil
+		}
+		marker = list.NextMarker
+	}
+}
+
+// attributesLastAccessKey is the context key used to make the driver's
+// Attributes request the blob's last access time.
+type attributesLastAccessKey struct{}
+
+// WithAttributesLastAccess returns a context that makes blob.Bucket.Attributes
+// calls using it also return the time the blob was last accessed, for
+// AttributesLastAccessedOn. It costs no extra request; the properties are
+// requested with a newer service version than azblob's.
+func WithAttributesLastAccess(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attributesLastAccessKey{}, true)
+}
+
+// AttributesLastAccessedOn returns the time the blob described by attrs, as
+// returned by blob.Bucket.Attributes with a context from
+// WithAttributesLastAccess, was last read or written, as tracked by Azure.
+// The time is zero if last access time tracking is not enabled for the
+// account, or if it wasn't requested. It returns false if attrs doesn't
+// come from this package.
+func AttributesLastAccessedOn(attrs *blob.Attributes) (time.Time, bool) {
+	var props azblob.BlobGetPropertiesResponse
+	if !attrs.As(&props) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC1123, props.Response().Header.Get("x-ms-last-access-time"))
+	if err != nil {
+		return time.Time{}, true
+	}
+	return t, true
+}
+
+// accessList is the subset of a List Blobs response used by
+// ListNotAccessedSince.
+type accessList struct {
+	Blobs      []accessListBlob `xml:"Blobs>Blob"`
+	NextMarker string           `xml:"NextMarker"`
+}
+
+type accessListBlob struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		LastModified   string `xml:"Last-Modified"`
+		LastAccessTime string `xml:"LastAccessTime"`
+		ContentLength  int64  `xml:"Content-Length"`
+	} `xml:"Properties"`
+}
+
+func (b *accessListBlob) object(drv *bucket) (*AccessedObject, error) {
+	obj := &AccessedObject{Key: drv.keyFromBlobName(b.Name), Size: b.Properties.ContentLength}
+	var err error
+	if obj.ModTime, err = time.Parse(time.RFC1123, b.Properties.LastModified); err != nil {
+		return nil, fmt.Errorf("azureblob: invalid Last-Modified for %q: %v", obj.Key, err)
+	}
+	if b.Properties.LastAccessTime != "" {
+		if obj.LastAccessedOn, err = time.Parse(time.RFC1123, b.Properties.LastAccessTime); err != nil {
+			return nil, fmt.Errorf("azureblob: invalid LastAccessTime for %q: %v", obj.Key, err)
+		}
+	}
+	return obj, nil
+}
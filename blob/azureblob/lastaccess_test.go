@@ -0,0 +1,109 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListNotAccessedSince(t *testing.T) {
+	// Two pages of blobs; "b" has no access time, as when tracking is
+	// disabled.
+	pages := map[string]string{
+		"": `<Blob><Name>a</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><LastAccessTime>Sat, 01 May 2021 00:00:00 GMT</LastAccessTime><Content-Length>1</Content-Length></Properties></Blob>` +
+			`<Blob><Name>b</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>2</Content-Length></Properties></Blob>`,
+		"page2": `<Blob><Name>c</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><LastAccessTime>Thu, 01 Apr 2021 00:00:00 GMT</LastAccessTime><Content-Length>3</Content-Length></Properties></Blob>`,
+	}
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("x-ms-version"); got != lastAccessServiceVersion {
+			t.Errorf("got x-ms-version %q want %q", got, lastAccessServiceVersion)
+		}
+		q := r.URL.Query()
+		if got := q.Get("prefix"); got != "dir/" {
+			t.Errorf("got prefix %q want %q", got, "dir/")
+		}
+		marker := q.Get("marker")
+		next := ""
+		if marker == "" {
+			next = "page2"
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>%s</Blobs><NextMarker>%s</NextMarker></EnumerationResults>`, pages[marker], next)
+	})
+	defer done()
+
+	var got []*AccessedObject
+	since := time.Date(2021, 4, 15, 0, 0, 0, 0, time.UTC)
+	err := ListNotAccessedSince(context.Background(), b, "dir/", since, func(obj *AccessedObject) error {
+		got = append(got, obj)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	march := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	want := []*AccessedObject{
+		{Key: "b", Size: 2, ModTime: march},
+		{Key: "c", Size: 3, ModTime: march, LastAccessedOn: time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ListNotAccessedSince diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAttributesLastAccessedOn(t *testing.T) {
+	accessed := time.Date(2021, 5, 3, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		description string
+		ctx         context.Context
+		wantVersion string
+		want        time.Time
+	}{
+		{description: "not requested", ctx: context.Background(), wantVersion: azblob.ServiceVersion},
+		{description: "requested", ctx: WithAttributesLastAccess(context.Background()), wantVersion: lastAccessServiceVersion, want: accessed},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				version := r.Header.Get("x-ms-version")
+				if version != test.wantVersion {
+					t.Errorf("got x-ms-version %q want %q", version, test.wantVersion)
+				}
+				if version == lastAccessServiceVersion {
+					w.Header().Set("x-ms-last-access-time", accessed.Format(http.TimeFormat))
+				}
+			})
+			defer done()
+			attrs, err := b.Attributes(test.ctx, "my-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := AttributesLastAccessedOn(attrs)
+			if !ok {
+				t.Fatal("AttributesLastAccessedOn failed")
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("got %v want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/internal/escape"
+)
+
+// IncludeMetadata can be used as blob.ListOptions.BeforeList to make List
+// return the metadata of each blob along with it, instead of requiring a
+// request per blob to fetch it. Use ListObjectMetadata to read it.
+//
+// To include other details too, use ListInclude instead.
+func IncludeMetadata(asFunc func(interface{}) bool) error {
+	var opts *azblob.ListBlobsSegmentOptions
+	if asFunc(&opts) {
+		opts.Details.Metadata = true
+	}
+	return nil
+}
+
+// ListInclude sets the details of each blob that List returns along with
+// it, or the additional blobs it returns. To use it, set
+// blob.ListOptions.BeforeList to the BeforeList method:
+//
+//	incl := &azureblob.ListInclude{Metadata: true, Tags: true}
+//	iter := bucket.List(&blob.ListOptions{BeforeList: incl.BeforeList})
+//
+// Options that are already set by another BeforeList are kept. Snapshots
+// can't be included, because List uses hierarchical listings, which don't
+// support them; use ListSnapshots instead.
+type ListInclude struct {
+	// Metadata includes the metadata of each blob; see ListObjectMetadata.
+	Metadata bool
+	// Tags includes the index tags of each blob; see ListObjectTags.
+	Tags bool
+	// Copy includes the properties of the last copy to each blob, in
+	// azblob.BlobItemInternal.Properties.
+	Copy bool
+	// Versions also returns the previous versions of each blob; see
+	// ListObjectVersion.
+	Versions bool
+	// Deleted also returns soft-deleted blobs; see ListObjectVersion.
+	Deleted bool
+	// Uncommitted also returns blobs that have uncommitted blocks but were
+	// never committed.
+	Uncommitted bool
+}
+
+// BeforeList applies incl to a listing; see ListInclude.
+func (incl *ListInclude) BeforeList(asFunc func(interface{}) bool) error {
+	var opts *azblob.ListBlobsSegmentOptions
+	if !asFunc(&opts) {
+		return errors.New("azureblob: ListInclude can only be used with azureblob buckets")
+	}
+	d := &opts.Details
+	d.Metadata = d.Metadata || incl.Metadata
+	d.Tags = d.Tags || incl.Tags
+	d.Copy = d.Copy || incl.Copy
+	d.Versions = d.Versions || incl.Versions
+	d.Deleted = d.Deleted || incl.Deleted
+	d.UncommittedBlobs = d.UncommittedBlobs || incl.Uncommitted
+	return nil
+}
+
+// ListObjectETag returns the ETag of obj, a blob returned by List, as
+// returned in blob.Attributes.ETag. Together with the key, size, MD5, and
+// modification time, it tells whether a blob changed without a request per
+// blob. It returns "" if obj is a directory.
+func ListObjectETag(obj *blob.ListObject) string {
+	var item azblob.BlobItemInternal
+	if !obj.As(&item) {
+		return ""
+	}
+	return string(item.Properties.Etag)
+}
+
+// ListObjectCreationTime returns the time obj, a blob returned by List, was
+// created, as returned in blob.Attributes.CreateTime. Unlike obj.ModTime, it
+// doesn't change when the blob is overwritten. It returns the zero time if
+// the service didn't return it or if obj is a directory.
+func ListObjectCreationTime(obj *blob.ListObject) time.Time {
+	var item azblob.BlobItemInternal
+	if !obj.As(&item) || item.Properties.CreationTime == nil {
+		return time.Time{}
+	}
+	return *item.Properties.CreationTime
+}
+
+// ListObjectMetadata returns the metadata of obj, a blob returned by List
+// with metadata included (see IncludeMetadata), unescaped like
+// blob.Attributes.Metadata. It returns nil if obj has no metadata, if
+// metadata wasn't included, or if obj is a directory.
+func ListObjectMetadata(obj *blob.ListObject) map[string]string {
+	var item azblob.BlobItemInternal
+	if !obj.As(&item) || len(item.Metadata) == 0 {
+		return nil
+	}
+	md := make(map[string]string, len(item.Metadata))
+	for k, v := range item.Metadata {
+		md[escape.HexUnescape(k)] = escape.URLUnescape(v)
+	}
+	return md
+}
+
+// LimitedListIterator iterates over the first objects of a listing; see
+// ListWithLimit.
+type LimitedListIterator struct {
+	iter      *blob.ListIterator
+	remaining int
+}
+
+// ListWithLimit is like b.List, but the returned iterator stops after limit
+// objects (including directories), even if there are more. Pages are
+// requested with at most as many objects as remain below the limit, so no
+// more objects than needed are transferred. It is meant to protect
+// interactive tools from iterating over huge containers by mistake.
+//
+// opts may be nil; opts.BeforeList, if set, is called before the page size
+// is capped, so it may lower it but not raise it above the limit.
+func ListWithLimit(b *blob.Bucket, opts *blob.ListOptions, limit int) *LimitedListIterator {
+	it := &LimitedListIterator{remaining: limit}
+	var o blob.ListOptions
+	if opts != nil {
+		o = *opts
+	}
+	beforeList := o.BeforeList
+	o.BeforeList = func(asFunc func(interface{}) bool) error {
+		if beforeList != nil {
+			if err := beforeList(asFunc); err != nil {
+				return err
+			}
+		}
+		var lo *azblob.ListBlobsSegmentOptions
+		if asFunc(&lo) && it.remaining > 0 && (lo.MaxResults == 0 || int(lo.MaxResults) > it.remaining) {
+			lo.MaxResults = int32(it.remaining)
+		}
+		return nil
+	}
+	it.iter = b.List(&o)
+	return it
+}
+
+// Next returns a *blob.ListObject for the next object, or io.EOF if there
+// are no more objects or the limit was reached.
+func (it *LimitedListIterator) Next(ctx context.Context) (*blob.ListObject, error) {
+	if it.remaining <= 0 {
+		return nil, io.EOF
+	}
+	obj, err := it.iter.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	it.remaining--
+	return obj, nil
+}
+
+// ListFilter selects the blobs returned by ListFiltered. A blob must match
+// all of the fields that are set.
+type ListFilter struct {
+	// Suffix, if set, selects the blobs whose keys end with it, e.g. ".json".
+	Suffix string
+	// Pattern, if set, selects the blobs whose keys it matches.
+	Pattern *regexp.Regexp
+}
+
+// match reports whether f selects the blob at key.
+func (f *ListFilter) match(key string) bool {
+	if f.Suffix != "" && !strings.HasSuffix(key, f.Suffix) {
+		return false
+	}
+	if f.Pattern != nil && !f.Pattern.MatchString(key) {
+		return false
+	}
+	return true
+}
+
+// FilteredListIterator iterates over the objects of a listing that match a
+// filter; see ListFiltered.
+type FilteredListIterator struct {
+	iter   *blob.ListIterator
+	filter ListFilter
+}
+
+// ListFiltered is like b.List, but the returned iterator only returns the
+// blobs that match filter, along with all directories so that they can be
+// browsed. Azure can only filter listings by prefix, so the other blobs are
+// still listed, and skipped as the pages arrive; set opts.Prefix to narrow
+// the listing down as much as possible. opts may be nil.
+func ListFiltered(b *blob.Bucket, opts *blob.ListOptions, filter ListFilter) *FilteredListIterator {
+	return &FilteredListIterator{iter: b.List(opts), filter: filter}
+}
+
+// Next returns a *blob.ListObject for the next matching object, or io.EOF
+// if there are no more.
+func (it *FilteredListIterator) Next(ctx context.Context) (*blob.ListObject, error) {
+	for {
+		obj, err := it.iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if obj.IsDir || it.filter.match(obj.Key) {
+			return obj, nil
+		}
+	}
+}
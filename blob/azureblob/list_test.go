@@ -0,0 +1,228 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+)
+
+func TestListObjectProperties(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("include"); got != "metadata" {
+			t.Errorf("got include=%q want %q", got, "metadata")
+		}
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`+
+			`<Blob><Name>a</Name><Properties><Creation-Time>Fri, 01 Jan 2021 00:00:00 GMT</Creation-Time><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Etag>0x1</Etag><Content-Length>1</Content-Length></Properties>`+
+			`<Metadata><owner>me</owner><__0x2d__weird>a%2Fb</__0x2d__weird></Metadata></Blob>`+
+			`<Blob><Name>b</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Etag>0x2</Etag><Content-Length>1</Content-Length></Properties></Blob>`+
+			`</Blobs><NextMarker /></EnumerationResults>`)
+	})
+	defer done()
+
+	type result struct {
+		Metadata map[string]string
+		Created  time.Time
+	}
+	got := map[string]result{}
+	iter := b.List(&blob.ListOptions{BeforeList: IncludeMetadata})
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[obj.Key] = result{ListObjectMetadata(obj), ListObjectCreationTime(obj)}
+	}
+	want := map[string]result{
+		"a": {map[string]string{"owner": "me", "-weird": "a/b"}, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"b": {nil, time.Time{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestListWithLimit(t *testing.T) {
+	const numBlobs = 10
+	var gotMaxResults []int
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		maxResults, _ := strconv.Atoi(q.Get("maxresults"))
+		gotMaxResults = append(gotMaxResults, maxResults)
+		start, _ := strconv.Atoi(q.Get("marker"))
+		end := start + maxResults
+		if end > numBlobs {
+			end = numBlobs
+		}
+		var sb strings.Builder
+		sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&sb, `<Blob><Name>%02d</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`, i)
+		}
+		sb.WriteString(`</Blobs>`)
+		if end < numBlobs {
+			fmt.Fprintf(&sb, `<NextMarker>%d</NextMarker>`, end)
+		} else {
+			sb.WriteString(`<NextMarker />`)
+		}
+		sb.WriteString(`</EnumerationResults>`)
+		fmt.Fprint(w, sb.String())
+	})
+	defer done()
+
+	tests := []struct {
+		description    string
+		pageSize       int32
+		limit          int
+		wantMaxResults []int
+		wantCount      int
+	}{
+		{description: "limit within a page", limit: 7, wantMaxResults: []int{7}, wantCount: 7},
+		{description: "limit across pages", pageSize: 3, limit: 7, wantMaxResults: []int{3, 3, 1}, wantCount: 7},
+		{description: "limit at a page boundary", pageSize: 3, limit: 6, wantMaxResults: []int{3, 3}, wantCount: 6},
+		{description: "limit above the number of blobs", pageSize: 4, limit: 20, wantMaxResults: []int{4, 4, 4}, wantCount: numBlobs},
+	}
+	for _, test := range tests {
+		gotMaxResults = nil
+		opts := &blob.ListOptions{BeforeList: func(asFunc func(interface{}) bool) error {
+			var lo *azblob.ListBlobsSegmentOptions
+			if !asFunc(&lo) {
+				return errors.New("BeforeList As failed")
+			}
+			if test.pageSize > 0 {
+				lo.MaxResults = test.pageSize
+			}
+			return nil
+		}}
+		iter := ListWithLimit(b, opts, test.limit)
+		count := 0
+		for {
+			_, err := iter.Next(context.Background())
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			count++
+		}
+		if count != test.wantCount {
+			t.Errorf("%s: got %d objects want %d", test.description, count, test.wantCount)
+		}
+		if !cmp.Equal(gotMaxResults, test.wantMaxResults) {
+			t.Errorf("%s: got page sizes %v want %v", test.description, gotMaxResults, test.wantMaxResults)
+		}
+	}
+}
+
+func TestListInclude(t *testing.T) {
+	tests := []struct {
+		description string
+		incl        ListInclude
+		before      func(asFunc func(interface{}) bool) error
+		want        string
+	}{
+		{description: "none", want: ""},
+		{description: "metadata and tags", incl: ListInclude{Metadata: true, Tags: true}, want: "metadata,tags"},
+		{
+			description: "all",
+			incl:        ListInclude{Metadata: true, Tags: true, Copy: true, Versions: true, Deleted: true, Uncommitted: true},
+			want:        "copy,deleted,metadata,uncommittedblobs,tags,versions",
+		},
+		{description: "combined with another BeforeList", incl: ListInclude{Versions: true}, before: IncludeDeleted, want: "deleted,versions"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var got string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Query().Get("include")
+				fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs></Blobs><NextMarker /></EnumerationResults>`)
+			})
+			defer done()
+			beforeList := func(asFunc func(interface{}) bool) error {
+				if test.before != nil {
+					if err := test.before(asFunc); err != nil {
+						return err
+					}
+				}
+				return test.incl.BeforeList(asFunc)
+			}
+			if _, err := b.List(&blob.ListOptions{BeforeList: beforeList}).Next(context.Background()); err != io.EOF {
+				t.Fatalf("got error %v want io.EOF", err)
+			}
+			if got != test.want {
+				t.Errorf("got include=%q want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestListFiltered(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`+
+			`<BlobPrefix><Name>dir/</Name></BlobPrefix>`+
+			`<Blob><Name>a.json</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`+
+			`<Blob><Name>a.txt</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`+
+			`<Blob><Name>b-2021.json</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`+
+			`</Blobs><NextMarker /></EnumerationResults>`)
+	})
+	defer done()
+
+	tests := []struct {
+		description string
+		filter      ListFilter
+		want        []string
+	}{
+		{description: "no filter", want: []string{"a.json", "a.txt", "b-2021.json", "dir/"}},
+		{description: "suffix", filter: ListFilter{Suffix: ".json"}, want: []string{"a.json", "b-2021.json", "dir/"}},
+		{description: "pattern", filter: ListFilter{Pattern: regexp.MustCompile(`^a\.`)}, want: []string{"a.json", "a.txt", "dir/"}},
+		{description: "both", filter: ListFilter{Suffix: ".json", Pattern: regexp.MustCompile(`\d{4}`)}, want: []string{"b-2021.json", "dir/"}},
+	}
+	for _, test := range tests {
+		iter := ListFiltered(b, &blob.ListOptions{Delimiter: "/"}, test.filter)
+		var got []string
+		for {
+			obj, err := iter.Next(context.Background())
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, obj.Key)
+		}
+		sort.Strings(got)
+		if !cmp.Equal(got, test.want) {
+			t.Errorf("%s: got %v want %v", test.description, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/internal/gcerr"
+)
+
+// Metadata values are strings. The helpers below store typed values in a
+// metadata map (such as blob.WriterOptions.Metadata) and read them back (such
+// as from blob.Attributes.Metadata) with a single, stable encoding, so that
+// values written by one program can be read by another. Keys and values are
+// escaped for Azure by the driver as usual; see the package comments.
+//
+// blob.Bucket lowercases metadata keys when writing and reading them, so the
+// helpers lowercase name too: a value set with name "Seq" is read back from
+// blob.Attributes.Metadata with name "Seq" or "seq".
+
+// SetMetadataInt64 sets md[name] to v, encoded in base 10.
+func SetMetadataInt64(md map[string]string, name string, v int64) {
+	md[strings.ToLower(name)] = strconv.FormatInt(v, 10)
+}
+
+// MetadataInt64 returns the value stored in md[name] by SetMetadataInt64.
+// The bool result is false if md has no entry for name. It returns an
+// InvalidArgument error if the entry isn't a valid int64.
+func MetadataInt64(md map[string]string, name string) (int64, bool, error) {
+	s, ok := md[strings.ToLower(name)]
+	if !ok {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, true, gcerr.Newf(gcerr.InvalidArgument, err, "azureblob: metadata %q isn't an int64: %q", name, s)
+	}
+	return v, true, nil
+}
+
+// SetMetadataTime sets md[name] to t, encoded in RFC 3339 format in UTC with
+// nanosecond precision. The monotonic clock reading and location of t are
+// not preserved.
+func SetMetadataTime(md map[string]string, name string, t time.Time) {
+	md[strings.ToLower(name)] = t.UTC().Format(time.RFC3339Nano)
+}
+
+// MetadataTime returns the value stored in md[name] by SetMetadataTime, in
+// UTC. The bool result is false if md has no entry for name. It returns an
+// InvalidArgument error if the entry isn't a valid RFC 3339 time.
+func MetadataTime(md map[string]string, name string) (time.Time, bool, error) {
+	s, ok := md[strings.ToLower(name)]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, true, gcerr.Newf(gcerr.InvalidArgument, err, "azureblob: metadata %q isn't an RFC 3339 time: %q", name, s)
+	}
+	return t.UTC(), true, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/escape"
+)
+
+// roundTripMetadata escapes md for Azure and unescapes it again, like a write
+// followed by a read.
+func roundTripMetadata(t *testing.T, md map[string]string) map[string]string {
+	t.Helper()
+	escaped, err := escapeMetadata(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for k, v := range escaped {
+		got[escape.HexUnescape(k)] = escape.URLUnescape(v)
+	}
+	return got
+}
+
+func TestMetadataInt64(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 1 << 62, -1 << 63} {
+		md := map[string]string{}
+		SetMetadataInt64(md, "seq-no", v)
+		got, ok, err := MetadataInt64(roundTripMetadata(t, md), "seq-no")
+		if err != nil || !ok || got != v {
+			t.Errorf("%d: got %d, %v, %v", v, got, ok, err)
+		}
+	}
+	if _, ok, err := MetadataInt64(map[string]string{}, "seq-no"); ok || err != nil {
+		t.Errorf("missing: got %v, %v want false, nil", ok, err)
+	}
+	_, ok, err := MetadataInt64(map[string]string{"seq-no": "x"}, "seq-no")
+	if !ok || gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("invalid: got %v, %v want true, InvalidArgument", ok, err)
+	}
+}
+
+func TestMetadataTime(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	for _, v := range []time.Time{
+		time.Date(2021, 5, 3, 10, 11, 12, 0, time.UTC),
+		time.Date(2021, 5, 3, 10, 11, 12, 123456789, loc),
+		time.Now(),
+	} {
+		md := map[string]string{}
+		SetMetadataTime(md, "updated", v)
+		got, ok, err := MetadataTime(roundTripMetadata(t, md), "updated")
+		if err != nil || !ok || !got.Equal(v) || got.Location() != time.UTC {
+			t.Errorf("%v: got %v, %v, %v", v, got, ok, err)
+		}
+	}
+	if _, ok, err := MetadataTime(map[string]string{}, "updated"); ok || err != nil {
+		t.Errorf("missing: got %v, %v want false, nil", ok, err)
+	}
+	_, ok, err := MetadataTime(map[string]string{"updated": "yesterday"}, "updated")
+	if !ok || gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("invalid: got %v, %v want true, InvalidArgument", ok, err)
+	}
+}
+
+func TestMetadataMixedCase(t *testing.T) {
+	var mu sync.Mutex
+	stored := http.Header{} // x-ms-meta-* headers of the last commit
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			for k, v := range r.Header {
+				if strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+					stored[k] = v
+				}
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodHead:
+			for k, v := range stored {
+				w.Header()[k] = v
+			}
+		}
+	})
+	defer done()
+
+	ctx := context.Background()
+	updated := time.Date(2021, 5, 3, 10, 11, 12, 0, time.UTC)
+	md := map[string]string{}
+	SetMetadataInt64(md, "Seq", 42)
+	SetMetadataTime(md, "UpdatedAt", updated)
+	if err := b.WriteAll(ctx, "my-key", []byte("hello"), &blob.WriterOptions{Metadata: md}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := MetadataInt64(attrs.Metadata, "Seq"); err != nil || !ok || got != 42 {
+		t.Errorf("MetadataInt64: got %d, %v, %v want 42, true, nil", got, ok, err)
+	}
+	if got, ok, err := MetadataTime(attrs.Metadata, "UpdatedAt"); err != nil || !ok || !got.Equal(updated) {
+		t.Errorf("MetadataTime: got %v, %v, %v want %v, true, nil", got, ok, err, updated)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"io"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/internal/gcerr"
+)
+
+// PageSize is the size of a page of a page blob. The sizes of page blobs and
+// the offsets and lengths of their writes must be multiples of PageSize.
+const PageSize = azblob.PageBlobPageBytes
+
+// CreatePageBlob creates an empty page blob of size bytes at key, replacing
+// any existing blob, with the given sequence number. Page blobs can't be
+// written with blob.Bucket.NewWriter; use WritePages.
+func CreatePageBlob(ctx context.Context, b *blob.Bucket, key string, size, sequenceNumber int64) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	pageBlobURL := drv.containerURL.NewPageBlobURL(drv.blobName(key))
+	_, err = pageBlobURL.Create(ctx, size, sequenceNumber, azblob.BlobHTTPHeaders{}, nil, azblob.BlobAccessConditions{}, azblob.PremiumPageBlobAccessTierNone, nil /* BlobTagsMap */, azblob.ClientProvidedKeyOptions{})
+	return drv.wrapError(err, key)
+}
+
+// WritePages writes data to the page blob at key, starting at offset.
+//
+// The write only succeeds if the blob's sequence number satisfies cond, so
+// that sequence numbers can be used to fence off writers that have been
+// superseded; see azblob.SequenceNumberAccessConditions for how to express
+// the conditions. If they are not met, WritePages returns an error whose
+// code is gcerrors.FailedPrecondition.
+func WritePages(ctx context.Context, b *blob.Bucket, key string, offset int64, data io.ReadSeeker, cond azblob.SequenceNumberAccessConditions) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	pageBlobURL := drv.containerURL.NewPageBlobURL(drv.blobName(key))
+	ac := azblob.PageBlobAccessConditions{SequenceNumberAccessConditions: cond}
+	_, err = pageBlobURL.UploadPages(ctx, offset, data, ac, nil, azblob.ClientProvidedKeyOptions{})
+	return drv.wrapError(err, key)
+}
+
+// UpdateSequenceNumber changes the sequence number of the page blob at key
+// and returns the new one. action says how:
+//
+//   - azblob.SequenceNumberActionUpdate sets it to sequenceNumber.
+//   - azblob.SequenceNumberActionMax sets it to sequenceNumber if that is
+//     higher than the current one.
+//   - azblob.SequenceNumberActionIncrement adds 1 to it; sequenceNumber is
+//     ignored.
+//
+// A coordinator can fence off a writer by raising the sequence number past
+// the one the writer passes to WritePages in its conditions.
+func UpdateSequenceNumber(ctx context.Context, b *blob.Bucket, key string, action azblob.SequenceNumberActionType, sequenceNumber int64) (int64, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return 0, err
+	}
+	switch action {
+	case azblob.SequenceNumberActionUpdate, azblob.SequenceNumberActionMax, azblob.SequenceNumberActionIncrement:
+	default:
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: unknown sequence number action %q", action)
+	}
+	pageBlobURL := drv.containerURL.NewPageBlobURL(drv.blobName(key))
+	resp, err := pageBlobURL.UpdateSequenceNumber(ctx, action, sequenceNumber, azblob.BlobAccessConditions{})
+	if err != nil {
+		return 0, drv.wrapError(err, key)
+	}
+	return resp.BlobSequenceNumber(), nil
+}
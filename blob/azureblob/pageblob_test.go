@@ -0,0 +1,172 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/gcerrors"
+)
+
+// fakePageBlobs is an http.Handler that implements enough of the page blob
+// API for the tests in this file.
+type fakePageBlobs struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	seqNos map[string]int64
+}
+
+func newFakePageBlobs() *fakePageBlobs {
+	return &fakePageBlobs{blobs: map[string][]byte{}, seqNos: map[string]int64{}}
+}
+
+func (f *fakePageBlobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := path.Base(r.URL.Path)
+	switch r.URL.Query().Get("comp") {
+	case "":
+		size, _ := strconv.ParseInt(r.Header.Get("x-ms-blob-content-length"), 10, 64)
+		f.blobs[key] = make([]byte, size)
+		f.seqNos[key], _ = strconv.ParseInt(r.Header.Get("x-ms-blob-sequence-number"), 10, 64)
+		w.WriteHeader(http.StatusCreated)
+	case "page":
+		if !f.sequenceNumberConditionsMet(key, r.Header) {
+			w.Header().Set("x-ms-error-code", "SequenceNumberConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		var start int
+		fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-", &start)
+		data, _ := ioutil.ReadAll(r.Body)
+		copy(f.blobs[key][start:], data)
+		w.WriteHeader(http.StatusCreated)
+	case "properties":
+		n, _ := strconv.ParseInt(r.Header.Get("x-ms-blob-sequence-number"), 10, 64)
+		switch r.Header.Get("x-ms-sequence-number-action") {
+		case "update":
+			f.seqNos[key] = n
+		case "max":
+			if n > f.seqNos[key] {
+				f.seqNos[key] = n
+			}
+		case "increment":
+			f.seqNos[key]++
+		}
+		w.Header().Set("x-ms-blob-sequence-number", strconv.FormatInt(f.seqNos[key], 10))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// sequenceNumberConditionsMet reports whether the sequence number of the
+// blob at key satisfies the conditions in h.
+func (f *fakePageBlobs) sequenceNumberConditionsMet(key string, h http.Header) bool {
+	seqNo := f.seqNos[key]
+	for name, ok := range map[string]func(int64) bool{
+		"x-ms-if-sequence-number-le": func(n int64) bool { return seqNo <= n },
+		"x-ms-if-sequence-number-lt": func(n int64) bool { return seqNo < n },
+		"x-ms-if-sequence-number-eq": func(n int64) bool { return seqNo == n },
+	} {
+		if v := h.Get(name); v != "" {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			if !ok(n) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestWritePages(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakePageBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	if err := CreatePageBlob(ctx, b, "my-key", 2*PageSize, 5); err != nil {
+		t.Fatal(err)
+	}
+	page := bytes.Repeat([]byte("a"), PageSize)
+	tests := []struct {
+		description string
+		cond        azblob.SequenceNumberAccessConditions
+		want        gcerrors.ErrorCode
+	}{
+		{description: "no conditions", want: gcerrors.OK},
+		{description: "equal", cond: azblob.SequenceNumberAccessConditions{IfSequenceNumberEqual: 5}, want: gcerrors.OK},
+		{description: "not equal", cond: azblob.SequenceNumberAccessConditions{IfSequenceNumberEqual: 4}, want: gcerrors.FailedPrecondition},
+		{description: "less than", cond: azblob.SequenceNumberAccessConditions{IfSequenceNumberLessThan: 6}, want: gcerrors.OK},
+		{description: "not less than", cond: azblob.SequenceNumberAccessConditions{IfSequenceNumberLessThan: 5}, want: gcerrors.FailedPrecondition},
+		{description: "less than or equal", cond: azblob.SequenceNumberAccessConditions{IfSequenceNumberLessThanOrEqual: 5}, want: gcerrors.OK},
+	}
+	for _, test := range tests {
+		err := WritePages(ctx, b, "my-key", PageSize, bytes.NewReader(page), test.cond)
+		if got := gcerrors.Code(err); got != test.want {
+			t.Errorf("%s: got error %v want code %v", test.description, err, test.want)
+		}
+	}
+	if got := fake.blobs["my-key"]; !bytes.Equal(got[PageSize:], page) || !bytes.Equal(got[:PageSize], make([]byte, PageSize)) {
+		t.Errorf("got unexpected blob content")
+	}
+}
+
+func TestUpdateSequenceNumber(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakePageBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	if err := CreatePageBlob(ctx, b, "my-key", PageSize, 5); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		action azblob.SequenceNumberActionType
+		n      int64
+		want   int64
+	}{
+		{azblob.SequenceNumberActionMax, 3, 5},
+		{azblob.SequenceNumberActionMax, 7, 7},
+		{azblob.SequenceNumberActionIncrement, 100, 8},
+		{azblob.SequenceNumberActionUpdate, 2, 2},
+	}
+	for _, test := range tests {
+		got, err := UpdateSequenceNumber(ctx, b, "my-key", test.action, test.n)
+		if err != nil {
+			t.Fatalf("%s %d: %v", test.action, test.n, err)
+		}
+		if got != test.want {
+			t.Errorf("%s %d: got sequence number %d want %d", test.action, test.n, got, test.want)
+		}
+	}
+	if _, err := UpdateSequenceNumber(ctx, b, "my-key", "bump", 1); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+
+	// A writer holding the old sequence number is fenced off.
+	cond := azblob.SequenceNumberAccessConditions{IfSequenceNumberEqual: 8}
+	if err := WritePages(ctx, b, "my-key", 0, bytes.NewReader(make([]byte, PageSize)), cond); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v want FailedPrecondition", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/internal/useragent"
+)
+
+// PipelinePolicies are custom policies to add to a pipeline created with
+// NewPipelineWithPolicies, e.g. for logging, custom authentication, or
+// request mutation.
+type PipelinePolicies struct {
+	// PerCall policies see each operation once, before it is retried.
+	PerCall []pipeline.Factory
+	// PerRetry policies see each attempt of each operation. They run before
+	// the request is signed, so they may change it.
+	PerRetry []pipeline.Factory
+	// ServiceLabel, if set, replaces "blob" as the service in this
+	// package's application ID in the User-Agent of each request, so that
+	// libraries built on this package can tell their requests apart in
+	// Azure's logs and metrics.
+	ServiceLabel string
+}
+
+// NewPipelineWithPolicies is like NewPipeline, but adds policies to the
+// pipeline and may change its User-Agent.
+func NewPipelineWithPolicies(credential azblob.Credential, opts azblob.PipelineOptions, policies PipelinePolicies) pipeline.Pipeline {
+	label := "blob"
+	if policies.ServiceLabel != "" {
+		label = policies.ServiceLabel
+	}
+	opts.Telemetry.Value = useragent.AzureUserAgentPrefix(label) + opts.Telemetry.Value
+
+	// This follows azblob.NewPipeline: closest to the API goes first,
+	// closest to the wire goes last.
+	f := []pipeline.Factory{
+		azblob.NewTelemetryPolicyFactory(opts.Telemetry),
+		azblob.NewUniqueRequestIDPolicyFactory(),
+	}
+	f = append(f, policies.PerCall...)
+	f = append(f, azblob.NewRetryPolicyFactory(opts.Retry))
+	f = append(f, policies.PerRetry...)
+	// The credential must come after the policies that change the request,
+	// so that it signs the changes.
+	f = append(f,
+		credential,
+		azblob.NewRequestLogPolicyFactory(opts.RequestLog),
+		pipeline.MethodFactoryMarker())
+	return pipeline.NewPipeline(f, pipeline.Options{HTTPSender: opts.HTTPSender, Log: opts.Log})
+}
+
+// NewCorrelationPolicyFactory returns a policy for PipelinePolicies.PerCall
+// that sets the header named header of each request to the value returned
+// by fromContext for the operation's context, e.g. a trace ID stored by the
+// caller's tracing or logging middleware. This carries the caller's
+// correlation ID into Azure without using WithClientRequestID on every
+// context. If header is empty, it is x-ms-client-request-id, which Azure
+// records in its logs. Requests for which fromContext returns "" are left
+// unchanged.
+func NewCorrelationPolicyFactory(fromContext func(context.Context) string, header string) pipeline.Factory {
+	if header == "" {
+		header = "x-ms-client-request-id"
+	}
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if id := fromContext(ctx); id != "" {
+				request.Header.Set(header, id)
+			}
+			return next.Do(ctx, request)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// countingPolicy returns a policy factory that counts the requests it sees
+// and adds a header to them.
+func countingPolicy(header string, n *int) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			*n++
+			request.Header.Set(header, "1")
+			return next.Do(ctx, request)
+		}
+	})
+}
+
+func TestNewPipelineWithPolicies(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var gotHeaders http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		gotHeaders = r.Header
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var perCall, perRetry int
+	p := NewPipelineWithPolicies(azblob.NewAnonymousCredential(), azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{MaxTries: 3, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond},
+	}, PipelinePolicies{
+		PerCall:  []pipeline.Factory{countingPolicy("X-Per-Call", &perCall)},
+		PerRetry: []pipeline.Factory{countingPolicy("X-Per-Retry", &perRetry)},
+	})
+	b, err := OpenBucket(context.Background(), p, accountName, "mycontainer", &Options{
+		Protocol:      "http",
+		StorageDomain: StorageDomain(strings.TrimPrefix(srv.URL, "http://")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, err := b.Attributes(context.Background(), "my-key"); err == nil {
+		t.Fatal("got nil error")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 3 || perCall != 1 || perRetry != 3 {
+		t.Errorf("got %d requests, %d per-call and %d per-retry policy calls; want 3, 1, and 3", requests, perCall, perRetry)
+	}
+	if gotHeaders.Get("X-Per-Call") != "1" || gotHeaders.Get("X-Per-Retry") != "1" {
+		t.Errorf("policies' headers are missing from %v", gotHeaders)
+	}
+}
+
+func TestPipelineServiceLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{label: "", want: "/blob/"},
+		{label: "mylib", want: "/mylib/"},
+	}
+	for _, test := range tests {
+		var got string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get("User-Agent")
+		}))
+		p := NewPipelineWithPolicies(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}, PipelinePolicies{ServiceLabel: test.label})
+		b, err := OpenBucket(context.Background(), p, accountName, "mycontainer", &Options{
+			Protocol:      "http",
+			StorageDomain: StorageDomain(strings.TrimPrefix(srv.URL, "http://")),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Attributes(context.Background(), "my-key")
+		b.Close()
+		srv.Close()
+		if !strings.Contains(got, test.want) {
+			t.Errorf("label %q: got User-Agent %q want it to contain %q", test.label, got, test.want)
+		}
+	}
+}
+
+type traceIDKey struct{}
+
+func TestNewCorrelationPolicyFactory(t *testing.T) {
+	fromContext := func(ctx context.Context) string {
+		id, _ := ctx.Value(traceIDKey{}).(string)
+		return id
+	}
+	tests := []struct {
+		description string
+		header      string
+		ctx         context.Context
+		wantHeader  string
+		want        string // "" means a random ID for x-ms-client-request-id
+	}{
+		{description: "request ID", ctx: context.WithValue(context.Background(), traceIDKey{}, "trace-1"), wantHeader: "x-ms-client-request-id", want: "trace-1"},
+		{description: "no ID in context", ctx: context.Background(), wantHeader: "x-ms-client-request-id"},
+		{description: "custom header", header: "X-Trace", ctx: context.WithValue(context.Background(), traceIDKey{}, "trace-2"), wantHeader: "X-Trace", want: "trace-2"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var got http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header
+			}))
+			defer srv.Close()
+			p := NewPipelineWithPolicies(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}, PipelinePolicies{
+				PerCall: []pipeline.Factory{NewCorrelationPolicyFactory(fromContext, test.header)},
+			})
+			b, err := OpenBucket(context.Background(), p, accountName, "mycontainer", &Options{
+				Protocol:      "http",
+				StorageDomain: StorageDomain(strings.TrimPrefix(srv.URL, "http://")),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			if _, err := b.Attributes(test.ctx, "my-key"); err != nil {
+				t.Fatal(err)
+			}
+			v := got.Get(test.wantHeader)
+			if test.want != "" && v != test.want || test.want == "" && v == "" {
+				t.Errorf("got %s %q want %q", test.wantHeader, v, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// readAheadSize returns the chunk size used for Options.ReadAhead.
+func (b *bucket) readAheadSize() int64 {
+	if b.opts.ReadAheadSize > 0 {
+		return b.opts.ReadAheadSize
+	}
+	return defaultReadAheadSize
+}
+
+// chunkResult is the result of fetching a chunk for a prefetchReader.
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// prefetchReader reads a range of a blob as a sequence of chunks, fetching
+// up to readAhead chunks concurrently ahead of the one being read.
+type prefetchReader struct {
+	cancel  context.CancelFunc
+	cur     io.ReadCloser         // the chunk being read
+	pending chan chan chunkResult // chunks being fetched, in order
+	// stopErr is set by the goroutine queuing the chunks before it closes
+	// pending, if it stopped before queuing all of them.
+	stopErr error
+	err     error // sticky error
+}
+
+// newPrefetchReader returns a prefetchReader that reads first, then the
+// blob's content from offset next to stop, in chunks of chunkSize bytes.
+func newPrefetchReader(ctx context.Context, blobURL *azblob.BlockBlobURL, ac azblob.BlobAccessConditions, retryOpts azblob.RetryReaderOptions, first io.ReadCloser, next, stop, chunkSize int64, readAhead int) *prefetchReader {
+	ctx, cancel := context.WithCancel(ctx)
+	// The channel holds the chunks that are fetched but not yet being
+	// read, so its capacity bounds the number of concurrent fetches.
+	pending := make(chan chan chunkResult, readAhead)
+	r := &prefetchReader{cancel: cancel, cur: first, pending: pending}
+	go func() {
+		defer close(pending)
+		for off := next; off < stop; off += chunkSize {
+			count := chunkSize
+			if off+count > stop {
+				count = stop - off
+			}
+			res := make(chan chunkResult, 1)
+			select {
+			case pending <- res:
+			case <-ctx.Done():
+				// Without this, the reader would see the end of the
+				// chunks queued so far as the end of the range.
+				r.stopErr = ctx.Err()
+				return
+			}
+			go func(off, count int64) {
+				resp, err := blobURL.Download(ctx, off, count, ac, false, azblob.ClientProvidedKeyOptions{})
+				if err != nil {
+					res <- chunkResult{err: err}
+					return
+				}
+				body := resp.Body(retryOpts)
+				defer body.Close()
+				data, err := ioutil.ReadAll(body)
+				res <- chunkResult{data: data, err: err}
+			}(off, count)
+		}
+	}()
+	return r
+}
+
+func (r *prefetchReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.cur.Read(p)
+	if err == io.EOF {
+		// Move on to the next chunk; its data is returned by the next call.
+		err = r.nextChunk()
+		if err == nil && n == 0 {
+			return r.Read(p)
+		}
+	}
+	if err != nil {
+		r.err = err
+		if n > 0 {
+			// Report the error on the next call.
+			err = nil
+		}
+	}
+	return n, err
+}
+
+// nextChunk waits for the next chunk and makes it the current one. It
+// returns io.EOF if there are no more chunks, or the context error if the
+// chunks stopped being fetched before the end of the range.
+func (r *prefetchReader) nextChunk() error {
+	r.cur.Close()
+	res, ok := <-r.pending
+	if !ok {
+		// Closing pending happens after setting stopErr.
+		if r.stopErr != nil {
+			return r.stopErr
+		}
+		return io.EOF
+	}
+	chunk := <-res
+	if chunk.err != nil {
+		return chunk.err
+	}
+	r.cur = ioutil.NopCloser(bytes.NewReader(chunk.data))
+	return nil
+}
+
+func (r *prefetchReader) Close() error {
+	r.cancel()
+	return r.cur.Close()
+}
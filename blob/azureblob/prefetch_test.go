@@ -0,0 +1,151 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/gcerrors"
+)
+
+// rangeServer serves content as a blob, honoring ranges and If-Match.
+type rangeServer struct {
+	mu       sync.Mutex
+	content  string
+	etag     string
+	requests int
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if m := r.Header.Get("If-Match"); m != "" && m != s.etag {
+		w.Header().Set("x-ms-error-code", "ConditionNotMet")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+	start, end := 0, len(s.content)-1
+	if rng := r.Header.Get("x-ms-range"); rng != "" {
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+			fmt.Sscanf(rng, "bytes=%d-", &start)
+		}
+		if end >= len(s.content) {
+			end = len(s.content) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.content)))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	fmt.Fprint(w, s.content[start:end+1])
+}
+
+func TestReadAhead(t *testing.T) {
+	content := strings.Repeat("0123456789", 9) + "abcde" // 95 bytes
+	tests := []struct {
+		description    string
+		offset, length int64
+		wantRequests   int
+	}{
+		{"whole blob", 0, -1, 10},
+		{"range", 5, 37, 4},
+		{"range to end", 90, -1, 1},
+		{"range past end", 85, 100, 1},
+		{"single chunk", 0, 10, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			srv := &rangeServer{content: content, etag: `"1"`}
+			b, done := newTestBucket(t, &Options{ReadAhead: 3, ReadAheadSize: 10}, srv.ServeHTTP)
+			defer done()
+			r, err := b.NewRangeReader(context.Background(), "my-key", test.offset, test.length, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := content[test.offset:]
+			if test.length >= 0 && int(test.offset+test.length) < len(content) {
+				want = content[test.offset : test.offset+test.length]
+			}
+			if string(got) != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+			if srv.requests != test.wantRequests {
+				t.Errorf("got %d requests want %d", srv.requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestReadAheadBlobChanged(t *testing.T) {
+	srv := &rangeServer{content: strings.Repeat("x", 100), etag: `"1"`}
+	b, done := newTestBucket(t, &Options{ReadAhead: 2, ReadAheadSize: 10}, func(w http.ResponseWriter, r *http.Request) {
+		srv.ServeHTTP(w, r)
+		// The blob changes after the first request.
+		srv.mu.Lock()
+		srv.etag = `"2"`
+		srv.mu.Unlock()
+	})
+	defer done()
+	r, err := b.NewReader(context.Background(), "my-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v want FailedPrecondition", err)
+	}
+}
+
+func TestReadAheadCanceled(t *testing.T) {
+	srv := &rangeServer{content: strings.Repeat("x", 100), etag: `"1"`}
+	b, done := newTestBucket(t, &Options{ReadAhead: 1, ReadAheadSize: 10}, srv.ServeHTTP)
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := b.NewReader(ctx, "my-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// Wait for the first request and the queued chunk, after which the
+	// chunks are no longer fetched until the reader makes room.
+	for {
+		srv.mu.Lock()
+		n := srv.requests
+		srv.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	got, err := ioutil.ReadAll(r)
+	if err == nil {
+		t.Errorf("got %d bytes and no error want an error", len(got))
+	}
+}
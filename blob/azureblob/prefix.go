@@ -0,0 +1,172 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+const defaultPrefixParallelism = 16
+
+// PrefixOptions sets options for CopyPrefix and DeletePrefix.
+type PrefixOptions struct {
+	// Parallelism is the maximum number of blobs processed concurrently.
+	// Defaults to 16.
+	Parallelism int
+	// CopyOptions are passed to blob.Bucket.Copy for each blob by
+	// CopyPrefix.
+	CopyOptions *blob.CopyOptions
+}
+
+// PrefixError is returned by CopyPrefix, DeletePrefix, and DeleteByTags when
+// some blobs couldn't be processed. The other blobs were processed
+// successfully.
+type PrefixError struct {
+	// Errors holds the error for each failed blob, by source key.
+	Errors map[string]error
+	// ListErr is the error that stopped listing the blobs, if any. Blobs
+	// after the point where listing failed were not processed.
+	ListErr error
+}
+
+func (e *PrefixError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for k := range e.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	msg := fmt.Sprintf("azureblob: %d blobs failed", len(keys))
+	if len(keys) > 0 {
+		msg += fmt.Sprintf("; first error (key %q): %v", keys[0], e.Errors[keys[0]])
+	}
+	if e.ListErr != nil {
+		msg += fmt.Sprintf("; listing failed: %v", e.ListErr)
+	}
+	return msg
+}
+
+// Unwrap returns ListErr.
+func (e *PrefixError) Unwrap() error {
+	return e.ListErr
+}
+
+// CopyPrefix copies every blob whose key starts with srcPrefix to the key
+// with srcPrefix replaced by dstPrefix, issuing up to opts.Parallelism copies
+// at a time. opts may be nil.
+//
+// If some copies fail, CopyPrefix copies the remaining blobs and returns a
+// *PrefixError describing the failures. If listing fails, it returns that
+// error after the copies already started have finished, in a *PrefixError
+// if some of them failed.
+//
+// dstPrefix must not start with srcPrefix, since the copies would then be
+// listed and copied again.
+func CopyPrefix(ctx context.Context, b *blob.Bucket, dstPrefix, srcPrefix string, opts *PrefixOptions) error {
+	if strings.HasPrefix(dstPrefix, srcPrefix) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: CopyPrefix: destination prefix %q is within source prefix %q", dstPrefix, srcPrefix)
+	}
+	if opts == nil {
+		opts = &PrefixOptions{}
+	}
+	return forEachInPrefix(ctx, b, srcPrefix, opts.Parallelism, func(key string) error {
+		return b.Copy(ctx, dstPrefix+strings.TrimPrefix(key, srcPrefix), key, opts.CopyOptions)
+	})
+}
+
+// DeletePrefix deletes every blob whose key starts with prefix, issuing up
+// to opts.Parallelism deletes at a time. opts may be nil.
+//
+// Errors are reported as for CopyPrefix. Blobs that are already gone when
+// they are deleted are not reported as failures.
+func DeletePrefix(ctx context.Context, b *blob.Bucket, prefix string, opts *PrefixOptions) error {
+	if opts == nil {
+		opts = &PrefixOptions{}
+	}
+	// azblob doesn't support blob batch requests, so each blob is deleted
+	// with its own request.
+	return forEachInPrefix(ctx, b, prefix, opts.Parallelism, func(key string) error {
+		if err := b.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+// forEachInPrefix calls fn with the key of each blob under prefix, with up
+// to parallelism calls running concurrently.
+func forEachInPrefix(ctx context.Context, b *blob.Bucket, prefix string, parallelism int, fn func(key string) error) error {
+	iter := b.List(&blob.ListOptions{Prefix: prefix})
+	return forEachKey(parallelism, func() (string, error) {
+		obj, err := iter.Next(ctx)
+		if err != nil {
+			return "", err
+		}
+		return obj.Key, nil
+	}, fn)
+}
+
+// forEachKey calls fn with each key returned by next until it returns
+// io.EOF, with up to parallelism calls running concurrently. Failures of fn
+// are returned as a *PrefixError once all calls have finished; an error from
+// next is returned as is, or as the ListErr of that *PrefixError.
+func forEachKey(parallelism int, next func() (string, error), fn func(key string) error) error {
+	if parallelism <= 0 {
+		parallelism = defaultPrefixParallelism
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = map[string]error{}
+		sem  = make(chan struct{}, parallelism)
+	)
+	var listErr error
+	for {
+		key, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			listErr = err
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(key); err != nil {
+				mu.Lock()
+				errs[key] = err
+				mu.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return &PrefixError{Errors: errs, ListErr: listErr}
+	}
+	return listErr
+}
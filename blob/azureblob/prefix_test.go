@@ -0,0 +1,169 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/gcerrors"
+)
+
+func TestCopyPrefix(t *testing.T) {
+	var mu sync.Mutex
+	var copied []string
+	inFlight, maxInFlight := 0, 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeListResponse(w, map[string]string{"src/a": "1", "src/b": "1", "src/c/d": "1", "src/fail": "1"})
+			return
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		src, err := url.Parse(r.Header.Get("x-ms-copy-source"))
+		if err != nil {
+			t.Error(err)
+		}
+		if strings.HasSuffix(src.Path, "/fail") {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		copied = append(copied, strings.TrimPrefix(src.Path, "/gocloudblobtests/mycontainer/")+" -> "+strings.TrimPrefix(r.URL.Path, "/gocloudblobtests/mycontainer/"))
+		mu.Unlock()
+		w.Header().Set("x-ms-copy-status", "success")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	defer done()
+
+	err := CopyPrefix(context.Background(), b, "dst/", "src/", &PrefixOptions{Parallelism: 2})
+	perr, ok := err.(*PrefixError)
+	if !ok {
+		t.Fatalf("got error %v want *PrefixError", err)
+	}
+	if len(perr.Errors) != 1 || gcerrors.Code(perr.Errors["src/fail"]) != gcerrors.NotFound {
+		t.Errorf("got errors %v want NotFound for src/fail only", perr.Errors)
+	}
+	sort.Strings(copied)
+	if want := []string{"src/a -> dst/a", "src/b -> dst/b", "src/c/d -> dst/c/d"}; !cmp.Equal(copied, want) {
+		t.Errorf("got copies %v want %v", copied, want)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("got %d concurrent copies want at most 2", maxInFlight)
+	}
+
+	// The copies would be listed again under an overlapping prefix.
+	for _, dst := range []string{"src/copy/", "src/"} {
+		if err := CopyPrefix(context.Background(), b, dst, "src/", nil); gcerrors.Code(err) != gcerrors.InvalidArgument {
+			t.Errorf("CopyPrefix to %q: got error %v want InvalidArgument", dst, err)
+		}
+	}
+}
+
+func TestForEachKeyListError(t *testing.T) {
+	listErr := errors.New("list failed")
+	keys := []string{"a", "fail"}
+	next := func() (string, error) {
+		if len(keys) == 0 {
+			return "", listErr
+		}
+		key := keys[0]
+		keys = keys[1:]
+		return key, nil
+	}
+	fn := func(key string) error {
+		if key == "fail" {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+	// Both the failure of "fail" and the listing error are reported.
+	err := forEachKey(1, next, fn)
+	perr, ok := err.(*PrefixError)
+	if !ok {
+		t.Fatalf("got error %v want *PrefixError", err)
+	}
+	if len(perr.Errors) != 1 || perr.Errors["fail"] != io.ErrUnexpectedEOF {
+		t.Errorf("got errors %v want fail only", perr.Errors)
+	}
+	if !errors.Is(err, listErr) {
+		t.Errorf("got error %v want it to wrap %v", err, listErr)
+	}
+
+	// Without other failures, the listing error is returned as is.
+	keys = []string{"a"}
+	if err := forEachKey(1, next, fn); err != listErr {
+		t.Errorf("got error %v want %v", err, listErr)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeListResponse(w, map[string]string{"dir/a": "1", "dir/gone": "1", "dir/leased": "1"})
+			return
+		}
+		if r.Method != http.MethodDelete {
+			t.Errorf("got %s %s", r.Method, r.URL)
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/gocloudblobtests/mycontainer/")
+		switch key {
+		case "dir/gone":
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case "dir/leased":
+			w.Header().Set("x-ms-error-code", "LeaseIdMissing")
+			w.WriteHeader(http.StatusPreconditionFailed)
+		default:
+			mu.Lock()
+			deleted = append(deleted, key)
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+	defer done()
+
+	err := DeletePrefix(context.Background(), b, "dir/", nil)
+	perr, ok := err.(*PrefixError)
+	if !ok {
+		t.Fatalf("got error %v want *PrefixError", err)
+	}
+	if _, ok := perr.Errors["dir/leased"]; !ok || len(perr.Errors) != 1 {
+		t.Errorf("got errors %v want dir/leased only", perr.Errors)
+	}
+	if want := []string{"dir/a"}; !cmp.Equal(deleted, want) {
+		t.Errorf("got deletes %v want %v", deleted, want)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"fmt"
+
+	"gocloud.dev/blob"
+)
+
+// ContentRange is the range of a blob returned by a range read, as reported
+// by Azure.
+type ContentRange struct {
+	// Start and End are the offsets of the first and last bytes of the range,
+	// inclusive.
+	Start, End int64
+	// Total is the size of the blob.
+	Total int64
+}
+
+// ReaderContentRange returns the range of the blob that r reads, as
+// reported by Azure in the Content-Range header of the response, so that
+// callers can check that their range was honored; for example, a range
+// extending beyond the end of the blob is truncated. It returns false if
+// Azure didn't return a range, which is the case when the whole blob is read
+// with blob.Bucket.NewReader, or if r reads no data.
+//
+// With Options.ReadAhead, the range covers all of the chunks read, not just
+// the first one. The raw header of the first response is available with
+// As, from azblob.DownloadResponse.ContentRange.
+func ReaderContentRange(r *blob.Reader) (ContentRange, bool) {
+	var cr ContentRange
+	return cr, r.As(&cr)
+}
+
+// parseContentRange parses a Content-Range header value of the form
+// "bytes <start>-<end>/<total>".
+func parseContentRange(s string) (ContentRange, bool) {
+	var cr ContentRange
+	if _, err := fmt.Sscanf(s, "bytes %d-%d/%d", &cr.Start, &cr.End, &cr.Total); err != nil {
+		return ContentRange{}, false
+	}
+	return cr, true
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReaderContentRange(t *testing.T) {
+	content := strings.Repeat("0123456789", 9) + "abcde" // 95 bytes
+	tests := []struct {
+		description    string
+		opts           *Options
+		offset, length int64
+		want           ContentRange
+		wantOK         bool
+	}{
+		{description: "range", offset: 5, length: 10, want: ContentRange{5, 14, 95}, wantOK: true},
+		{description: "range to end", offset: 90, length: -1, want: ContentRange{90, 94, 95}, wantOK: true},
+		{description: "range past end", offset: 85, length: 100, want: ContentRange{85, 94, 95}, wantOK: true},
+		{description: "empty range", offset: 5},
+		{description: "read-ahead", opts: &Options{ReadAhead: 3, ReadAheadSize: 10}, offset: 5, length: 37, want: ContentRange{5, 41, 95}, wantOK: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			srv := &rangeServer{content: content, etag: `"1"`}
+			b, done := newTestBucket(t, test.opts, srv.ServeHTTP)
+			defer done()
+			r, err := b.NewRangeReader(context.Background(), "my-key", test.offset, test.length, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, ok := ReaderContentRange(r)
+			if ok != test.wantOK || got != test.want {
+				t.Errorf("got %+v, %v want %+v, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/internal/gcerr"
+)
+
+// directorySASVersion is the first Azure Storage service version that
+// supports directory SASs. azblob can't sign them.
+const directorySASVersion = "2020-02-10"
+
+// SASResponseHeaders are response headers that a SAS can override for
+// downloads of the blob. Empty fields are not overridden.
+type SASResponseHeaders struct {
+	CacheControl       string // rscc
+	ContentDisposition string // rscd
+	ContentEncoding    string // rsce
+	ContentLanguage    string // rscl
+	ContentType        string // rsct
+}
+
+// SignedURLWithResponseHeaders is like blob.Bucket.SignedURL, but signs the
+// URL so that GET requests using it return the headers set in h instead of
+// the blob's stored properties. Azure ignores the overrides for other
+// methods.
+//
+// opts may be nil; opts.BeforeSign, if set, is called after the headers are
+// set and may change them.
+func SignedURLWithResponseHeaders(ctx context.Context, b *blob.Bucket, key string, opts *blob.SignedURLOptions, h *SASResponseHeaders) (string, error) {
+	var o blob.SignedURLOptions
+	if opts != nil {
+		o = *opts
+	}
+	beforeSign := o.BeforeSign
+	o.BeforeSign = func(asFunc func(interface{}) bool) error {
+		var v *azblob.BlobSASSignatureValues
+		if asFunc(&v) && h != nil {
+			if h.CacheControl != "" {
+				v.CacheControl = h.CacheControl
+			}
+			if h.ContentDisposition != "" {
+				v.ContentDisposition = h.ContentDisposition
+			}
+			if h.ContentEncoding != "" {
+				v.ContentEncoding = h.ContentEncoding
+			}
+			if h.ContentLanguage != "" {
+				v.ContentLanguage = h.ContentLanguage
+			}
+			if h.ContentType != "" {
+				v.ContentType = h.ContentType
+			}
+		}
+		if beforeSign != nil {
+			return beforeSign(asFunc)
+		}
+		return nil
+	}
+	return b.SignedURL(ctx, key, &o)
+}
+
+// SignedURLHeaders returns the headers that requests using a URL from
+// SignedURL with opts must include, beyond those any HTTP client sends.
+// opts may be nil.
+//
+// Azure requires PUT requests that create a blob to say what type of blob
+// to create, so signed PUT URLs only work with an "x-ms-blob-type:
+// BlockBlob" header; other methods need no extra headers.
+func SignedURLHeaders(opts *blob.SignedURLOptions) http.Header {
+	h := http.Header{}
+	if opts != nil && opts.Method == http.MethodPut {
+		h.Set("x-ms-blob-type", string(azblob.BlobBlockBlob))
+	}
+	return h
+}
+
+// SignedURLWithHeaders is like blob.Bucket.SignedURL, but also returns the
+// headers that requests using the URL must include (see SignedURLHeaders),
+// so that the URL can be handed to clients that know nothing about Azure.
+func SignedURLWithHeaders(ctx context.Context, b *blob.Bucket, key string, opts *blob.SignedURLOptions) (string, http.Header, error) {
+	u, err := b.SignedURL(ctx, key, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return u, SignedURLHeaders(opts), nil
+}
+
+// SignedDirectoryURL is like blob.Bucket.SignedURL, but returns a URL with a
+// SAS that grants access to all of the blobs under the directory dir,
+// recursively, instead of to a single blob. The SAS is appended to the
+// directory's URL; to use it for a blob under dir, append the blob's path to
+// the URL path.
+//
+// Directory SASs are only supported by storage accounts with a hierarchical
+// namespace (Azure Data Lake Storage Gen2), and can only be signed with a
+// shared key Options.Credential.
+//
+// The permissions granted depend on opts.Method: GET grants read and list,
+// PUT grants create and write, and DELETE grants delete. opts may be nil;
+// opts.BeforeSign is not called.
+func SignedDirectoryURL(ctx context.Context, b *blob.Bucket, dir string, opts *blob.SignedURLOptions) (string, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return "", err
+	}
+	cred, ok := drv.opts.Credential.(*azblob.SharedKeyCredential)
+	if !ok {
+		return "", gcerr.New(gcerr.Unimplemented, ErrNoSharedKeyCredential, 1, "azureblob: SignedDirectoryURL requires a shared key Options.Credential")
+	}
+	if opts == nil {
+		opts = &blob.SignedURLOptions{}
+	}
+	if opts.ContentType != "" || opts.EnforceAbsentContentType {
+		return "", gcerr.New(gcerr.Unimplemented, nil, 1, "azureblob: does not enforce Content-Type on PUT")
+	}
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return "", gcerr.New(gcerr.InvalidArgument, nil, 1, "azureblob: SignedDirectoryURL requires a non-empty directory")
+	}
+	var perms string
+	switch opts.Method {
+	case "", http.MethodGet:
+		perms = "rl"
+	case http.MethodPut:
+		perms = "cw"
+	case http.MethodDelete:
+		perms = "d"
+	default:
+		return "", gcerr.New(gcerr.Unimplemented, ErrUnsupportedMethod, 1, fmt.Sprintf("azureblob: unsupported Method %s", opts.Method))
+	}
+	expiry := opts.Expiry
+	if expiry == 0 {
+		expiry = blob.DefaultSignedURLExpiry
+	}
+	se := time.Now().UTC().Add(expiry).Format(azblob.SASTimeFormat)
+
+	name := drv.blobName(dir)
+	// See https://docs.microsoft.com/en-us/rest/api/storageservices/create-service-sas#version-2018-11-09-and-later.
+	stringToSign := strings.Join([]string{
+		perms,
+		"", // signed start
+		se,
+		fmt.Sprintf("/blob/%s/%s/%s", cred.AccountName(), drv.name, name),
+		"", // signed identifier
+		"", // signed IP
+		string(azblob.SASProtocolHTTPS),
+		directorySASVersion,
+		"d", // signed resource
+		"",  // signed snapshot time
+		"",  // rscc
+		"",  // rscd
+		"",  // rsce
+		"",  // rscl
+		"",  // rsct
+	}, "\n")
+	q := url.Values{
+		"sv":  {directorySASVersion},
+		"spr": {string(azblob.SASProtocolHTTPS)},
+		"se":  {se},
+		"sp":  {perms},
+		"sr":  {"d"},
+		"sdd": {strconv.Itoa(strings.Count(name, "/") + 1)},
+		"sig": {cred.ComputeHMACSHA256(stringToSign)},
+	}
+	u := drv.containerURL.NewBlobURL(name).URL()
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ValidateSignedURLOptions checks that b.SignedURL can sign a URL with opts,
+// without signing one: that the method is supported, that Content-Type
+// enforcement isn't requested, and that the expiry is within the validity
+// of the user delegation key when Options.Credential is one. It returns the
+// error SignedURL would return. opts may be nil.
+//
+// It doesn't check that b has a credential to sign with, which may require
+// a request to discover, nor the expiry when SignedURL would sign with a
+// user delegation key obtained with MSI; such keys are valid for 48 hours.
+func ValidateSignedURLOptions(b *blob.Bucket, opts *blob.SignedURLOptions) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	dopts, err := driverSignedURLOptions(opts)
+	if err != nil {
+		return err
+	}
+	return drv.validateSignedURLOptions(dopts)
+}
+
+// SignedHeadURL is like blob.Bucket.SignedURL, but returns a URL for HEAD
+// requests, which the portable type doesn't sign. It grants the same read
+// permission as a GET URL, so a client can check that the blob exists and
+// read its properties without being handed a URL for a GET.
+// opts.Method is ignored; opts may be nil.
+func SignedHeadURL(ctx context.Context, b *blob.Bucket, key string, opts *blob.SignedURLOptions) (string, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return "", err
+	}
+	var o blob.SignedURLOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Method = http.MethodHead
+	dopts, err := driverSignedURLOptions(&o)
+	if err != nil {
+		return "", err
+	}
+	u, err := drv.SignedURL(ctx, key, dopts)
+	return u, drv.wrapError(err, key)
+}
+
+// driverSignedURLOptions converts opts to the options passed to the driver,
+// applying the defaults that blob.Bucket.SignedURL applies.
+func driverSignedURLOptions(opts *blob.SignedURLOptions) (*driver.SignedURLOptions, error) {
+	if opts == nil {
+		opts = &blob.SignedURLOptions{}
+	}
+	dopts := &driver.SignedURLOptions{
+		Expiry:                   opts.Expiry,
+		Method:                   opts.Method,
+		ContentType:              opts.ContentType,
+		EnforceAbsentContentType: opts.EnforceAbsentContentType,
+		BeforeSign:               opts.BeforeSign,
+	}
+	switch {
+	case dopts.Expiry < 0:
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: Expiry must be >= 0 (%v)", dopts.Expiry)
+	case dopts.Expiry == 0:
+		dopts.Expiry = blob.DefaultSignedURLExpiry
+	}
+	if dopts.Method == "" {
+		dopts.Method = http.MethodGet
+	}
+	return dopts, nil
+}
@@ -0,0 +1,303 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestSignedURLWithResponseHeaders(t *testing.T) {
+	cred, err := NewCredential(accountName, AccountKey(base64.StdEncoding.EncodeToString([]byte("not a real key"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, done := newTestBucket(t, &Options{Credential: cred}, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	})
+	defer done()
+
+	h := &SASResponseHeaders{
+		CacheControl:       "no-cache",
+		ContentDisposition: "attachment",
+		ContentEncoding:    "gzip",
+		ContentLanguage:    "fr",
+		ContentType:        "text/plain",
+	}
+	beforeSignCalled := false
+	opts := &blob.SignedURLOptions{
+		BeforeSign: func(asFunc func(interface{}) bool) error {
+			beforeSignCalled = true
+			var v *azblob.BlobSASSignatureValues
+			if !asFunc(&v) {
+				t.Error("BeforeSign: As failed")
+			} else if v.ContentLanguage != "fr" {
+				t.Errorf("BeforeSign: got ContentLanguage %q want %q", v.ContentLanguage, "fr")
+			}
+			return nil
+		},
+	}
+	signed, err := SignedURLWithResponseHeaders(context.Background(), b, "my-key", opts, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !beforeSignCalled {
+		t.Error("BeforeSign was not called")
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	for param, want := range map[string]string{
+		"rscc": "no-cache",
+		"rscd": "attachment",
+		"rsce": "gzip",
+		"rscl": "fr",
+		"rsct": "text/plain",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("got %s=%q want %q", param, got, want)
+		}
+	}
+
+	// Empty fields are not overridden.
+	signed, err = SignedURLWithResponseHeaders(context.Background(), b, "my-key", nil, &SASResponseHeaders{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err = url.Parse(signed); err != nil {
+		t.Fatal(err)
+	}
+	for _, param := range []string{"rscc", "rscd", "rsce", "rscl"} {
+		if _, ok := u.Query()[param]; ok {
+			t.Errorf("got %s in URL %q, want it absent", param, signed)
+		}
+	}
+}
+
+func TestSignedURLHeaders(t *testing.T) {
+	tests := []struct {
+		opts *blob.SignedURLOptions
+		want http.Header
+	}{
+		{nil, http.Header{}},
+		{&blob.SignedURLOptions{Method: http.MethodGet}, http.Header{}},
+		{&blob.SignedURLOptions{Method: http.MethodPut}, http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}},
+	}
+	for _, test := range tests {
+		if got := SignedURLHeaders(test.opts); !cmp.Equal(got, test.want) {
+			t.Errorf("SignedURLHeaders(%+v): got %v want %v", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestSignedURLWithHeaders(t *testing.T) {
+	cred, err := NewCredential(accountName, AccountKey(base64.StdEncoding.EncodeToString([]byte("not a real key"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotBlobType string
+	b, done := newTestBucket(t, &Options{Credential: cred}, func(w http.ResponseWriter, r *http.Request) {
+		gotBlobType = r.Header.Get("x-ms-blob-type")
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer done()
+
+	signed, h, err := SignedURLWithHeaders(context.Background(), b, "my-key", &blob.SignedURLOptions{Method: http.MethodPut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, signed, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if gotBlobType != "BlockBlob" {
+		t.Errorf("got x-ms-blob-type %q want %q", gotBlobType, "BlockBlob")
+	}
+}
+
+func TestSignedDirectoryURL(t *testing.T) {
+	key := []byte("not a real key")
+	cred, err := NewCredential(accountName, AccountKey(base64.StdEncoding.EncodeToString(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, done := newTestBucket(t, &Options{Credential: cred}, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	})
+	defer done()
+
+	signed, err := SignedDirectoryURL(context.Background(), b, "/logs/2021/", &blob.SignedURLOptions{Expiry: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/gocloudblobtests/mycontainer/logs/2021"; u.Path != want {
+		t.Errorf("got path %q want %q", u.Path, want)
+	}
+	q := u.Query()
+	for param, want := range map[string]string{"sr": "d", "sdd": "2", "sp": "rl", "sv": "2020-02-10", "spr": "https"} {
+		if got := q.Get(param); got != want {
+			t.Errorf("got %s=%q want %q", param, got, want)
+		}
+	}
+	stringToSign := "rl\n\n" + q.Get("se") + "\n/blob/" + string(accountName) + "/mycontainer/logs/2021\n\n\nhttps\n2020-02-10\nd\n\n\n\n\n\n"
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); q.Get("sig") != want {
+		t.Errorf("got sig %q want %q", q.Get("sig"), want)
+	}
+
+	// Without a shared key credential, it fails.
+	b2, done2 := newTestBucket(t, nil, nil)
+	defer done2()
+	if _, err := SignedDirectoryURL(context.Background(), b2, "logs", nil); gcerrors.Code(err) != gcerrors.Unimplemented || !errors.Is(err, ErrNoSharedKeyCredential) {
+		t.Errorf("got error %v want Unimplemented ErrNoSharedKeyCredential", err)
+	}
+}
+
+func TestSignedHeadURL(t *testing.T) {
+	cred, err := NewCredential(accountName, AccountKey(base64.StdEncoding.EncodeToString([]byte("not a real key"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, done := newTestBucket(t, &Options{Credential: cred}, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	})
+	defer done()
+
+	signed, err := SignedHeadURL(context.Background(), b, "my-key", &blob.SignedURLOptions{Method: http.MethodPut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("sp"); got != "r" {
+		t.Errorf("got sp=%q want %q", got, "r")
+	}
+	if _, err := SignedHeadURL(context.Background(), b, "my-key", &blob.SignedURLOptions{Expiry: -time.Hour}); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+}
+
+func TestValidateSignedURLOptions(t *testing.T) {
+	cred, err := NewCredential(accountName, AccountKey(base64.StdEncoding.EncodeToString([]byte("not a real key"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delegationCred := azblob.NewUserDelegationCredential(string(accountName), azblob.UserDelegationKey{})
+	tests := []struct {
+		description string
+		cred        azblob.StorageAccountCredential
+		opts        *blob.SignedURLOptions
+		want        gcerrors.ErrorCode
+	}{
+		{description: "nil options", cred: cred, want: gcerrors.OK},
+		{description: "PUT", cred: cred, opts: &blob.SignedURLOptions{Method: http.MethodPut}, want: gcerrors.OK},
+		{description: "HEAD", opts: &blob.SignedURLOptions{Method: http.MethodHead}, want: gcerrors.OK},
+		{description: "unsupported method", cred: cred, opts: &blob.SignedURLOptions{Method: http.MethodPost}, want: gcerrors.Unimplemented},
+		{description: "content type", cred: cred, opts: &blob.SignedURLOptions{Method: http.MethodPut, ContentType: "text/plain"}, want: gcerrors.Unimplemented},
+		{description: "negative expiry", cred: cred, opts: &blob.SignedURLOptions{Expiry: -time.Hour}, want: gcerrors.InvalidArgument},
+		{description: "long expiry with shared key", cred: cred, opts: &blob.SignedURLOptions{Expiry: 30 * 24 * time.Hour}, want: gcerrors.OK},
+		{description: "long expiry with delegation key", cred: delegationCred, opts: &blob.SignedURLOptions{Expiry: 8 * 24 * time.Hour}, want: gcerrors.InvalidArgument},
+		{description: "short expiry with delegation key", cred: delegationCred, opts: &blob.SignedURLOptions{Expiry: 72 * time.Hour}, want: gcerrors.OK},
+		// The bucket may sign with a shared key from its pipeline.
+		{description: "long expiry without credential", opts: &blob.SignedURLOptions{Expiry: 72 * time.Hour}, want: gcerrors.OK},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, &Options{Credential: test.cred}, func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			})
+			defer done()
+			err := ValidateSignedURLOptions(b, test.opts)
+			if got := gcerrors.Code(err); got != test.want {
+				t.Errorf("got error %v want code %v", err, test.want)
+			}
+			if got, want := errors.Is(err, ErrUnsupportedMethod), test.description == "unsupported method"; got != want {
+				t.Errorf("got errors.Is(err, ErrUnsupportedMethod) %v want %v", got, want)
+			}
+			if test.cred == nil || err != nil {
+				return
+			}
+			// SignedURL agrees.
+			if _, err := b.SignedURL(context.Background(), "my-key", test.opts); err != nil {
+				t.Errorf("SignedURL failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestRefreshDelegationCredentials(t *testing.T) {
+	requests := 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") != "userdelegationkey" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		requests++
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><UserDelegationKey><Value>a2V5</Value></UserDelegationKey>`)
+	})
+	defer done()
+	drv, err := driverBucket(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	refresh := func(minValidity time.Duration, want int) {
+		t.Helper()
+		if _, err := drv.refreshDelegationCredentials(ctx, minValidity); err != nil {
+			t.Fatal(err)
+		}
+		if requests != want {
+			t.Errorf("got %d requests want %d", requests, want)
+		}
+	}
+	refresh(time.Hour, 1)
+	// The key is still valid long enough.
+	refresh(24*time.Hour, 1)
+	// The key expires too soon.
+	drv.credentialExpiration = time.Now().Add(24 * time.Hour)
+	refresh(36*time.Hour, 2)
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+)
+
+// CreateSnapshot creates a read-only snapshot of the current content and
+// metadata of the blob at key, and returns its timestamp, which identifies
+// it in ListSnapshots and DeleteSnapshot.
+func CreateSnapshot(ctx context.Context, b *blob.Bucket, key string) (string, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return "", err
+	}
+	blobURL := drv.containerURL.NewBlobURL(drv.blobName(key))
+	resp, err := blobURL.CreateSnapshot(ctx, azblob.Metadata{}, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return "", drv.wrapError(err, key)
+	}
+	return resp.Snapshot(), nil
+}
+
+// ListSnapshots returns the timestamps of the snapshots of the blob at key,
+// oldest first. It returns an empty list if the blob has no snapshots, even
+// if it doesn't exist.
+func ListSnapshots(ctx context.Context, b *blob.Bucket, key string) ([]string, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return nil, err
+	}
+	name := drv.blobName(key)
+	opts := azblob.ListBlobsSegmentOptions{
+		Prefix:  name,
+		Details: azblob.BlobListingDetails{Snapshots: true},
+	}
+	var snapshots []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := drv.containerURL.ListBlobsFlatSegment(ctx, marker, opts)
+		if err != nil {
+			return nil, drv.wrapError(err, key)
+		}
+		for _, item := range resp.Segment.BlobItems {
+			// The prefix also matches other blobs whose names start with
+			// the key.
+			if item.Name == name && item.Snapshot != "" {
+				snapshots = append(snapshots, item.Snapshot)
+			}
+		}
+		marker = resp.NextMarker
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot deletes the snapshot of the blob at key with the given
+// timestamp, leaving the blob and its other snapshots unchanged.
+func DeleteSnapshot(ctx context.Context, b *blob.Bucket, key, snapshot string) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	blobURL := drv.containerURL.NewBlobURL(drv.blobName(key)).WithSnapshot(snapshot)
+	_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	return drv.wrapError(err, key)
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeSnapshots is a fake Azure Blob Storage server for the snapshots of
+// blobs.
+type fakeSnapshots struct {
+	mu        sync.Mutex
+	n         int
+	snapshots map[string][]string // blob name -> snapshot timestamps
+}
+
+func (f *fakeSnapshots) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/gocloudblobtests/mycontainer/")
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "snapshot":
+		f.n++
+		snapshot := fmt.Sprintf("2021-03-0%dT00:00:00.0000000Z", f.n)
+		f.snapshots[name] = append(f.snapshots[name], snapshot)
+		w.Header().Set("x-ms-snapshot", snapshot)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && q.Get("comp") == "list":
+		if q.Get("include") != "snapshots" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Each snapshot is returned on its own page.
+		var sb strings.Builder
+		sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+		var all []string
+		for _, n := range []string{q.Get("prefix"), q.Get("prefix") + "-other"} {
+			for _, s := range f.snapshots[n] {
+				all = append(all, n+"|"+s)
+			}
+		}
+		i := 0
+		fmt.Sscanf(q.Get("marker"), "%d", &i)
+		if i < len(all) {
+			parts := strings.SplitN(all[i], "|", 2)
+			fmt.Fprintf(&sb, `<Blob><Name>%s</Name><Snapshot>%s</Snapshot><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified></Properties></Blob>`, parts[0], parts[1])
+		}
+		sb.WriteString(`</Blobs>`)
+		if i+1 < len(all) {
+			fmt.Fprintf(&sb, `<NextMarker>%d</NextMarker>`, i+1)
+		} else {
+			sb.WriteString(`<NextMarker />`)
+		}
+		sb.WriteString(`</EnumerationResults>`)
+		fmt.Fprint(w, sb.String())
+	case r.Method == http.MethodDelete:
+		snapshot := q.Get("snapshot")
+		for i, s := range f.snapshots[name] {
+			if s == snapshot {
+				f.snapshots[name] = append(f.snapshots[name][:i], f.snapshots[name][i+1:]...)
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+		}
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeSnapshots{snapshots: map[string][]string{}}
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	var created []string
+	for _, key := range []string{"my-key", "my-key-other", "my-key", "my-key"} {
+		s, err := CreateSnapshot(ctx, b, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == "my-key" {
+			created = append(created, s)
+		}
+	}
+	got, err := ListSnapshots(ctx, b, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, created); diff != "" {
+		t.Errorf("diff (-got +want):\n%s", diff)
+	}
+
+	if err := DeleteSnapshot(ctx, b, "my-key", created[1]); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ListSnapshots(ctx, b, "my-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{created[0], created[2]}; !cmp.Equal(got, want) {
+		t.Errorf("got snapshots %v want %v", got, want)
+	}
+	if got := fake.snapshots["my-key-other"]; len(got) != 1 {
+		t.Errorf("got %d snapshots of another blob, want 1", len(got))
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+// IncludeTags can be used as blob.ListOptions.BeforeList to make List
+// return the index tags of each blob along with it, instead of requiring a
+// request per blob to fetch them. Use ListObjectTags to read the tags.
+//
+// To include other details too, use ListInclude instead.
+func IncludeTags(asFunc func(interface{}) bool) error {
+	var opts *azblob.ListBlobsSegmentOptions
+	if asFunc(&opts) {
+		opts.Details.Tags = true
+	}
+	return nil
+}
+
+// ListObjectTags returns the index tags of obj, a blob returned by List with
+// tags included (see IncludeTags). It returns nil if obj has no tags, if tags
+// weren't included, or if obj is a directory.
+func ListObjectTags(obj *blob.ListObject) map[string]string {
+	var item azblob.BlobItemInternal
+	if !obj.As(&item) || item.BlobTags == nil {
+		return nil
+	}
+	return tagsToMap(item.BlobTags.BlobTagSet)
+}
+
+// attributesTagsKey is the context key used to make the driver's Attributes
+// fetch the blob's tags.
+type attributesTagsKey struct{}
+
+// WithAttributesTags returns a context that makes blob.Bucket.Attributes
+// calls using it also fetch the index tags of the blob, for AttributesTags.
+// Tags are fetched with a second request, which is skipped if the blob has
+// no tags.
+func WithAttributesTags(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attributesTagsKey{}, true)
+}
+
+// AttributesTags returns the index tags of the blob described by attrs, as
+// returned by blob.Bucket.Attributes with a context from WithAttributesTags.
+// It returns nil and false if tags weren't requested, and nil and true if
+// the blob has no tags.
+func AttributesTags(attrs *blob.Attributes) (map[string]string, bool) {
+	var tags azblob.BlobTags
+	if !attrs.As(&tags) {
+		return nil, false
+	}
+	return tagsToMap(tags.BlobTagSet), true
+}
+
+// PartialUpdateError is returned by SetMetadataAndTags when the metadata of
+// the blob was replaced but its tags weren't.
+type PartialUpdateError struct {
+	// Key is the key of the blob.
+	Key string
+	// Err is the error from setting the tags.
+	Err error
+}
+
+func (e *PartialUpdateError) Error() string {
+	return fmt.Sprintf("azureblob: metadata of blob %q was set, but its tags weren't: %v", e.Key, e.Err)
+}
+
+func (e *PartialUpdateError) Unwrap() error {
+	return e.Err
+}
+
+// SetMetadataAndTags replaces the metadata and the index tags of the blob at
+// key, without changing its content. Metadata is escaped like
+// blob.WriterOptions.Metadata. A nil md or tags leaves the metadata or the
+// tags unchanged; an empty non-nil one removes them all.
+//
+// Azure stores metadata and tags separately, so they are set with two
+// requests, metadata first. If the metadata is set but setting the tags
+// fails, SetMetadataAndTags returns a *PartialUpdateError, and retrying the
+// call sets both again. Its error code is that of the failure.
+func SetMetadataAndTags(ctx context.Context, b *blob.Bucket, key string, md, tags map[string]string) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	blobURL := drv.containerURL.NewBlobURL(drv.blobName(key))
+	if md != nil {
+		escaped, err := escapeMetadata(md)
+		if err != nil {
+			return gcerr.New(gcerr.InvalidArgument, err, 1, "azureblob: SetMetadataAndTags")
+		}
+		if _, err := blobURL.SetMetadata(ctx, escaped, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{}); err != nil {
+			return drv.wrapError(err, key)
+		}
+	}
+	if tags != nil {
+		if _, err := blobURL.SetTags(ctx, nil, nil, nil, nil, nil, nil, tags); err != nil {
+			err = drv.wrapError(err, key)
+			if md != nil {
+				return &PartialUpdateError{Key: key, Err: err}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func tagsToMap(tags []azblob.BlobTag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[tag.Key] = tag.Value
+	}
+	return m
+}
+
+// ifTagsKey is the context key for the tag condition set with WithIfTags.
+type ifTagsKey struct{}
+
+// WithIfTags returns a context that makes operations on blobs using it,
+// such as writes, deletes, and copies, only succeed if the blob's index tags
+// match expr, a tag filter expression like
+//
+//	"class" = 'public' AND "state" <> 'locked'
+//
+// For copies, the condition applies to the destination only; the source's
+// tags aren't checked. If the tags don't match, the operation fails with an
+// error whose code is gcerrors.FailedPrecondition.
+//
+// The condition is sent with every request of the operation that supports
+// it; the requests that stage blocks of a write don't, so the condition is
+// checked when the blocks are committed.
+func WithIfTags(ctx context.Context, expr string) context.Context {
+	return context.WithValue(ctx, ifTagsKey{}, expr)
+}
+
+// withoutIfTags returns a context like ctx without the condition set with
+// WithIfTags, for requests on blobs other than the one it applies to.
+func withoutIfTags(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ifTagsKey{}, nil)
+}
+
+// supportsIfTags reports whether request is a blob operation that accepts an
+// x-ms-if-tags condition.
+func supportsIfTags(request pipeline.Request) bool {
+	q := request.URL.Query()
+	return q.Get("restype") == "" && !(request.Method == http.MethodPut && q.Get("comp") == "block")
+}
+
+// TagDeleteOptions sets options for DeleteByTags.
+type TagDeleteOptions struct {
+	// Parallelism is the maximum number of blobs deleted concurrently.
+	// Defaults to 16.
+	Parallelism int
+	// DryRun makes DeleteByTags find the blobs to delete without deleting
+	// them.
+	DryRun bool
+}
+
+// DeleteByTags deletes every blob of the bucket whose index tags match expr,
+// a tag filter expression such as `"expired" = 'true'`, issuing up to
+// opts.Parallelism deletes at a time. See
+// https://docs.microsoft.com/en-us/rest/api/storageservices/find-blobs-by-tags#remarks
+// for the syntax of expr. opts may be nil.
+//
+// DeleteByTags returns the sorted keys of the blobs it deleted, or, with
+// opts.DryRun, of the blobs it would delete. Errors are reported as for
+// DeletePrefix; the keys of blobs that couldn't be deleted are not returned.
+//
+// The tag index is updated asynchronously, so blobs whose tags changed
+// shortly before the call may be missed or deleted based on their old tags.
+func DeleteByTags(ctx context.Context, b *blob.Bucket, expr string, opts *TagDeleteOptions) ([]string, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &TagDeleteOptions{}
+	}
+	// Find Blobs by Tags searches the whole account; restrict it to the
+	// bucket's container. Container names can't contain quotes.
+	where := fmt.Sprintf("@container='%s' AND %s", drv.name, expr)
+	var (
+		marker azblob.Marker
+		done   bool
+		page   []string
+	)
+	next := func() (string, error) {
+		for len(page) == 0 {
+			if done {
+				return "", io.EOF
+			}
+			resp, err := drv.serviceURL.FindBlobsByTags(ctx, nil, nil, &where, marker, nil)
+			if err != nil {
+				return "", drv.wrapError(err, "")
+			}
+			for _, item := range resp.Blobs {
+				if item.ContainerName == drv.name {
+					page = append(page, drv.keyFromBlobName(item.Name))
+				}
+			}
+			marker = azblob.Marker{Val: resp.NextMarker}
+			done = resp.NextMarker == nil || *resp.NextMarker == ""
+		}
+		key := page[0]
+		page = page[1:]
+		return key, nil
+	}
+
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	err = forEachKey(opts.Parallelism, next, func(key string) error {
+		if !opts.DryRun {
+			if err := b.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+				return err
+			}
+		}
+		mu.Lock()
+		keys = append(keys, key)
+		mu.Unlock()
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
@@ -0,0 +1,335 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestListObjectTags(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("include"); got != "tags" {
+			t.Errorf("got include=%q want %q", got, "tags")
+		}
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`+
+			`<Blob><Name>a</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length><TagCount>2</TagCount></Properties>`+
+			`<Tags><TagSet><Tag><Key>class</Key><Value>secret &amp; private</Value></Tag><Tag><Key>owner</Key><Value>me</Value></Tag></TagSet></Tags></Blob>`+
+			`<Blob><Name>b</Name><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`+
+			`</Blobs><NextMarker /></EnumerationResults>`)
+	})
+	defer done()
+
+	got := map[string]map[string]string{}
+	iter := b.List(&blob.ListOptions{BeforeList: IncludeTags})
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[obj.Key] = ListObjectTags(obj)
+	}
+	want := map[string]map[string]string{
+		"a": {"class": "secret & private", "owner": "me"},
+		"b": nil,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ListObjectTags diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAttributesTags(t *testing.T) {
+	tests := []struct {
+		description  string
+		ctx          context.Context
+		tagCount     string
+		want         map[string]string
+		wantOK       bool
+		wantRequests int
+	}{
+		{description: "not requested", ctx: context.Background(), tagCount: "1", wantRequests: 1},
+		{description: "no tags", ctx: WithAttributesTags(context.Background()), wantOK: true, wantRequests: 1},
+		{
+			description:  "tags",
+			ctx:          WithAttributesTags(context.Background()),
+			tagCount:     "1",
+			want:         map[string]string{"class": "secret & private"},
+			wantOK:       true,
+			wantRequests: 2,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			requests := 0
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.URL.Query().Get("comp") == "tags" {
+					fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Tags><TagSet><Tag><Key>class</Key><Value>secret &amp; private</Value></Tag></TagSet></Tags>`)
+					return
+				}
+				if test.tagCount != "" {
+					w.Header().Set("x-ms-tag-count", test.tagCount)
+				}
+			})
+			defer done()
+			attrs, err := b.Attributes(test.ctx, "my-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := AttributesTags(attrs)
+			if ok != test.wantOK {
+				t.Errorf("got ok %v want %v", ok, test.wantOK)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("diff (-got +want):\n%s", diff)
+			}
+			if requests != test.wantRequests {
+				t.Errorf("got %d requests want %d", requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSetMetadataAndTags(t *testing.T) {
+	tests := []struct {
+		description string
+		md, tags    map[string]string
+		failTags    bool
+		wantComps   []string
+		wantPartial bool
+		wantErr     gcerrors.ErrorCode
+	}{
+		{description: "both", md: map[string]string{"a": "b"}, tags: map[string]string{"c": "d"}, wantComps: []string{"metadata", "tags"}},
+		{description: "metadata only", md: map[string]string{"a": "b"}, wantComps: []string{"metadata"}},
+		{description: "tags only", tags: map[string]string{}, wantComps: []string{"tags"}},
+		{description: "tags fail", md: map[string]string{"a": "b"}, tags: map[string]string{"c": "d"}, failTags: true, wantComps: []string{"metadata", "tags"}, wantPartial: true, wantErr: gcerrors.PermissionDenied},
+		{description: "tags only fail", tags: map[string]string{"c": "d"}, failTags: true, wantComps: []string{"tags"}, wantErr: gcerrors.PermissionDenied},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var gotComps []string
+			var gotMD string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				comp := r.URL.Query().Get("comp")
+				gotComps = append(gotComps, comp)
+				if comp == "metadata" {
+					gotMD = r.Header.Get("x-ms-meta-a")
+				}
+				if comp == "tags" && test.failTags {
+					w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+			defer done()
+			err := SetMetadataAndTags(context.Background(), b, "my-key", test.md, test.tags)
+			if got := gcerrors.Code(err); got != test.wantErr {
+				t.Errorf("got error %v want code %v", err, test.wantErr)
+			}
+			var perr *PartialUpdateError
+			if got := errors.As(err, &perr); got != test.wantPartial {
+				t.Errorf("got PartialUpdateError %v want %v", got, test.wantPartial)
+			}
+			if diff := cmp.Diff(gotComps, test.wantComps); diff != "" {
+				t.Errorf("requests diff (-got +want):\n%s", diff)
+			}
+			if test.md != nil && gotMD != test.md["a"] {
+				t.Errorf("got x-ms-meta-a %q want %q", gotMD, test.md["a"])
+			}
+		})
+	}
+}
+
+func TestWithIfTags(t *testing.T) {
+	const expr = `"class" = 'public'`
+	var mu sync.Mutex
+	got := map[string]string{} // x-ms-if-tags by method and comp
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got[r.Method+" "+r.URL.Query().Get("comp")] = r.Header.Get("x-ms-if-tags")
+		mu.Unlock()
+		switch {
+		case r.Method == http.MethodDelete:
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+		case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+			w.Header().Set("x-ms-copy-status", "success")
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+	defer done()
+
+	ctx := WithIfTags(context.Background(), expr)
+	w, err := b.NewWriter(ctx, "my-key", &blob.WriterOptions{ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "my-key"); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v want FailedPrecondition", err)
+	}
+	if err := b.Copy(ctx, "dst", "src", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"PUT block":     "",
+		"PUT blocklist": expr,
+		"DELETE ":       expr,
+		"PUT ":          expr,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("x-ms-if-tags diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestWithIfTagsCopyTags(t *testing.T) {
+	const expr = `"class" = 'public'`
+	tags := map[string]string{
+		"/gocloudblobtests/mycontainer/src": "class=private",
+		"/gocloudblobtests/mycontainer/dst": "class=public",
+	}
+	var conditioned []string // requests sent with x-ms-if-tags
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-ms-if-tags") != "" {
+			conditioned = append(conditioned, r.Method+" "+r.URL.Path+"?comp="+r.URL.Query().Get("comp"))
+		}
+		// Only the destination's tags match expr.
+		if r.Header.Get("x-ms-if-tags") != "" && tags[r.URL.Path] != "class=public" {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		switch {
+		case r.URL.Query().Get("comp") == "tags" && r.Method == http.MethodGet:
+			fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Tags><TagSet><Tag><Key>class</Key><Value>private</Value></Tag></TagSet></Tags>`)
+		case r.URL.Query().Get("comp") == "tags":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("x-ms-copy-status", "success")
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+	defer done()
+
+	ctx := WithIfTags(context.Background(), expr)
+	tagOpts := &CopyTags{Directive: TagDirectiveCopy}
+	opts := &blob.CopyOptions{BeforeCopy: tagOpts.BeforeCopy}
+	if err := b.Copy(ctx, "dst", "src", opts); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"PUT /gocloudblobtests/mycontainer/dst?comp=",
+		"PUT /gocloudblobtests/mycontainer/dst?comp=tags",
+	}
+	if diff := cmp.Diff(conditioned, want); diff != "" {
+		t.Errorf("conditioned requests diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestDeleteByTags(t *testing.T) {
+	// Find Blobs by Tags results, by marker.
+	pages := map[string]string{
+		"": `<Blob><Name>a</Name><ContainerName>mycontainer</ContainerName></Blob>` +
+			`<Blob><Name>a</Name><ContainerName>othercontainer</ContainerName></Blob>` +
+			`<Blob><Name>leased</Name><ContainerName>mycontainer</ContainerName></Blob>` +
+			`</Blobs><NextMarker>page2</NextMarker>`,
+		"page2": `<Blob><Name>b</Name><ContainerName>mycontainer</ContainerName></Blob>` +
+			`<Blob><Name>gone</Name><ContainerName>mycontainer</ContainerName></Blob>` +
+			`</Blobs><NextMarker />`,
+	}
+	for _, dryRun := range []bool{false, true} {
+		var mu sync.Mutex
+		var gotWhere string
+		var deleted []string
+		b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				q := r.URL.Query()
+				gotWhere = q.Get("where")
+				fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>%s</EnumerationResults>`, pages[q.Get("marker")])
+				return
+			}
+			key := strings.TrimPrefix(r.URL.Path, "/gocloudblobtests/mycontainer/")
+			switch key {
+			case "gone":
+				w.Header().Set("x-ms-error-code", "BlobNotFound")
+				w.WriteHeader(http.StatusNotFound)
+			case "leased":
+				w.Header().Set("x-ms-error-code", "LeaseIdMissing")
+				w.WriteHeader(http.StatusPreconditionFailed)
+			default:
+				mu.Lock()
+				deleted = append(deleted, key)
+				mu.Unlock()
+				w.WriteHeader(http.StatusAccepted)
+			}
+		})
+		defer done()
+
+		keys, err := DeleteByTags(context.Background(), b, `"expired" = 'true'`, &TagDeleteOptions{DryRun: dryRun})
+		if want := `@container='mycontainer' AND "expired" = 'true'`; gotWhere != want {
+			t.Errorf("dry run %v: got where %q want %q", dryRun, gotWhere, want)
+		}
+		sort.Strings(deleted)
+		if dryRun {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"a", "b", "gone", "leased"}; !cmp.Equal(keys, want) {
+				t.Errorf("dry run: got keys %v want %v", keys, want)
+			}
+			if len(deleted) != 0 {
+				t.Errorf("dry run: got deletes %v want none", deleted)
+			}
+			continue
+		}
+		perr, ok := err.(*PrefixError)
+		if !ok {
+			t.Fatalf("got error %v want *PrefixError", err)
+		}
+		if _, ok := perr.Errors["leased"]; !ok || len(perr.Errors) != 1 {
+			t.Errorf("got errors %v want leased only", perr.Errors)
+		}
+		if want := []string{"a", "b", "gone"}; !cmp.Equal(keys, want) {
+			t.Errorf("got keys %v want %v", keys, want)
+		}
+		if want := []string{"a", "b"}; !cmp.Equal(deleted, want) {
+			t.Errorf("got deletes %v want %v", deleted, want)
+		}
+	}
+}
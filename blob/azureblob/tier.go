@@ -0,0 +1,112 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/internal/gcerr"
+)
+
+// TierInfo describes the access tier of a blob.
+type TierInfo struct {
+	// Tier is the access tier of the blob, e.g. azblob.AccessTierHot.
+	Tier azblob.AccessTierType
+	// Inferred is true if the blob has no tier of its own and Tier is the
+	// account's default tier. Changing the account default changes the
+	// tier of such blobs.
+	Inferred bool
+	// ChangeTime is the time the tier was last set explicitly; it is the
+	// zero time if it never was.
+	ChangeTime time.Time
+	// ArchiveStatus is the rehydration status of an archived blob, e.g.
+	// azblob.ArchiveStatusRehydratePendingToHot; it is empty otherwise.
+	ArchiveStatus azblob.ArchiveStatusType
+}
+
+// AttributesTier returns the access tier of the blob described by attrs, as
+// returned by blob.Bucket.Attributes. It returns false if attrs doesn't
+// come from this package or Azure didn't report a tier, as for page blobs in
+// standard storage accounts.
+func AttributesTier(attrs *blob.Attributes) (*TierInfo, bool) {
+	var props azblob.BlobGetPropertiesResponse
+	if !attrs.As(&props) || props.AccessTier() == "" {
+		return nil, false
+	}
+	return &TierInfo{
+		Tier:          azblob.AccessTierType(props.AccessTier()),
+		Inferred:      props.AccessTierInferred() == "true",
+		ChangeTime:    props.AccessTierChangeTime(),
+		ArchiveStatus: azblob.ArchiveStatusType(props.ArchiveStatus()),
+	}, true
+}
+
+// SetTierOptions sets options for SetTier.
+type SetTierOptions struct {
+	// Priority is the rehydrate priority when moving a blob out of the
+	// Archive tier: azblob.RehydratePriorityStandard (the default) or
+	// azblob.RehydratePriorityHigh, which is faster but costs more. It is
+	// ignored by Azure for other tier changes.
+	Priority azblob.RehydratePriorityType
+}
+
+// SetTier sets the access tier of the blob at key. Moving a blob out of the
+// Archive tier starts rehydrating it, which may take hours; SetTier returns
+// without waiting, and AttributesTier reports the progress in ArchiveStatus.
+// opts may be nil.
+func SetTier(ctx context.Context, b *blob.Bucket, key string, tier azblob.AccessTierType, opts *SetTierOptions) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	var priority azblob.RehydratePriorityType
+	if opts != nil {
+		priority = opts.Priority
+	}
+	if err := checkRehydratePriority("SetTier", priority); err != nil {
+		return err
+	}
+	// azblob's SetTier doesn't support setting the rehydrate priority, so
+	// issue the request directly.
+	u := drv.containerURL.NewBlobURL(drv.blobName(key)).URL()
+	q := u.Query()
+	q.Set("comp", "tier")
+	u.RawQuery = q.Encode()
+	header := http.Header{}
+	header.Set("x-ms-access-tier", string(tier))
+	if priority != azblob.RehydratePriorityNone {
+		header.Set("x-ms-rehydrate-priority", string(priority))
+	}
+	resp, err := drv.doRaw(ctx, http.MethodPut, u, header, nil)
+	if err != nil {
+		return drv.wrapError(err, key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// checkRehydratePriority returns an error if p isn't a rehydrate priority
+// known to Azure. An empty p means the default priority.
+func checkRehydratePriority(op string, p azblob.RehydratePriorityType) error {
+	switch p {
+	case azblob.RehydratePriorityNone, azblob.RehydratePriorityStandard, azblob.RehydratePriorityHigh:
+		return nil
+	}
+	return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: %s: rehydrate priority must be %s or %s, got %q", op, azblob.RehydratePriorityStandard, azblob.RehydratePriorityHigh, p)
+}
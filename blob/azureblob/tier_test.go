@@ -0,0 +1,108 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/gcerrors"
+)
+
+func TestAttributesTier(t *testing.T) {
+	tests := []struct {
+		description string
+		header      map[string]string
+		want        *TierInfo
+	}{
+		{
+			description: "explicit",
+			header: map[string]string{
+				"x-ms-access-tier":             "Archive",
+				"x-ms-access-tier-change-time": "Mon, 01 Mar 2021 00:00:00 GMT",
+				"x-ms-archive-status":          "rehydrate-pending-to-cool",
+			},
+			want: &TierInfo{
+				Tier:          azblob.AccessTierArchive,
+				ChangeTime:    time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+				ArchiveStatus: azblob.ArchiveStatusRehydratePendingToCool,
+			},
+		},
+		{
+			description: "no tier",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range test.header {
+					w.Header().Set(k, v)
+				}
+			})
+			defer done()
+			attrs, err := b.Attributes(context.Background(), "my-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := AttributesTier(attrs)
+			if ok != (test.want != nil) {
+				t.Fatalf("got ok %v want %v", ok, test.want != nil)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("AttributesTier diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetTier(t *testing.T) {
+	tests := []struct {
+		description  string
+		opts         *SetTierOptions
+		wantPriority string
+		wantErr      gcerrors.ErrorCode
+	}{
+		{description: "default priority"},
+		{description: "high priority", opts: &SetTierOptions{Priority: azblob.RehydratePriorityHigh}, wantPriority: "High"},
+		{description: "invalid priority", opts: &SetTierOptions{Priority: "Urgent"}, wantErr: gcerrors.InvalidArgument},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var gotQuery, gotTier, gotPriority string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				gotQuery = r.URL.Query().Get("comp")
+				gotTier = r.Header.Get("x-ms-access-tier")
+				gotPriority = r.Header.Get("x-ms-rehydrate-priority")
+				w.WriteHeader(http.StatusAccepted)
+			})
+			defer done()
+			err := SetTier(context.Background(), b, "my-key", azblob.AccessTierHot, test.opts)
+			if got := gcerrors.Code(err); got != test.wantErr {
+				t.Fatalf("got error %v want code %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if gotQuery != "tier" || gotTier != "Hot" || gotPriority != test.wantPriority {
+				t.Errorf("got comp=%q, tier %q, priority %q; want comp=tier, tier Hot, priority %q", gotQuery, gotTier, gotPriority, test.wantPriority)
+			}
+		})
+	}
+}
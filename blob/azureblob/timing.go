@@ -0,0 +1,85 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ReadTimingHooks are called at points of a read to measure its latency,
+// so that the time to first byte of reads can be measured separately from
+// their throughput. Either may be nil. To use them, set
+// blob.ReaderOptions.BeforeRead to the BeforeRead method:
+//
+//   hooks := &azureblob.ReadTimingHooks{FirstByte: recordTTFB}
+//   r, err := bucket.NewReader(ctx, key, &blob.ReaderOptions{BeforeRead: hooks.BeforeRead})
+type ReadTimingHooks struct {
+	// FirstByte is called with the time from the start of the read (the
+	// call to blob.Bucket.NewRangeReader) to the first byte of content
+	// returned by the reader, i.e., the time to first byte.
+	FirstByte func(ttfb time.Duration)
+	// Done is called once with the time from the start of the read to when
+	// the reader returned io.EOF or another error, or was closed, whichever
+	// happened first, and the number of bytes read.
+	Done func(elapsed time.Duration, n int64)
+}
+
+// BeforeRead applies h to a read; see ReadTimingHooks.
+func (h *ReadTimingHooks) BeforeRead(asFunc func(interface{}) bool) error {
+	var s *readSettings
+	if !asFunc(&s) {
+		return errors.New("azureblob: ReadTimingHooks can only be used with azureblob buckets")
+	}
+	s.timingHooks = h
+	return nil
+}
+
+// timedBody is an io.ReadCloser that calls hooks as it is read.
+type timedBody struct {
+	io.ReadCloser
+	hooks *ReadTimingHooks
+	start time.Time
+	n     int64
+	done  bool
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.n == 0 && b.hooks.FirstByte != nil {
+		b.hooks.FirstByte(time.Since(b.start))
+	}
+	b.n += int64(n)
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *timedBody) finish() {
+	if b.done {
+		return
+	}
+	b.done = true
+	if b.hooks.Done != nil {
+		b.hooks.Done(time.Since(b.start), b.n)
+	}
+}
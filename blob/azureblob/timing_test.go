@@ -0,0 +1,62 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+func TestReadTimingHooks(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		fmt.Fprint(w, "hello")
+	})
+	defer done()
+
+	var ttfb, elapsed time.Duration
+	var n int64
+	doneCalls := 0
+	hooks := &ReadTimingHooks{
+		FirstByte: func(d time.Duration) { ttfb = d },
+		Done: func(d time.Duration, bytes int64) {
+			doneCalls++
+			elapsed, n = d, bytes
+		},
+	}
+	r, err := b.NewReader(context.Background(), "my-key", &blob.ReaderOptions{BeforeRead: hooks.BeforeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ttfb < delay {
+		t.Errorf("got time to first byte %v, want at least %v", ttfb, delay)
+	}
+	if doneCalls != 1 || elapsed < ttfb || n != 5 {
+		t.Errorf("got %d Done calls, last with elapsed %v and %d bytes; want 1 call with at least %v and 5 bytes", doneCalls, elapsed, n, ttfb)
+	}
+}
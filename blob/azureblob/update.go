@@ -0,0 +1,128 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+const defaultUpdateAttempts = 5
+
+// UpdateOptions sets options for Update.
+type UpdateOptions struct {
+	// MaxAttempts is the maximum number of times fn is called before Update
+	// gives up. Defaults to 5.
+	MaxAttempts int
+	// WriterOptions are used for the conditional write; their BeforeWrite,
+	// if any, is called before the conditions are applied.
+	WriterOptions *blob.WriterOptions
+}
+
+// Update atomically replaces the content of the blob at key with the result
+// of calling fn on its current content, using optimistic concurrency: the
+// blob and its ETag are read, and the result of fn is written only if the
+// ETag still matches. If the blob was modified in the meantime, Update
+// starts over, up to opts.MaxAttempts times.
+//
+// If the blob doesn't exist, fn is called with nil and the write only
+// succeeds if the blob still doesn't exist. fn may be called more than once
+// and must not have side effects; if it returns an error, Update stops and
+// returns it.
+//
+// If every attempt conflicts with another writer, Update returns an error
+// whose code is gcerrors.FailedPrecondition. opts may be nil.
+func Update(ctx context.Context, b *blob.Bucket, key string, fn func(data []byte) ([]byte, error), opts *UpdateOptions) error {
+	if _, err := driverBucket(b); err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultUpdateAttempts
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		var data []byte
+		var etag azblob.ETag
+		data, etag, err = readWithETag(ctx, b, key)
+		if err != nil {
+			return err
+		}
+		data, err = fn(data)
+		if err != nil {
+			return err
+		}
+		err = b.WriteAll(ctx, key, data, conditionalWriterOptions(opts.WriterOptions, etag))
+		switch gcerrors.Code(err) {
+		case gcerrors.OK:
+			return nil
+		case gcerrors.FailedPrecondition, gcerrors.AlreadyExists:
+			// Someone else wrote the blob since it was read; try again.
+		default:
+			return err
+		}
+	}
+	return gcerr.Newf(gcerr.FailedPrecondition, err, "azureblob: Update: blob %q was modified concurrently on each of %d attempts", key, attempts)
+}
+
+// readWithETag returns the content and ETag of the blob at key, or nil and
+// an empty ETag if it doesn't exist.
+func readWithETag(ctx context.Context, b *blob.Bucket, key string) ([]byte, azblob.ETag, error) {
+	r, err := b.NewReader(ctx, key, nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, azblob.ETagNone, nil
+	}
+	if err != nil {
+		return nil, azblob.ETagNone, err
+	}
+	defer r.Close()
+	var resp azblob.DownloadResponse
+	if !r.As(&resp) {
+		return nil, azblob.ETagNone, gcerr.Newf(gcerr.Internal, nil, "azureblob: Update: no ETag for blob %q", key)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, azblob.ETagNone, err
+	}
+	return data, resp.ETag(), nil
+}
+
+// conditionalWriterOptions returns a copy of opts whose writes only succeed
+// if the blob's ETag is etag, or if it doesn't exist when etag is empty.
+func conditionalWriterOptions(opts *blob.WriterOptions, etag azblob.ETag) *blob.WriterOptions {
+	var o blob.WriterOptions
+	if opts != nil {
+		o = *opts
+	}
+	cond := &WriteOptions{IfMatch: string(etag), IfNotExists: etag == azblob.ETagNone}
+	before := o.BeforeWrite
+	o.BeforeWrite = func(asFunc func(interface{}) bool) error {
+		if before != nil {
+			if err := before(asFunc); err != nil {
+				return err
+			}
+		}
+		return cond.BeforeWrite(asFunc)
+	}
+	return &o
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gocloud.dev/gcerrors"
+)
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	appendX := func(data []byte) ([]byte, error) { return append(data, 'x'), nil }
+
+	// The blob is created if it doesn't exist.
+	if err := Update(ctx, b, "my-key", appendX, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A concurrent write during the first attempt forces a retry.
+	calls := 0
+	err := Update(ctx, b, "my-key", func(data []byte) ([]byte, error) {
+		calls++
+		if calls == 1 {
+			if err := b.WriteAll(ctx, "my-key", []byte("yy"), nil); err != nil {
+				return nil, err
+			}
+		}
+		return appendX(data)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls to fn, want 2", calls)
+	}
+	if got, want := string(fake.content("my-key")), "yyx"; got != want {
+		t.Errorf("got content %q want %q", got, want)
+	}
+
+	// Update gives up after MaxAttempts conflicts.
+	calls = 0
+	err = Update(ctx, b, "my-key", func(data []byte) ([]byte, error) {
+		calls++
+		if err := b.WriteAll(ctx, "my-key", []byte("zz"), nil); err != nil {
+			return nil, err
+		}
+		return appendX(data)
+	}, &UpdateOptions{MaxAttempts: 3})
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition || calls != 3 {
+		t.Errorf("got error %v after %d calls, want FailedPrecondition after 3", err, calls)
+	}
+
+	// Errors from fn are returned as is.
+	errFn := errors.New("fn failed")
+	if err := Update(ctx, b, "my-key", func([]byte) ([]byte, error) { return nil, errFn }, nil); err != errFn {
+		t.Errorf("got error %v want %v", err, errFn)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+)
+
+// IncludeVersions can be used as blob.ListOptions.BeforeList to make List
+// return all of the versions of each blob, for accounts with blob versioning
+// enabled, instead of only the current ones. Use ListObjectVersion to tell
+// the versions apart.
+//
+// To include other details too, use ListInclude instead.
+func IncludeVersions(asFunc func(interface{}) bool) error {
+	var opts *azblob.ListBlobsSegmentOptions
+	if asFunc(&opts) {
+		opts.Details.Versions = true
+	}
+	return nil
+}
+
+// IncludeDeleted can be used as blob.ListOptions.BeforeList to make List
+// also return soft-deleted blobs, for accounts with soft delete enabled.
+// Use ListObjectVersion to tell them apart.
+//
+// To include other details too, use ListInclude instead.
+func IncludeDeleted(asFunc func(interface{}) bool) error {
+	var opts *azblob.ListBlobsSegmentOptions
+	if asFunc(&opts) {
+		opts.Details.Deleted = true
+	}
+	return nil
+}
+
+// ObjectVersion describes the version of a blob returned by List.
+//
+// Azure has no delete markers: when the current version of a versioned blob
+// is deleted, it becomes a previous version. So a key whose versions are all
+// previous versions (IsCurrent is false) has been deleted, at the time its
+// most recent version stopped being current.
+type ObjectVersion struct {
+	// VersionID identifies the version. It is empty if versions weren't
+	// included or the account doesn't have versioning enabled.
+	VersionID string
+	// IsCurrent is true for the current version of the blob, and false for
+	// previous versions and soft-deleted blobs.
+	IsCurrent bool
+	// Deleted is true if the blob is soft-deleted.
+	Deleted bool
+}
+
+// ListObjectVersion returns the version of obj, a blob returned by List. It
+// returns false if obj is a directory or doesn't come from this package.
+func ListObjectVersion(obj *blob.ListObject) (*ObjectVersion, bool) {
+	var item azblob.BlobItemInternal
+	if !obj.As(&item) {
+		return nil, false
+	}
+	v := &ObjectVersion{Deleted: item.Deleted}
+	if item.VersionID == nil {
+		v.IsCurrent = !item.Deleted
+	} else {
+		// Azure only sets IsCurrentVersion for the current version.
+		v.VersionID = *item.VersionID
+		v.IsCurrent = item.IsCurrentVersion != nil && *item.IsCurrentVersion && !item.Deleted
+	}
+	return v, true
+}
+
+// PromoteVersion makes the version versionID of the blob at key its current
+// version, rolling back any later changes, by copying the version over the
+// blob server-side. The blob's later versions are kept as previous versions,
+// so the rollback can itself be undone.
+//
+// It works even if the blob has been deleted, restoring it.
+func PromoteVersion(ctx context.Context, b *blob.Bucket, key, versionID string) error {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return err
+	}
+	blobURL := drv.containerURL.NewBlobURL(drv.blobName(key))
+	srcURL := blobURL.WithVersionID(versionID).URL()
+	resp, err := blobURL.StartCopyFromURL(ctx, srcURL, nil, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{}, azblob.AccessTierNone, nil /* BlobTagsMap */)
+	if err != nil {
+		return drv.wrapError(err, key)
+	}
+	return drv.wrapError(waitForCopy(ctx, blobURL, resp.CopyStatus(), defaultCopyPollInterval, nil), key)
+}
+
+// SoftDeleteInfo describes a soft-deleted blob.
+type SoftDeleteInfo struct {
+	// DeletedTime is when the blob was deleted.
+	DeletedTime time.Time
+	// RemainingRetentionDays is the number of days until Azure permanently
+	// deletes the blob, after which it can no longer be undeleted.
+	RemainingRetentionDays int
+}
+
+// SoftDeleted reports whether there is a soft-deleted blob at key, for
+// accounts with soft delete enabled, and if so, when it was deleted and how
+// long it can still be undeleted. It returns false if the blob was never
+// deleted, if it was deleted permanently, or if it has been recreated since
+// it was deleted. Deleted snapshots and versions of the blob are ignored.
+func SoftDeleted(ctx context.Context, b *blob.Bucket, key string) (*SoftDeleteInfo, bool, error) {
+	drv, err := driverBucket(b)
+	if err != nil {
+		return nil, false, err
+	}
+	name := drv.blobName(key)
+	opts := azblob.ListBlobsSegmentOptions{
+		Prefix:  name,
+		Details: azblob.BlobListingDetails{Deleted: true},
+	}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := drv.containerURL.ListBlobsFlatSegment(ctx, marker, opts)
+		if err != nil {
+			return nil, false, drv.wrapError(err, key)
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name != name || !item.Deleted || item.Snapshot != "" || item.VersionID != nil {
+				continue
+			}
+			info := &SoftDeleteInfo{}
+			if item.Properties.DeletedTime != nil {
+				info.DeletedTime = *item.Properties.DeletedTime
+			}
+			if item.Properties.RemainingRetentionDays != nil {
+				info.RemainingRetentionDays = int(*item.Properties.RemainingRetentionDays)
+			}
+			return info, true, nil
+		}
+		marker = resp.NextMarker
+	}
+	return nil, false, nil
+}
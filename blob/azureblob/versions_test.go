@@ -0,0 +1,150 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+)
+
+func TestListObjectVersion(t *testing.T) {
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Query().Get("include"), "deleted,versions"; got != want {
+			t.Errorf("got include=%q want %q", got, want)
+		}
+		const props = `<Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified><Content-Length>1</Content-Length></Properties>`
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`+
+			`<Blob><Name>a</Name><VersionId>v1</VersionId>`+props+`</Blob>`+
+			`<Blob><Name>a</Name><VersionId>v2</VersionId><IsCurrentVersion>true</IsCurrentVersion>`+props+`</Blob>`+
+			`<Blob><Name>b</Name><VersionId>v1</VersionId>`+props+`</Blob>`+
+			`<Blob><Name>c</Name><Deleted>true</Deleted>`+props+`</Blob>`+
+			`<Blob><Name>d</Name>`+props+`</Blob>`+
+			`</Blobs><NextMarker /></EnumerationResults>`)
+	})
+	defer done()
+
+	beforeList := func(asFunc func(interface{}) bool) error {
+		if err := IncludeVersions(asFunc); err != nil {
+			return err
+		}
+		return IncludeDeleted(asFunc)
+	}
+	var got []string
+	iter := b.List(&blob.ListOptions{BeforeList: beforeList})
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok := ListObjectVersion(obj)
+		if !ok {
+			t.Fatalf("ListObjectVersion failed for %q", obj.Key)
+		}
+		got = append(got, fmt.Sprintf("%s %q current=%v deleted=%v", obj.Key, v.VersionID, v.IsCurrent, v.Deleted))
+	}
+	want := []string{
+		`a "v1" current=false deleted=false`,
+		`a "v2" current=true deleted=false`,
+		`b "v1" current=false deleted=false`,
+		`c "" current=false deleted=true`,
+		`d "" current=true deleted=false`,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ListObjectVersion diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestPromoteVersion(t *testing.T) {
+	var gotMethod, gotPath, gotSource string
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotSource = r.Method, r.URL.Path, r.Header.Get("x-ms-copy-source")
+		w.Header().Set("x-ms-copy-status", "success")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	defer done()
+
+	if err := PromoteVersion(context.Background(), b, "dir/my-key", "2021-03-01T00:00:00.0000000Z"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/gocloudblobtests/mycontainer/dir/my-key"; gotMethod != http.MethodPut || gotPath != want {
+		t.Errorf("got %s %s want PUT %s", gotMethod, gotPath, want)
+	}
+	src, err := url.Parse(gotSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Path != gotPath || src.Query().Get("versionid") != "2021-03-01T00:00:00.0000000Z" {
+		t.Errorf("got copy source %q", gotSource)
+	}
+}
+
+func TestSoftDeleted(t *testing.T) {
+	const item = `<Blob><Name>%s</Name>%s<Deleted>%v</Deleted><Properties><Last-Modified>Mon, 01 Mar 2021 00:00:00 GMT</Last-Modified>` +
+		`<DeletedTime>Tue, 02 Mar 2021 00:00:00 GMT</DeletedTime><RemainingRetentionDays>%d</RemainingRetentionDays></Properties></Blob>`
+	tests := []struct {
+		description string
+		items       []string
+		want        *SoftDeleteInfo
+	}{
+		{description: "never deleted", items: []string{fmt.Sprintf(item, "my-key", "", false, 0)}},
+		{
+			description: "deleted",
+			items: []string{
+				fmt.Sprintf(item, "my-key", "<Snapshot>2021-03-01T00:00:00.0000000Z</Snapshot>", true, 1),
+				fmt.Sprintf(item, "my-key", "", true, 5),
+				fmt.Sprintf(item, "my-key-2", "", true, 3),
+			},
+			want: &SoftDeleteInfo{DeletedTime: time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), RemainingRetentionDays: 5},
+		},
+		{description: "other blob deleted", items: []string{fmt.Sprintf(item, "my-key-2", "", true, 3)}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				if q := r.URL.Query(); q.Get("include") != "deleted" || q.Get("prefix") != "my-key" {
+					t.Errorf("got query %q", r.URL.RawQuery)
+				}
+				fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+				for _, item := range test.items {
+					fmt.Fprint(w, item)
+				}
+				fmt.Fprint(w, `</Blobs><NextMarker /></EnumerationResults>`)
+			})
+			defer done()
+			got, ok, err := SoftDeleted(context.Background(), b, "my-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (test.want != nil) {
+				t.Errorf("got ok %v want %v", ok, test.want != nil)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
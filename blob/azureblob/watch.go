@@ -0,0 +1,163 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const defaultWatchInterval = 30 * time.Second
+
+// WatchOptions sets options for Watch and WatchPrefix.
+type WatchOptions struct {
+	// Interval is the time between polls. Defaults to 30 seconds.
+	Interval time.Duration
+}
+
+// ChangeEvent describes a change to a blob detected by Watch or WatchPrefix.
+type ChangeEvent struct {
+	// Key is the key of the blob that changed.
+	Key string
+	// Deleted is true if the blob no longer exists. The fields below are
+	// only set if Deleted is false.
+	Deleted bool
+	// ETag is the ETag of the blob after the change.
+	ETag string
+	// ModTime is the time the blob was last modified.
+	ModTime time.Time
+	// Size is the size of the blob in bytes.
+	Size int64
+}
+
+// Watch polls the blob at key every opts.Interval and calls fn when it is
+// created, modified, or deleted, until ctx is done or fn returns an error.
+// Changes are detected by ETag, using a conditional request so that an
+// unchanged blob costs a single empty response per poll.
+// fn is not called for the state of the blob when Watch starts.
+//
+// Watch returns the error from fn, the first error from polling, or
+// ctx.Err(). opts may be nil.
+func Watch(ctx context.Context, b *blob.Bucket, key string, opts *WatchOptions, fn func(*ChangeEvent) error) error {
+	ticker := time.NewTicker(watchInterval(opts))
+	defer ticker.Stop()
+
+	var etag string // empty if the blob doesn't exist
+	for first := true; ; first = false {
+		var attrs *blob.Attributes
+		var err error
+		if etag == "" {
+			attrs, err = b.Attributes(ctx, key)
+		} else {
+			attrs, err = AttributesIfNoneMatch(ctx, b, key, etag)
+		}
+		var event *ChangeEvent
+		switch {
+		case errors.Is(err, ErrNotModified):
+		case gcerrors.Code(err) == gcerrors.NotFound:
+			if etag != "" {
+				event = &ChangeEvent{Key: key, Deleted: true}
+			}
+			etag = ""
+		case err != nil:
+			return err
+		default:
+			event = &ChangeEvent{Key: key, ETag: attrs.ETag, ModTime: attrs.ModTime, Size: attrs.Size}
+			etag = attrs.ETag
+		}
+		if event != nil && !first {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WatchPrefix is like Watch, but watches all of the blobs whose keys start
+// with prefix. Each poll lists the blobs under prefix, so the cost of a poll
+// grows with the number of blobs.
+func WatchPrefix(ctx context.Context, b *blob.Bucket, prefix string, opts *WatchOptions, fn func(*ChangeEvent) error) error {
+	ticker := time.NewTicker(watchInterval(opts))
+	defer ticker.Stop()
+
+	var prev map[string]*ChangeEvent
+	for {
+		cur, err := listForWatch(ctx, b, prefix)
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			for key, e := range cur {
+				if p, ok := prev[key]; !ok || p.ETag != e.ETag {
+					if err := fn(e); err != nil {
+						return err
+					}
+				}
+			}
+			for key := range prev {
+				if _, ok := cur[key]; !ok {
+					if err := fn(&ChangeEvent{Key: key, Deleted: true}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		prev = cur
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// listForWatch returns the current state of the blobs under prefix, by key.
+func listForWatch(ctx context.Context, b *blob.Bucket, prefix string) (map[string]*ChangeEvent, error) {
+	state := map[string]*ChangeEvent{}
+	iter := b.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return state, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var item azblob.BlobItemInternal
+		if !obj.As(&item) {
+			return nil, fmt.Errorf("azureblob: WatchPrefix: unexpected list object for %q", obj.Key)
+		}
+		state[obj.Key] = &ChangeEvent{Key: obj.Key, ETag: string(item.Properties.Etag), ModTime: obj.ModTime, Size: obj.Size}
+	}
+}
+
+func watchInterval(opts *WatchOptions) time.Duration {
+	if opts == nil || opts.Interval <= 0 {
+		return defaultWatchInterval
+	}
+	return opts.Interval
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var errStopWatch = errors.New("stop watching")
+
+func TestWatch(t *testing.T) {
+	// Each request is served the next state in the list; "" means the blob
+	// doesn't exist.
+	states := []string{`"1"`, `"1"`, `"2"`, "", "", `"3"`}
+	var mu sync.Mutex
+	n := 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		etag := states[len(states)-1]
+		if n < len(states) {
+			etag = states[n]
+		}
+		n++
+		switch {
+		case etag == "":
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case r.Header.Get("If-None-Match") == etag:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", etag)
+		}
+	})
+	defer done()
+
+	var got []string
+	err := Watch(context.Background(), b, "my-key", &WatchOptions{Interval: time.Millisecond}, func(e *ChangeEvent) error {
+		if e.Deleted {
+			got = append(got, "deleted")
+		} else {
+			got = append(got, e.ETag)
+		}
+		if len(got) == 3 {
+			return errStopWatch
+		}
+		return nil
+	})
+	if err != errStopWatch {
+		t.Fatalf("got error %v want %v", err, errStopWatch)
+	}
+	if want := []string{`"2"`, "deleted", `"3"`}; !cmp.Equal(got, want) {
+		t.Errorf("got events %v want %v", got, want)
+	}
+}
+
+func TestWatchPrefix(t *testing.T) {
+	// Each request is served the next listing, as key->ETag.
+	listings := []map[string]string{
+		{"dir/a": "1", "dir/b": "1"},
+		{"dir/a": "1", "dir/b": "2", "dir/c": "1"},
+		{"dir/b": "2", "dir/c": "1"},
+	}
+	var mu sync.Mutex
+	n := 0
+	b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		listing := listings[len(listings)-1]
+		if n < len(listings) {
+			listing = listings[n]
+		}
+		n++
+		writeListResponse(w, listing)
+	})
+	defer done()
+
+	var got []string
+	err := WatchPrefix(context.Background(), b, "dir/", &WatchOptions{Interval: time.Millisecond}, func(e *ChangeEvent) error {
+		if e.Deleted {
+			got = append(got, e.Key+" deleted")
+		} else {
+			got = append(got, e.Key+" "+e.ETag)
+		}
+		if len(got) == 3 {
+			return errStopWatch
+		}
+		return nil
+	})
+	if err != errStopWatch {
+		t.Fatalf("got error %v want %v", err, errStopWatch)
+	}
+	sort.Strings(got[:2]) // changes within a poll are unordered
+	if want := []string{"dir/b 2", "dir/c 1", "dir/a deleted"}; !cmp.Equal(got, want) {
+		t.Errorf("got events %v want %v", got, want)
+	}
+}
+
+// writeListResponse writes a List Blobs response for blobs, a map from blob
+// name to ETag.
+func writeListResponse(w http.ResponseWriter, blobs map[string]string) {
+	var names []string
+	for name := range blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="mycontainer"><Blobs>`)
+	for _, name := range names {
+		fmt.Fprintf(&sb, `<Blob><Name>%s</Name><Properties><Last-Modified>Mon, 03 May 2021 00:00:00 GMT</Last-Modified><Etag>%s</Etag><Content-Length>5</Content-Length></Properties></Blob>`, name, blobs[name])
+	}
+	sb.WriteString(`</Blobs><NextMarker /></EnumerationResults>`)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, sb.String())
+}
@@ -0,0 +1,429 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+// WriteOptions sets Azure-specific options for writes. To use them, set
+// blob.WriterOptions.BeforeWrite to the BeforeWrite method:
+//
+//   opts := &azureblob.WriteOptions{IfNotExists: true}
+//   w, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{BeforeWrite: opts.BeforeWrite})
+type WriteOptions struct {
+	// IfNotExists makes the write fail if the blob already exists, with an
+	// error whose code is gcerrors.AlreadyExists; the existing blob is left
+	// unchanged.
+	IfNotExists bool
+
+	// IfMatch, if set, makes the write fail unless the ETag of the existing
+	// blob matches it, with an error whose code is
+	// gcerrors.FailedPrecondition.
+	IfMatch string
+
+	// Tags are index tags to set on the blob. They are set in the same
+	// request that commits the blob's content, so the blob is never visible
+	// without them.
+	Tags map[string]string
+}
+
+// BeforeWrite applies o to a write; see WriteOptions.
+func (o *WriteOptions) BeforeWrite(asFunc func(interface{}) bool) error {
+	var opts *azblob.UploadStreamToBlockBlobOptions
+	if !asFunc(&opts) {
+		return errors.New("azureblob: WriteOptions can only be used with azureblob buckets")
+	}
+	if o.IfNotExists {
+		opts.AccessConditions.ModifiedAccessConditions.IfNoneMatch = azblob.ETagAny
+	}
+	if o.IfMatch != "" {
+		opts.AccessConditions.ModifiedAccessConditions.IfMatch = azblob.ETag(o.IfMatch)
+	}
+	if len(o.Tags) > 0 {
+		opts.BlobTagsMap = o.Tags
+	}
+	return nil
+}
+
+// ErrBlobTypeMismatch is returned (possibly wrapped) by writes using
+// BlobTypeCheck when the blob they would replace is an append or page blob.
+// Use errors.Is to detect it.
+// Its error code is gcerrors.FailedPrecondition.
+var ErrBlobTypeMismatch = errors.New("azureblob: existing blob is not a block blob")
+
+// BlobTypeCheck makes a write check the type of the blob it replaces before
+// uploading, since writers only write block blobs. If the existing blob is
+// an append or page blob, the write fails with ErrBlobTypeMismatch, or, if
+// Replace is true, deletes the blob and its snapshots and writes a block
+// blob in its place. To use it, set blob.WriterOptions.BeforeWrite to the
+// BeforeWrite method:
+//
+//   check := &azureblob.BlobTypeCheck{Replace: true}
+//   w, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{BeforeWrite: check.BeforeWrite})
+//
+// Without the check, such writes fail while uploading with an error whose
+// code is also gcerrors.FailedPrecondition, but that doesn't say why. The
+// check costs a request per write.
+type BlobTypeCheck struct {
+	// Replace makes the write replace an append or page blob instead of
+	// failing.
+	Replace bool
+}
+
+// BeforeWrite applies c to a write; see BlobTypeCheck.
+func (c *BlobTypeCheck) BeforeWrite(asFunc func(interface{}) bool) error {
+	var s *writeSettings
+	if !asFunc(&s) {
+		return errors.New("azureblob: BlobTypeCheck can only be used with azureblob buckets")
+	}
+	s.checkType = true
+	s.replaceType = c.Replace
+	return nil
+}
+
+// checkBlobType returns an error wrapping ErrBlobTypeMismatch if the blob at
+// blobURL exists and isn't a block blob, unless replace is set, in which case
+// it deletes it.
+func (b *bucket) checkBlobType(ctx context.Context, blobURL azblob.BlobURL, replace bool) error {
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		if b.ErrorCode(err) == gcerrors.NotFound {
+			return nil
+		}
+		return err
+	}
+	typ := props.BlobType()
+	if typ == azblob.BlobBlockBlob {
+		return nil
+	}
+	if !replace {
+		return fmt.Errorf("%w: it is a %s", ErrBlobTypeMismatch, typ)
+	}
+	// Only delete the blob that was checked.
+	ac := azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: props.ETag()}}
+	if _, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, ac); err != nil && b.ErrorCode(err) != gcerrors.NotFound {
+		return err
+	}
+	return nil
+}
+
+// writeSettings holds the settings of a write that have no field in
+// azblob.UploadStreamToBlockBlobOptions. Options like UploadSize set them
+// through the As function passed to blob.WriterOptions.BeforeWrite.
+type writeSettings struct {
+	size        int64 // the size declared with UploadSize, or -1
+	checkType   bool  // set by BlobTypeCheck
+	replaceType bool  // BlobTypeCheck.Replace
+	progress    UploadProgress
+}
+
+// UploadSize declares that a write writes exactly that many bytes, e.g. when
+// copying a file of known length. A write of at most
+// blob.WriterOptions.BufferSize bytes (the size of uploaded blocks) is then
+// buffered in full and uploaded with a single request, instead of staging a
+// block and committing it with a second one. To use it, set
+// blob.WriterOptions.BeforeWrite to the BeforeWrite method:
+//
+//   opts := &blob.WriterOptions{BeforeWrite: azureblob.UploadSize(len(data)).BeforeWrite}
+//   w, err := bucket.NewWriter(ctx, key, opts)
+//
+// If more or fewer bytes are written, the write fails with an error whose
+// code is gcerrors.InvalidArgument, and the blob is left unchanged.
+type UploadSize int64
+
+// BeforeWrite applies n to a write; see UploadSize.
+func (n UploadSize) BeforeWrite(asFunc func(interface{}) bool) error {
+	if n < 0 {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: negative UploadSize %d", n)
+	}
+	var s *writeSettings
+	if !asFunc(&s) {
+		return errors.New("azureblob: UploadSize can only be used with azureblob buckets")
+	}
+	s.size = int64(n)
+	return nil
+}
+
+// sizeCheckReader reads from r, failing if r doesn't have exactly size
+// bytes.
+type sizeCheckReader struct {
+	r    io.Reader
+	size int64
+	read int64
+}
+
+func (r *sizeCheckReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	switch {
+	case r.read > r.size:
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: wrote more than the %d bytes declared with UploadSize", r.size)
+	case err == io.EOF && r.read < r.size:
+		return n, gcerr.Newf(gcerr.InvalidArgument, nil, "azureblob: wrote %d bytes, less than the %d declared with UploadSize", r.read, r.size)
+	}
+	return n, err
+}
+
+// uploadSingle uploads the size bytes read from body with a single Put Blob
+// request.
+func (w *writer) uploadSingle(body io.Reader, size int64) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return err
+	}
+	// Fail if there is more data.
+	var extra [1]byte
+	if _, err := io.ReadFull(body, extra[:]); err != io.EOF {
+		return err
+	}
+	o := w.uploadOpts
+	_, err := w.blockBlobURL.Upload(w.ctx, bytes.NewReader(data), o.BlobHTTPHeaders, o.Metadata, o.AccessConditions, o.BlobAccessTier, o.BlobTagsMap, o.ClientProvidedKeyOptions)
+	return err
+}
+
+// bufferedPipe buffers up to size bytes of the data written to it and
+// writes them to pw from a separate goroutine, so that Write only blocks
+// while the buffer is full. See Options.WriteBufferSize.
+type bufferedPipe struct {
+	pw    *io.PipeWriter
+	donec chan struct{} // closed when the goroutine has stopped
+
+	mu     sync.Mutex
+	cond   *sync.Cond // signaled when buf, closed, or err change
+	buf    []byte     // data not yet written to pw; never reallocated
+	closed bool
+	err    error // the error writing to pw, if any
+}
+
+func newBufferedPipe(pw *io.PipeWriter, size int) *bufferedPipe {
+	p := &bufferedPipe{
+		pw:    pw,
+		donec: make(chan struct{}),
+		buf:   make([]byte, 0, size),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.drain()
+	return p
+}
+
+// Write copies data into the buffer, waiting for room when it is full.
+func (p *bufferedPipe) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for len(data) > 0 {
+		for len(p.buf) == cap(p.buf) && p.err == nil {
+			p.cond.Wait()
+		}
+		if p.err != nil {
+			return n, p.err
+		}
+		k := cap(p.buf) - len(p.buf)
+		if k > len(data) {
+			k = len(data)
+		}
+		p.buf = append(p.buf, data[:k]...)
+		data = data[k:]
+		n += k
+		p.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// Close waits for the buffered data to be written and closes pw.
+func (p *bufferedPipe) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	<-p.donec
+	if p.err != nil {
+		return p.err
+	}
+	return p.pw.Close()
+}
+
+// drain writes the buffered data to pw until p is closed or writing fails.
+// Data stays in the buffer until pw has taken it, so that no more than the
+// buffer's size is held.
+func (p *bufferedPipe) drain() {
+	defer close(p.donec)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for len(p.buf) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.buf) == 0 {
+			return
+		}
+		// Write only appends after data, so it can be read without the lock.
+		data := p.buf
+		p.mu.Unlock()
+		_, err := p.pw.Write(data)
+		p.mu.Lock()
+		if err != nil {
+			p.err = err
+			p.cond.Broadcast()
+			return
+		}
+		p.buf = p.buf[:copy(p.buf, p.buf[len(data):])]
+		p.cond.Broadcast()
+	}
+}
+
+// UploadProgress is called with the number of bytes of a blob uploaded so
+// far, each time Azure acknowledges a block, or the whole blob for writes
+// uploaded with a single request. Progress is therefore reported in steps of
+// the block size (see Options.UploadBlockSize), which is the granularity at
+// which data is actually flushed to Azure, regardless of the size of the
+// calls to Write. To use it, set blob.WriterOptions.BeforeWrite to the
+// BeforeWrite method:
+//
+//   progress := azureblob.UploadProgress(func(uploaded int64) { ... })
+//   w, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{BeforeWrite: progress.BeforeWrite})
+//
+// Calls are serialized, but may be made from goroutines other than the one
+// writing. The function should return quickly, as it delays the upload.
+type UploadProgress func(uploaded int64)
+
+// BeforeWrite applies fn to a write; see UploadProgress.
+func (fn UploadProgress) BeforeWrite(asFunc func(interface{}) bool) error {
+	var s *writeSettings
+	if !asFunc(&s) {
+		return errors.New("azureblob: UploadProgress can only be used with azureblob buckets")
+	}
+	s.progress = fn
+	return nil
+}
+
+// uploadProgressStateKey is the context key of the *uploadProgressState of
+// a writer, set by NewTypedWriter for writes with UploadProgress. The
+// requests of the writer carry it to headerPipeline, which reports their
+// progress.
+type uploadProgressStateKey struct{}
+
+// uploadProgressState counts the bytes uploaded by a writer.
+type uploadProgressState struct {
+	fn UploadProgress
+
+	mu       sync.Mutex
+	uploaded int64
+}
+
+// withUploadProgressState returns a context carrying a new
+// uploadProgressState reporting to fn if fn is not nil, and ctx otherwise.
+func withUploadProgressState(ctx context.Context, fn UploadProgress) context.Context {
+	if fn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, uploadProgressStateKey{}, &uploadProgressState{fn: fn})
+}
+
+// add records that n more bytes were uploaded and reports the total.
+func (p *uploadProgressState) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.uploaded += n
+	p.fn(p.uploaded)
+}
+
+// isUploadRequest reports whether request uploads blob data, i.e. is a Put
+// Block request or a Put Blob request for a block blob.
+func isUploadRequest(request pipeline.Request) bool {
+	if request.Method != http.MethodPut {
+		return false
+	}
+	q := request.URL.Query()
+	if q.Get("restype") != "" {
+		return false
+	}
+	switch q.Get("comp") {
+	case "block":
+		return true
+	case "":
+		return request.Header.Get("x-ms-blob-type") == string(azblob.BlobBlockBlob)
+	}
+	return false
+}
+
+// WriteAllIdempotent writes data to the blob at key if it doesn't exist, like
+// b.WriteAll with WriteOptions.IfNotExists, but also succeeds without writing
+// if the blob exists with the same content. This makes writes of the same
+// data to a deterministic key safe to repeat, as when a producer with
+// at-least-once delivery writes each event to its own key.
+//
+// The contents are compared by MD5, which is set on the written blob. If the
+// existing blob has no MD5, its content is read to compute it. If the
+// contents differ, WriteAllIdempotent returns an error whose code is
+// gcerrors.AlreadyExists.
+//
+// To fail whenever the blob exists instead, use WriteOptions.IfNotExists.
+// opts may be nil; its ContentMD5 is ignored.
+func WriteAllIdempotent(ctx context.Context, b *blob.Bucket, key string, data []byte, opts *blob.WriterOptions) error {
+	if _, err := driverBucket(b); err != nil {
+		return err
+	}
+	sum := md5.Sum(data)
+	var o blob.WriterOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.ContentMD5 = sum[:]
+	err := b.WriteAll(ctx, key, data, conditionalWriterOptions(&o, azblob.ETagNone))
+	if gcerrors.Code(err) != gcerrors.AlreadyExists {
+		return err
+	}
+	existing, mdErr := existingMD5(ctx, b, key)
+	if mdErr != nil {
+		return mdErr
+	}
+	if bytes.Equal(existing, sum[:]) {
+		return nil
+	}
+	return err
+}
+
+// existingMD5 returns the MD5 of the blob at key, reading its content if
+// Azure doesn't have it.
+func existingMD5(ctx context.Context, b *blob.Bucket, key string) ([]byte, error) {
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs.MD5) > 0 {
+		return attrs.MD5, nil
+	}
+	data, err := b.ReadAll(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(data)
+	return sum[:], nil
+}
@@ -0,0 +1,324 @@
+// Copyright 2021 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestWriteOptions(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	opts := &WriteOptions{IfNotExists: true, Tags: map[string]string{"class": "a b"}}
+	wopts := &blob.WriterOptions{BeforeWrite: opts.BeforeWrite}
+	if err := b.WriteAll(ctx, "my-key", []byte("hello"), wopts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fake.tags["my-key"], "class=a+b"; got != want {
+		t.Errorf("got x-ms-tags %q want %q", got, want)
+	}
+
+	err := b.WriteAll(ctx, "my-key", []byte("world"), wopts)
+	if gcerrors.Code(err) != gcerrors.AlreadyExists {
+		t.Errorf("got error %v want AlreadyExists", err)
+	}
+	if got := string(fake.content("my-key")); got != "hello" {
+		t.Errorf("got content %q want %q", got, "hello")
+	}
+}
+
+func TestWriteAllIdempotent(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeBlockBlobs()
+	b, done := newTestBucket(t, nil, fake.ServeHTTP)
+	defer done()
+
+	if err := WriteAllIdempotent(ctx, b, "my-key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Repeating the write succeeds without writing.
+	if err := WriteAllIdempotent(ctx, b, "my-key", []byte("hello"), nil); err != nil {
+		t.Errorf("got error %v for identical write, want nil", err)
+	}
+	if got := fake.versions["my-key"]; got != 1 {
+		t.Errorf("got %d commits want 1", got)
+	}
+	// Different content conflicts.
+	err := WriteAllIdempotent(ctx, b, "my-key", []byte("world"), nil)
+	if gcerrors.Code(err) != gcerrors.AlreadyExists {
+		t.Errorf("got error %v for different write, want AlreadyExists", err)
+	}
+
+	// Blobs written without an MD5 are compared by content.
+	if err := b.WriteAll(ctx, "no-md5", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteAllIdempotent(ctx, b, "no-md5", []byte("hello"), nil); err != nil {
+		t.Errorf("got error %v for identical write without MD5, want nil", err)
+	}
+	err = WriteAllIdempotent(ctx, b, "no-md5", []byte("world"), nil)
+	if gcerrors.Code(err) != gcerrors.AlreadyExists {
+		t.Errorf("got error %v for different write without MD5, want AlreadyExists", err)
+	}
+	if got := string(fake.content("no-md5")); got != "hello" {
+		t.Errorf("got content %q want %q", got, "hello")
+	}
+}
+
+func TestBlobTypeCheck(t *testing.T) {
+	tests := []struct {
+		description string
+		existing    string // x-ms-blob-type of the existing blob, if any
+		replace     bool
+		wantDelete  bool
+		wantErr     error
+	}{
+		{description: "no blob"},
+		{description: "block blob", existing: "BlockBlob"},
+		{description: "page blob", existing: "PageBlob", wantErr: ErrBlobTypeMismatch},
+		{description: "replace page blob", existing: "PageBlob", replace: true, wantDelete: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var gotDelete, gotWrite bool
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodHead:
+					if test.existing == "" {
+						w.Header().Set("x-ms-error-code", "BlobNotFound")
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					w.Header().Set("x-ms-blob-type", test.existing)
+					w.Header().Set("ETag", `"1"`)
+				case http.MethodDelete:
+					if got := r.Header.Get("If-Match"); got != `"1"` {
+						t.Errorf("got If-Match %q want %q", got, `"1"`)
+					}
+					gotDelete = true
+					w.WriteHeader(http.StatusAccepted)
+				case http.MethodPut:
+					gotWrite = true
+					w.WriteHeader(http.StatusCreated)
+				}
+			})
+			defer done()
+			check := &BlobTypeCheck{Replace: test.replace}
+			err := b.WriteAll(context.Background(), "my-key", []byte("hello"), &blob.WriterOptions{BeforeWrite: check.BeforeWrite})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v want %v", err, test.wantErr)
+			}
+			if err != nil {
+				if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+					t.Errorf("got code %v want FailedPrecondition", gcerrors.Code(err))
+				}
+				if gotWrite {
+					t.Error("blob was written despite the mismatch")
+				}
+				return
+			}
+			if !gotWrite {
+				t.Error("blob was not written")
+			}
+			if gotDelete != test.wantDelete {
+				t.Errorf("got delete %v want %v", gotDelete, test.wantDelete)
+			}
+		})
+	}
+}
+
+func TestUploadSize(t *testing.T) {
+	// The default block size is 8 MiB.
+	const blockSize = 8 * 1024 * 1024
+	tests := []struct {
+		description string
+		size        int64
+		data        []byte
+		wantComps   []string // comp parameters of the requests
+		wantErr     gcerrors.ErrorCode
+	}{
+		{description: "undeclared", data: []byte("hello"), wantComps: []string{"block", "blocklist"}},
+		{description: "small", size: 5, data: []byte("hello"), wantComps: []string{""}},
+		{description: "empty", size: 0, data: nil, wantComps: []string{""}},
+		{description: "larger than a block", size: blockSize + 1, data: make([]byte, blockSize+1), wantComps: []string{"block", "block", "blocklist"}},
+		{description: "too much data", size: 4, data: []byte("hello"), wantErr: gcerrors.InvalidArgument},
+		{description: "too little data", size: 6, data: []byte("hello"), wantErr: gcerrors.InvalidArgument},
+		{description: "too little data for a block", size: blockSize + 1, data: []byte("hello"), wantErr: gcerrors.InvalidArgument},
+		{description: "negative", size: -1, data: []byte("hello"), wantErr: gcerrors.InvalidArgument},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			var gotComps []string
+			b, done := newTestBucket(t, nil, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				gotComps = append(gotComps, r.URL.Query().Get("comp"))
+				mu.Unlock()
+				ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+			})
+			defer done()
+			opts := &blob.WriterOptions{ContentType: "text/plain"}
+			if test.description != "undeclared" {
+				opts.BeforeWrite = UploadSize(test.size).BeforeWrite
+			}
+			w, err := b.NewWriter(context.Background(), "my-key", opts)
+			if err == nil {
+				_, err = w.Write(test.data)
+				if cerr := w.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if got := gcerrors.Code(err); got != test.wantErr {
+				t.Fatalf("got error %v want code %v", err, test.wantErr)
+			}
+			if err != nil {
+				for _, comp := range gotComps {
+					if comp != "block" {
+						t.Errorf("got a request with comp=%q after a size mismatch", comp)
+					}
+				}
+				return
+			}
+			if !cmp.Equal(gotComps, test.wantComps) {
+				t.Errorf("got requests with comp %q want %q", gotComps, test.wantComps)
+			}
+		})
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	const blockSize = 1024 * 1024 // azblob uploads blocks of at least 1 MiB
+	tests := []struct {
+		description string
+		size        int
+		uploadSize  bool // use UploadSize to upload with a single request
+		want        []int64
+	}{
+		{description: "blocks", size: 2*blockSize + 452, want: []int64{blockSize, 2 * blockSize, 2*blockSize + 452}},
+		{description: "single request", size: 452, uploadSize: true, want: []int64{452}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.description, func(t *testing.T) {
+			// A single upload buffer makes the blocks upload in order.
+			b, done := newTestBucket(t, &Options{UploadBlockSize: blockSize, UploadBuffers: 1}, func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+			})
+			defer done()
+
+			var got []int64
+			progress := UploadProgress(func(uploaded int64) {
+				got = append(got, uploaded)
+			})
+			opts := &blob.WriterOptions{BeforeWrite: progress.BeforeWrite}
+			if test.uploadSize {
+				opts.BeforeWrite = func(asFunc func(interface{}) bool) error {
+					if err := progress.BeforeWrite(asFunc); err != nil {
+						return err
+					}
+					return UploadSize(test.size).BeforeWrite(asFunc)
+				}
+			}
+			w, err := b.NewWriter(context.Background(), "my-key", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(bytes.Repeat([]byte("a"), test.size)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("got progress %v want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBufferedPipe(t *testing.T) {
+	pr, pw := io.Pipe()
+	p := newBufferedPipe(pw, 4)
+
+	// Writes that fit in the buffer return before the data is read, and
+	// the data is handed over without waiting for more writes.
+	if _, err := p.Write([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Write([]byte("cd")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(pr, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcd" {
+		t.Errorf("got %q want %q", got, "abcd")
+	}
+
+	// A write larger than the buffer waits for the reader.
+	wrote := make(chan error)
+	go func() {
+		_, err := p.Write([]byte("efghij"))
+		if err == nil {
+			err = p.Close()
+		}
+		wrote <- err
+	}()
+	rest, err := ioutil.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "efghij" {
+		t.Errorf("got %q want %q", rest, "efghij")
+	}
+	if err := <-wrote; err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors from the reader are returned by later writes.
+	pr, pw = io.Pipe()
+	p = newBufferedPipe(pw, 4)
+	pr.CloseWithError(errors.New("upload failed"))
+	for i := 0; ; i++ {
+		if _, err := p.Write([]byte("abcd")); err != nil {
+			break
+		}
+		if i == 3 {
+			t.Fatal("got no error writing after the reader failed")
+		}
+	}
+	if err := p.Close(); err == nil {
+		t.Error("got nil error from Close after the reader failed")
+	}
+}